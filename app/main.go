@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/codecrafters-io/http-server-starter-go/internal/application"
 	"github.com/codecrafters-io/http-server-starter-go/internal/router"
@@ -12,12 +15,116 @@ import (
 
 func main() {
 	directory := flag.String("directory", "/tmp", "Directory where files are stored")
+	listing := flag.Bool("listing", false, "List directories without an index file")
+	tlsCert := flag.String("tls-cert", "", "Comma separated certificate files, enables HTTPS")
+	tlsKey := flag.String("tls-key", "", "Comma separated key files, one per certificate")
+	selfSigned := flag.String("tls-self-signed", "", "Comma separated hosts to generate a self-signed certificate for, enables HTTPS")
+	clientCA := flag.String("tls-client-ca", "", "PEM bundle of the authorities client certificates are verified against")
+	clientAuth := flag.String("tls-client-auth", "none", "Client certificate verification: none, request or require")
 	flag.Parse()
 	router := router.NewRouter()
-	application.RegisterControllers(router, directory)
+	if err := application.RegisterControllers(router, directory, *listing); err != nil {
+		fmt.Printf("[ERROR] %v\n", err)
+		os.Exit(1)
+	}
+
+	if flag.Arg(0) == "routes" {
+		if err := printRoutes(router, flag.Args()[1:]); err != nil {
+			fmt.Printf("[ERROR] %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	httpServer := servercore.NewHttpServer(router)
-	if err := httpServer.Listen(4221); err != nil {
+
+	var err error
+	if *tlsCert != "" || *selfSigned != "" {
+		var options servercore.TLSOptions
+		options, err = tlsOptions(*tlsCert, *tlsKey, *selfSigned)
+		if err == nil {
+			options.ClientCAFile = *clientCA
+			options.ClientAuth, err = servercore.ParseClientAuth(*clientAuth)
+		}
+		if err == nil {
+			err = httpServer.ListenTLS(4221, options)
+		}
+	} else {
+		err = httpServer.Listen(4221)
+	}
+
+	if err != nil {
 		fmt.Printf("[ERROR] %v\n", err)
 		os.Exit(1)
 	}
 }
+
+// printRoutes implements the "routes" subcommand, listing the registered
+// routes as a table or as JSON with -json.
+func printRoutes(appRouter router.IRouter, args []string) error {
+	flags := flag.NewFlagSet("routes", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "Print the routes as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	routes := appRouter.Routes()
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(routes)
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "METHOD\tPATTERN\tNAME\tHANDLERS\tMIDDLEWARE")
+	for _, route := range routes {
+		name, middleware := route.Name, strings.Join(route.Middleware, ", ")
+		if name == "" {
+			name = "-"
+		}
+		if middleware == "" {
+			middleware = "-"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", route.Method, route.Pattern, name, strings.Join(route.Handlers, ", "), middleware)
+	}
+	return table.Flush()
+}
+
+func tlsOptions(certFiles, keyFiles, selfSignedHosts string) (servercore.TLSOptions, error) {
+	certs, keys := splitList(certFiles), splitList(keyFiles)
+	if len(certs) != len(keys) {
+		return servercore.TLSOptions{}, fmt.Errorf("got %d certificates but %d keys", len(certs), len(keys))
+	}
+
+	pairs := make([]servercore.CertificatePair, 0, len(certs))
+	for idx := range certs {
+		pairs = append(pairs, servercore.CertificatePair{CertFile: certs[idx], KeyFile: keys[idx]})
+	}
+
+	store, err := servercore.NewCertificateStore(pairs...)
+	if err != nil {
+		return servercore.TLSOptions{}, err
+	}
+
+	if hosts := splitList(selfSignedHosts); len(hosts) > 0 {
+		certificate, err := servercore.GenerateSelfSignedCertificate(hosts...)
+		if err != nil {
+			return servercore.TLSOptions{}, err
+		}
+		if err := store.Add(certificate); err != nil {
+			return servercore.TLSOptions{}, err
+		}
+	}
+
+	return servercore.TLSOptions{Certificates: store}, nil
+}
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
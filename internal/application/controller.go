@@ -1,15 +1,32 @@
 package application
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 
+	"github.com/codecrafters-io/http-server-starter-go/internal/common"
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression"
+	"github.com/codecrafters-io/http-server-starter-go/internal/fileserver"
 	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
 	"github.com/codecrafters-io/http-server-starter-go/internal/router"
 )
 
-func RegisterControllers(appRouter router.IRouter, directory *string) {
+// maxUploadSize bounds a decoded upload
+const maxUploadSize = 64 << 20
+
+// RegisterControllers registers the routes of the application. Files are
+// served from and uploaded to directory, listed only with listing.
+func RegisterControllers(appRouter router.IRouter, directory *string, listing bool) error {
+	// both GET and POST go through the root, so neither a name nor a
+	// symlink leads out of directory. It stays open for the process.
+	root, err := os.OpenRoot(*directory)
+	if err != nil {
+		return err
+	}
+
 	appRouter.Get("/", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
 		w.SetStatus(httpcore.StatusOK)
 	})
@@ -22,59 +39,52 @@ func RegisterControllers(appRouter router.IRouter, directory *string) {
 		w.Write([]byte(value))
 	})
 	appRouter.Get("/user-agent", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
-		value := r.Headers["user-agent"]
+		value := r.Headers.Get("user-agent")
 		w.SetHeader("Content-Type", "text/plain")
 		w.Write([]byte(value))
 	})
 
-	appRouter.Get("/files/:filename", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+	files := appRouter.Group("/files")
+	fileServer := fileserver.NewFileServer(root.FS(), fileserver.Options{Listing: listing})
+	files.Get("/", fileServer.Handler("filename"))
+	files.Named("file", common.GET, "/*filename", fileServer.Handler("filename"))
+
+	// uploads may come compressed, the file is stored decoded
+	files.With(compression.Decompress(nil, maxUploadSize)).Post("/:filename", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		// the same names the file server takes for GET
 		filename, exists := r.PathParams["filename"]
-		if !exists {
+		if !exists || !fs.ValidPath(filename) || filename == "." {
 			w.SetStatus(httpcore.StatusNotFound)
 			return
 		}
-		absolutePath := fmt.Sprintf("%s%s", *directory, filename)
 
-		file, err := os.Open(absolutePath)
+		// a missing parent or a symlink out of the root is not found
+		file, err := root.Create(filename)
 		if err != nil {
-			if os.IsNotExist(err) {
-				w.SetStatus(httpcore.StatusNotFound)
-				return
-			}
-
 			fmt.Println(err)
-			w.SetStatus(httpcore.StatusInternalServerError)
+			w.SetStatus(httpcore.StatusNotFound)
+			if errors.Is(err, fs.ErrPermission) {
+				w.SetStatus(httpcore.StatusInternalServerError)
+			}
 			return
 		}
-
 		defer file.Close()
 
-		content, err := io.ReadAll(file)
-		if err != nil {
+		if _, err := io.Copy(file, r.Body); err != nil {
 			fmt.Println(err)
+			root.Remove(filename)
+			if errors.Is(err, compression.ErrBodyTooLarge) {
+				w.SetStatus(httpcore.StatusPayloadTooLarge)
+				return
+			}
 			w.SetStatus(httpcore.StatusInternalServerError)
 			return
 		}
 
-		w.SetHeader("Content-Type", "application/octet-stream")
-		w.Write(content)
-	})
-
-	appRouter.Post("/files/:filename", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
-		filename, exists := r.PathParams["filename"]
-		if !exists {
-			w.SetStatus(httpcore.StatusNotFound)
-			return
-		}
-		absolutePath := fmt.Sprintf("%s%s", *directory, filename)
-
-		fmt.Println(r.Body)
-
-		if err := os.WriteFile(absolutePath, r.Body, 0644); err != nil {
-			w.SetStatus(httpcore.StatusInternalServerError)
-			return
+		if location, err := appRouter.URL("file", map[string]string{"filename": filename}, nil); err == nil {
+			w.SetHeader("Location", location)
 		}
-
 		w.SetStatus(httpcore.StatusCreated)
 	})
+	return nil
 }
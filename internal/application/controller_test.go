@@ -0,0 +1,143 @@
+package application_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/application"
+	"github.com/codecrafters-io/http-server-starter-go/internal/common"
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+	"github.com/codecrafters-io/http-server-starter-go/internal/router"
+)
+
+// send sends body to target the way the server dispatches it, a nil
+// handler stands for the 404 the server answers.
+func send(t *testing.T, appRouter router.IRouter, method common.Method, target string, params map[string]string, body string) (httpcore.HttpStatus, []byte) {
+	t.Helper()
+	headers := httpcore.HeaderMap{"content-length": {strconv.Itoa(len(body))}}
+	request, err := httpcore.NewRequest(method, target, headers, io.NopCloser(bytes.NewReader([]byte(body))), int64(len(body)))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	handler, pathParams := appRouter.(router.ReadOnlyRouter).GetHandler(request.Method, request.RawPath)
+	if handler == nil {
+		return httpcore.StatusNotFound, nil
+	}
+	request.PathParams = pathParams
+	if params != nil {
+		request.PathParams = params
+	}
+
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	response := httpcore.NewStreamingResponseWriter(httpcore.NewHttp1StreamWriter(writer))
+	handler(*request, &response)
+	if err := response.Finish(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	writer.Flush()
+
+	parsed, err := http.ReadResponse(bufio.NewReader(&buf), &http.Request{Method: string(method)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer parsed.Body.Close()
+	responseBody, _ := io.ReadAll(parsed.Body)
+	return httpcore.HttpStatus(parsed.StatusCode), responseBody
+}
+
+func TestUploadStaysInDirectory(t *testing.T) {
+	root := t.TempDir()
+	directory := filepath.Join(root, "files") + "/"
+	if err := os.Mkdir(directory, 0o755); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := os.Symlink(root, filepath.Join(directory, "up")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	appRouter := router.NewRouter()
+	if err := application.RegisterControllers(appRouter, &directory, false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	testCases := []struct {
+		Name   string
+		Target string
+		Params map[string]string
+		Status httpcore.HttpStatus
+	}{
+		{Name: "Plain name", Target: "/files/upload.txt", Status: httpcore.StatusCreated},
+		// no upload route matches, only the GET catch-all does
+		{Name: "Encoded slash", Target: "/files/..%2Fescaped.txt", Status: httpcore.StatusMethodNotAllowed},
+		{Name: "Encoded dots and slash", Target: "/files/%2E%2E%2Fescaped.txt", Status: httpcore.StatusMethodNotAllowed},
+		{Name: "Dot segments", Target: "/files/../escaped.txt", Status: httpcore.StatusNotFound},
+		// the handler checks the name on its own
+		{Name: "Param climbing up", Target: "/files/x", Params: map[string]string{"filename": "../escaped.txt"}, Status: httpcore.StatusNotFound},
+		{Name: "Absolute param", Target: "/files/x", Params: map[string]string{"filename": filepath.Join(root, "escaped.txt")}, Status: httpcore.StatusNotFound},
+		// names GET would not serve are not written either
+		{Name: "Dot prefixed param", Target: "/files/x", Params: map[string]string{"filename": "./upload.txt"}, Status: httpcore.StatusNotFound},
+		{Name: "Symlink out of the directory", Target: "/files/x", Params: map[string]string{"filename": "up/escaped.txt"}, Status: httpcore.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		if status, _ := send(t, appRouter, common.POST, tc.Target, tc.Params, "data"); status != tc.Status {
+			t.Errorf("[ %s ]expected status %d, got %d", tc.Name, tc.Status, status)
+		}
+		if _, err := os.Stat(filepath.Join(root, "escaped.txt")); err == nil {
+			t.Fatalf("[ %s ]file written outside the upload directory", tc.Name)
+		}
+	}
+
+	if contents, err := os.ReadFile(filepath.Join(directory, "upload.txt")); err != nil || string(contents) != "data" {
+		t.Errorf("expected the upload in the directory, got %q %v", contents, err)
+	}
+}
+
+func TestFilesStayInDirectory(t *testing.T) {
+	root := t.TempDir()
+	directory := filepath.Join(root, "files") + "/"
+	if err := os.Mkdir(directory, 0o755); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for name, contents := range map[string]string{"secret.txt": "secret", "files/notes.txt": "notes"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(contents), 0o644); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if err := os.Symlink(root, filepath.Join(directory, "up")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	appRouter := router.NewRouter()
+	if err := application.RegisterControllers(appRouter, &directory, false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	testCases := []struct {
+		Name   string
+		Target string
+		Status httpcore.HttpStatus
+		Body   string
+	}{
+		{Name: "File in the directory", Target: "/files/notes.txt", Status: httpcore.StatusOK, Body: "notes"},
+		{Name: "Symlink out of the directory", Target: "/files/up/secret.txt", Status: httpcore.StatusNotFound},
+		{Name: "Listing through the symlink", Target: "/files/up/", Status: httpcore.StatusNotFound},
+		// listings are off unless enabled
+		{Name: "Listing", Target: "/files/", Status: httpcore.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		status, body := send(t, appRouter, common.GET, tc.Target, nil, "")
+		if status != tc.Status {
+			t.Errorf("[ %s ]expected status %d, got %d", tc.Name, tc.Status, status)
+		}
+		if string(body) != tc.Body {
+			t.Errorf("[ %s ]expected body %q, got %q", tc.Name, tc.Body, body)
+		}
+	}
+}
@@ -3,10 +3,11 @@ package common
 type Method string
 
 const (
-	POST   Method = "POST"
-	GET    Method = "GET"
-	HEAD   Method = "HEAD"
-	PUT    Method = "PUT"
-	PATCH  Method = "PATCH"
-	DELETE Method = "DELETE"
+	POST    Method = "POST"
+	GET     Method = "GET"
+	HEAD    Method = "HEAD"
+	PUT     Method = "PUT"
+	PATCH   Method = "PATCH"
+	DELETE  Method = "DELETE"
+	OPTIONS Method = "OPTIONS"
 )
@@ -0,0 +1,73 @@
+package brotli
+
+import (
+	_ "embed"
+)
+
+// dictionary is the static dictionary of RFC 7932 appendix A, words of 4 to
+// 24 bytes grouped by length.
+//
+//go:embed dictionary.bin
+var dictionary string
+
+// dictionarySizeBits is NDBITS, the log2 of the number of words by length
+var dictionarySizeBits = [25]uint8{
+	0, 0, 0, 0, 10, 10, 11, 11, 10, 10, 10, 10, 10, 9, 9, 8, 7, 7, 8, 7, 7, 6, 6, 5, 5,
+}
+
+// dictionaryOffsets is DOFFSET, where the words of a length start
+var dictionaryOffsets = [25]int{
+	0, 0, 0, 0, 0, 4096, 9216, 21504, 35840, 44032, 53248, 63488, 74752, 87040,
+	93696, 100864, 104704, 106752, 108928, 113536, 115968, 118528, 119872, 121280, 122016,
+}
+
+const (
+	minDictionaryWord = 4
+	maxDictionaryWord = 24
+)
+
+// appendWord appends word changed by t to dst.
+func appendWord(dst []byte, word string, t transform) []byte {
+	dst = append(dst, t.prefix...)
+	switch {
+	case t.kind >= omitFirst1:
+		word = word[min(int(t.kind-omitFirst1)+1, len(word)):]
+	case t.kind >= omitLast1 && t.kind <= omitLast9:
+		word = word[:max(len(word)-int(t.kind-omitLast1)-1, 0)]
+	}
+
+	start := len(dst)
+	dst = append(dst, word...)
+	switch t.kind {
+	case uppercaseFirst:
+		if len(word) > 0 {
+			toUpper(dst[start:])
+		}
+	case uppercaseAll:
+		for upper := dst[start:]; len(upper) > 0; {
+			upper = upper[min(toUpper(upper), len(upper)):]
+		}
+	}
+	return append(dst, t.suffix...)
+}
+
+// toUpper uppercases the character at the start of p the simplified way of
+// RFC 7932 section 8 and returns how many bytes it takes.
+func toUpper(p []byte) int {
+	if p[0] < 0xc0 {
+		if 'a' <= p[0] && p[0] <= 'z' {
+			p[0] ^= 32
+		}
+		return 1
+	}
+	if p[0] < 0xe0 {
+		if len(p) > 1 {
+			p[1] ^= 32
+		}
+		return 2
+	}
+	if len(p) > 2 {
+		p[2] ^= 5
+	}
+	return 3
+}
@@ -0,0 +1,565 @@
+package brotli
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math/bits"
+	"slices"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression/huffman"
+)
+
+// maxCodeLength is the longest prefix code, in bits
+const maxCodeLength = 15
+
+// bitReader reads the stream least significant bit first.
+type bitReader struct {
+	r     io.ByteReader
+	value uint64
+	nbits uint
+	// err is the error that ended the input, buffered bits are still read
+	err error
+}
+
+func newBitReader(r io.Reader) bitReader {
+	byteReader, ok := r.(io.ByteReader)
+	if !ok {
+		byteReader = bufio.NewReader(r)
+	}
+	return bitReader{r: byteReader}
+}
+
+// fill buffers at least n bits unless the input ends first.
+func (b *bitReader) fill(n uint) {
+	for b.nbits < n && b.err == nil {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			b.err = err
+			return
+		}
+		b.value |= uint64(c) << b.nbits
+		b.nbits += 8
+	}
+}
+
+// failure is the error of a read past the end of the input.
+func (b *bitReader) failure() error {
+	if errors.Is(b.err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return b.err
+}
+
+// readBits reads n bits, up to 32.
+func (b *bitReader) readBits(n uint) (uint32, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	b.fill(n)
+	if b.nbits < n {
+		return 0, b.failure()
+	}
+	v := uint32(b.value & (1<<n - 1))
+	b.value >>= n
+	b.nbits -= n
+	return v, nil
+}
+
+// alignToByte drops the bits left in the current byte, they have to be
+// zero.
+func (b *bitReader) alignToByte() error {
+	pad := b.nbits % 8
+	if b.value&(1<<pad-1) != 0 {
+		return corrupt("non-zero padding")
+	}
+	b.value >>= pad
+	b.nbits -= pad
+	return nil
+}
+
+// readByte reads a whole byte once the reader is aligned.
+func (b *bitReader) readByte() (byte, error) {
+	if b.nbits >= 8 {
+		c := byte(b.value)
+		b.value >>= 8
+		b.nbits -= 8
+		return c, nil
+	}
+	if b.err != nil {
+		return 0, b.failure()
+	}
+	c, err := b.r.ReadByte()
+	if err != nil {
+		b.err = err
+		return 0, b.failure()
+	}
+	return c, nil
+}
+
+// rootBits is the number of bits resolved by the lookup table of a prefix
+// code, longer codes are walked bit by bit.
+const rootBits = 8
+
+// longCode marks a root entry that is the start of a longer code
+const longCode = 0x1f
+
+// prefixCode decodes a canonical prefix code. Codes are packed starting
+// with their most significant bit (RFC 7932 section 3.1).
+type prefixCode struct {
+	// root maps the next rootBits bits to symbol<<5 | length
+	root    [1 << rootBits]uint32
+	counts  [maxCodeLength + 1]uint16
+	symbols []uint16
+}
+
+// newPrefixCode builds the code of a complete set of code lengths, see
+// singleSymbol for a code of one symbol.
+func newPrefixCode(lengths []uint8) *prefixCode {
+	code := &prefixCode{}
+	var offsets [maxCodeLength + 2]uint16
+	used := 0
+	for _, length := range lengths {
+		code.counts[length]++
+	}
+	for length := 1; length <= maxCodeLength; length++ {
+		offsets[length+1] = offsets[length] + code.counts[length]
+		used += int(code.counts[length])
+	}
+	code.symbols = make([]uint16, used)
+	for symbol, length := range lengths {
+		if length != 0 {
+			code.symbols[offsets[length]] = uint16(symbol)
+			offsets[length]++
+		}
+	}
+
+	for idx := range code.root {
+		code.root[idx] = longCode
+	}
+	next, idx := 0, 0
+	for length := 1; length <= rootBits; length++ {
+		for range code.counts[length] {
+			symbol := uint32(code.symbols[idx])
+			reversed := int(bits.Reverse16(uint16(next)) >> (16 - length))
+			for fill := reversed; fill < len(code.root); fill += 1 << length {
+				code.root[fill] = symbol<<5 | uint32(length)
+			}
+			next++
+			idx++
+		}
+		next <<= 1
+	}
+	return code
+}
+
+// singleSymbol is the code of a one symbol alphabet, which takes no bits
+func singleSymbol(symbol int) *prefixCode {
+	code := &prefixCode{}
+	for idx := range code.root {
+		code.root[idx] = uint32(symbol) << 5
+	}
+	return code
+}
+
+func (b *bitReader) readSymbol(code *prefixCode) (int, error) {
+	b.fill(maxCodeLength)
+	entry := code.root[b.value&(1<<rootBits-1)]
+	if length := uint(entry & 0x1f); length != longCode {
+		if length > b.nbits {
+			return 0, b.failure()
+		}
+		b.value >>= length
+		b.nbits -= length
+		return int(entry >> 5), nil
+	}
+
+	// walk the canonical code one bit at a time
+	value, first, index := 0, 0, 0
+	for length := uint(1); length <= maxCodeLength; length++ {
+		if length > b.nbits {
+			return 0, b.failure()
+		}
+		value |= int(b.value>>(length-1)) & 1
+		count := int(code.counts[length])
+		if value-count < first {
+			b.value >>= length
+			b.nbits -= length
+			return int(code.symbols[index+value-first]), nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		value <<= 1
+	}
+	return 0, corrupt("invalid prefix code")
+}
+
+// codeLengthOrder is the order code lengths of the code length alphabet
+// are sent in
+var codeLengthOrder = [18]int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// code lengths of the code length alphabet use a fixed variable length
+// code, indexed by the next 4 bits
+var (
+	codeLengthPrefixLength = [16]uint8{2, 2, 2, 3, 2, 2, 2, 4, 2, 2, 2, 3, 2, 2, 2, 4}
+	codeLengthPrefixValue  = [16]uint8{0, 4, 3, 2, 0, 4, 3, 1, 0, 4, 3, 2, 0, 4, 3, 5}
+)
+
+const (
+	repeatPreviousLength = 16
+	repeatZeroLength     = 17
+)
+
+// readPrefixCode reads a simple or complex prefix code for alphabetSize
+// symbols (RFC 7932 section 3.4 and 3.5).
+func (b *bitReader) readPrefixCode(alphabetSize int) (*prefixCode, error) {
+	hskip, err := b.readBits(2)
+	if err != nil {
+		return nil, err
+	}
+	if hskip == 1 {
+		return b.readSimplePrefixCode(alphabetSize)
+	}
+
+	var codeLengthLengths [18]uint8
+	space, codes := 32, 0
+	for _, symbol := range codeLengthOrder[hskip:] {
+		b.fill(4)
+		peek := b.value & 0xf
+		length := uint(codeLengthPrefixLength[peek])
+		if length > b.nbits {
+			return nil, b.failure()
+		}
+		b.value >>= length
+		b.nbits -= length
+
+		value := codeLengthPrefixValue[peek]
+		codeLengthLengths[symbol] = value
+		if value != 0 {
+			space -= 32 >> value
+			codes++
+			if space <= 0 {
+				break
+			}
+		}
+	}
+	if codes != 1 && space != 0 {
+		return nil, corrupt("incomplete code length code")
+	}
+	var codeLengthCode *prefixCode
+	if codes == 1 {
+		for symbol, length := range codeLengthLengths {
+			if length != 0 {
+				codeLengthCode = singleSymbol(symbol)
+			}
+		}
+	} else {
+		codeLengthCode = newPrefixCode(codeLengthLengths[:])
+	}
+
+	lengths := make([]uint8, alphabetSize)
+	symbol, previous := 0, uint8(8)
+	repeat, repeatLength := 0, uint8(0)
+	space = 1 << maxCodeLength
+	for symbol < alphabetSize && space > 0 {
+		code, err := b.readSymbol(codeLengthCode)
+		if err != nil {
+			return nil, err
+		}
+		if code < repeatPreviousLength {
+			lengths[symbol] = uint8(code)
+			symbol++
+			repeat = 0
+			if code != 0 {
+				previous = uint8(code)
+				space -= 1 << maxCodeLength >> code
+			}
+			continue
+		}
+
+		extraBits, length := uint(2), previous
+		if code == repeatZeroLength {
+			extraBits, length = 3, 0
+		}
+		if repeatLength != length {
+			repeat, repeatLength = 0, length
+		}
+		// consecutive repeat codes extend the previous run
+		old := repeat
+		if repeat > 0 {
+			repeat = (repeat - 2) << extraBits
+		}
+		extra, err := b.readBits(extraBits)
+		if err != nil {
+			return nil, err
+		}
+		repeat += int(extra) + 3
+		delta := repeat - old
+		if symbol+delta > alphabetSize {
+			return nil, corrupt("code lengths overflow the alphabet")
+		}
+		for range delta {
+			lengths[symbol] = length
+			symbol++
+		}
+		if length != 0 {
+			space -= delta << maxCodeLength >> length
+		}
+	}
+	if space != 0 {
+		return nil, corrupt("incomplete prefix code")
+	}
+	return newPrefixCode(lengths), nil
+}
+
+func (b *bitReader) readSimplePrefixCode(alphabetSize int) (*prefixCode, error) {
+	count, err := b.readBits(2)
+	if err != nil {
+		return nil, err
+	}
+	alphabetBits := uint(bits.Len(uint(alphabetSize - 1)))
+	symbols := make([]int, count+1)
+	for idx := range symbols {
+		symbol, err := b.readBits(alphabetBits)
+		if err != nil {
+			return nil, err
+		}
+		if int(symbol) >= alphabetSize {
+			return nil, corrupt("symbol outside the alphabet")
+		}
+		for _, seen := range symbols[:idx] {
+			if seen == int(symbol) {
+				return nil, corrupt("repeated symbol")
+			}
+		}
+		symbols[idx] = int(symbol)
+	}
+
+	var shape []uint8
+	switch len(symbols) {
+	case 1:
+		return singleSymbol(symbols[0]), nil
+	case 2:
+		shape = []uint8{1, 1}
+	case 3:
+		shape = []uint8{1, 2, 2}
+	default:
+		treeSelect, err := b.readBits(1)
+		if err != nil {
+			return nil, err
+		}
+		shape = []uint8{2, 2, 2, 2}
+		if treeSelect == 1 {
+			shape = []uint8{1, 2, 3, 3}
+		}
+	}
+	lengths := make([]uint8, alphabetSize)
+	for idx, symbol := range symbols {
+		lengths[symbol] = shape[idx]
+	}
+	return newPrefixCode(lengths), nil
+}
+
+// bitWriter writes the stream least significant bit first, whole bytes go
+// to out.
+type bitWriter struct {
+	out   []byte
+	value uint64
+	nbits uint
+}
+
+func (b *bitWriter) writeBits(v uint64, n uint) {
+	b.value |= v << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.out = append(b.out, byte(b.value))
+		b.value >>= 8
+		b.nbits -= 8
+	}
+}
+
+// alignToByte pads the last byte with zero bits.
+func (b *bitWriter) alignToByte() {
+	if b.nbits > 0 {
+		b.writeBits(0, 8-b.nbits)
+	}
+}
+
+// prefixEncoder holds the code of every symbol, bit reversed so it writes
+// least significant bit first.
+type prefixEncoder struct {
+	codes   []uint16
+	lengths []uint8
+}
+
+// newPrefixEncoder assigns the canonical codes of lengths, the way
+// newPrefixCode reads them.
+func newPrefixEncoder(lengths []uint8) *prefixEncoder {
+	var count [maxCodeLength + 1]int
+	for _, length := range lengths {
+		count[length]++
+	}
+	count[0] = 0
+	var next [maxCodeLength + 1]int
+	for length := 1; length <= maxCodeLength; length++ {
+		next[length] = (next[length-1] + count[length-1]) << 1
+	}
+	encoder := &prefixEncoder{codes: make([]uint16, len(lengths)), lengths: lengths}
+	for symbol, length := range lengths {
+		if length > 0 {
+			encoder.codes[symbol] = bits.Reverse16(uint16(next[length])) >> (16 - length)
+			next[length]++
+		}
+	}
+	return encoder
+}
+
+func (e *prefixEncoder) write(w *bitWriter, symbol int) {
+	w.writeBits(uint64(e.codes[symbol]), uint(e.lengths[symbol]))
+}
+
+// the codes of the fixed variable length code of code length code lengths
+var (
+	codeLengthPrefixCode = [6]uint8{0, 7, 3, 2, 1, 15}
+	codeLengthPrefixBits = [6]uint8{2, 4, 3, 2, 2, 4}
+)
+
+// writePrefixCode writes a prefix code for the symbol frequencies freqs
+// and returns its encoder. No symbol or a single one makes a simple code
+// whose symbol takes no bits, any other a complex code (RFC 7932 section
+// 3.4 and 3.5).
+func writePrefixCode(w *bitWriter, freqs []uint32) *prefixEncoder {
+	alphabetBits := uint(bits.Len(uint(len(freqs) - 1)))
+	used, last := 0, 0
+	for symbol, freq := range freqs {
+		if freq > 0 {
+			used, last = used+1, symbol
+		}
+	}
+	if used <= 1 {
+		w.writeBits(1, 2)
+		w.writeBits(0, 2)
+		w.writeBits(uint64(last), alphabetBits)
+		return &prefixEncoder{codes: make([]uint16, len(freqs)), lengths: make([]uint8, len(freqs))}
+	}
+
+	lengths := huffman.Lengths(freqs, maxCodeLength)
+	symbols, extras := codeLengthSymbols(lengths)
+	var histogram [18]uint32
+	for _, symbol := range symbols {
+		histogram[symbol]++
+	}
+	codeLengthLengths := huffman.Lengths(histogram[:], 5)
+	codes := 0
+	for _, length := range codeLengthLengths {
+		if length > 0 {
+			codes++
+		}
+	}
+
+	hskip := 0
+	for hskip < 3 && codeLengthLengths[codeLengthOrder[hskip]] == 0 {
+		hskip++
+	}
+	if hskip == 1 {
+		hskip = 0
+	}
+	// the decoder stops at the last length of a complete code, a single
+	// one does not complete it, so every length is sent
+	end := len(codeLengthOrder)
+	if codes > 1 {
+		for codeLengthLengths[codeLengthOrder[end-1]] == 0 {
+			end--
+		}
+	}
+	w.writeBits(uint64(hskip), 2)
+	for _, symbol := range codeLengthOrder[hskip:end] {
+		length := codeLengthLengths[symbol]
+		w.writeBits(uint64(codeLengthPrefixCode[length]), uint(codeLengthPrefixBits[length]))
+	}
+
+	codeLengthCode := newPrefixEncoder(codeLengthLengths)
+	if codes == 1 {
+		clear(codeLengthCode.lengths)
+	}
+	for idx, symbol := range symbols {
+		codeLengthCode.write(w, int(symbol))
+		switch symbol {
+		case repeatPreviousLength:
+			w.writeBits(uint64(extras[idx]), 2)
+		case repeatZeroLength:
+			w.writeBits(uint64(extras[idx]), 3)
+		}
+	}
+	return newPrefixEncoder(lengths)
+}
+
+// codeLengthSymbols run length codes lengths into code length symbols and
+// their extra bits, the way the reference encoder does. Trailing zeros are
+// left out, the decoder stops once the code is complete.
+func codeLengthSymbols(lengths []uint8) ([]uint8, []uint8) {
+	end := len(lengths)
+	for end > 0 && lengths[end-1] == 0 {
+		end--
+	}
+	var symbols, extras []uint8
+	// runs of repeat codes are sent most significant part first
+	repeat := func(code uint8, reps int, extraBits uint) {
+		start := len(symbols)
+		reps -= 3
+		for {
+			symbols = append(symbols, code)
+			extras = append(extras, uint8(reps&(1<<extraBits-1)))
+			reps >>= extraBits
+			if reps == 0 {
+				break
+			}
+			reps--
+		}
+		slices.Reverse(symbols[start:])
+		slices.Reverse(extras[start:])
+	}
+
+	previous := uint8(8)
+	for idx := 0; idx < end; {
+		value := lengths[idx]
+		reps := 1
+		for idx+reps < end && lengths[idx+reps] == value {
+			reps++
+		}
+		idx += reps
+		if value == 0 {
+			if reps == 11 {
+				symbols, extras = append(symbols, 0), append(extras, 0)
+				reps--
+			}
+			if reps < 3 {
+				for range reps {
+					symbols, extras = append(symbols, 0), append(extras, 0)
+				}
+			} else {
+				repeat(repeatZeroLength, reps, 3)
+			}
+			continue
+		}
+
+		if value != previous {
+			symbols, extras = append(symbols, value), append(extras, 0)
+			reps--
+			previous = value
+		}
+		if reps == 7 {
+			symbols, extras = append(symbols, value), append(extras, 0)
+			reps--
+		}
+		if reps < 3 {
+			for range reps {
+				symbols, extras = append(symbols, value), append(extras, 0)
+			}
+		} else {
+			repeat(repeatPreviousLength, reps, 2)
+		}
+	}
+	return symbols, extras
+}
@@ -0,0 +1,746 @@
+// Package brotli implements the brotli format of RFC 7932, the br content
+// coding.
+package brotli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrCorrupt is wrapped by the errors of a malformed stream.
+var ErrCorrupt = errors.New("brotli: corrupt stream")
+
+func corrupt(reason string) error {
+	return fmt.Errorf("%w: %s", ErrCorrupt, reason)
+}
+
+const (
+	literalAlphabet     = 256
+	insertCopyAlphabet  = 704
+	blockCountAlphabet  = 26
+	literalContexts     = 64
+	distanceContexts    = 4
+	numDistanceShort    = 16
+	readChunk           = 32 << 10
+	categoryLiteral     = 0
+	categoryInsertCopy  = 1
+	categoryDistance    = 2
+	numBlockCategories  = 3
+	maxMetaBlockNibbles = 6
+)
+
+// block counts are a base plus extra bits, by block count symbol
+var blockCountBase = [blockCountAlphabet]uint32{
+	1, 5, 9, 13, 17, 25, 33, 41, 49, 65, 81, 97, 113, 145, 177, 209, 241, 305, 369, 497,
+	753, 1265, 2289, 4337, 8433, 16625,
+}
+
+var blockCountExtra = [blockCountAlphabet]uint8{
+	2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 6, 6, 7, 8, 9, 10, 11, 12, 13, 24,
+}
+
+// insert and copy lengths are a base plus extra bits, by length code
+var (
+	insertLengthBase  = [24]uint32{0, 1, 2, 3, 4, 5, 6, 8, 10, 14, 18, 26, 34, 50, 66, 98, 130, 194, 322, 578, 1090, 2114, 6210, 22594}
+	insertLengthExtra = [24]uint8{0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 12, 14, 24}
+	copyLengthBase    = [24]uint32{2, 3, 4, 5, 6, 7, 8, 9, 10, 12, 14, 18, 22, 30, 38, 54, 70, 102, 134, 198, 326, 582, 1094, 2118}
+	copyLengthExtra   = [24]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 24}
+)
+
+// an insert-and-copy symbol names a cell of 64 symbols, the cell gives the
+// first insert and copy length codes (RFC 7932 section 5)
+var (
+	insertRangeOfCell = [9]int{0, 0, 8, 8, 0, 16, 8, 16, 16}
+	copyRangeOfCell   = [9]int{0, 8, 0, 8, 16, 0, 16, 8, 16}
+)
+
+type readerState int
+
+const (
+	stateStreamHeader readerState = iota
+	stateMetaBlockHeader
+	stateCommand
+	stateInsert
+	stateDistance
+	stateCopy
+	stateUncompressed
+	stateDone
+)
+
+// blockSwitch tracks the block type and count of one category
+type blockSwitch struct {
+	types    int
+	current  int
+	last     [2]int
+	count    uint32
+	typeCode *prefixCode
+	lenCode  *prefixCode
+}
+
+// Reader decompresses a brotli stream (RFC 7932).
+type Reader struct {
+	bits bitReader
+	err  error
+
+	state   readerState
+	last    bool
+	window  int
+	maxDist int
+	// out holds the history the stream copies from followed by the bytes
+	// Read has not returned yet, from rpos
+	out   []byte
+	rpos  int
+	total int64
+
+	// meta-block
+	remaining  int
+	blocks     [numBlockCategories]blockSwitch
+	postfix    uint
+	direct     int
+	modes      []contextMode
+	literalMap []uint8
+	distMap    []uint8
+	literals   []*prefixCode
+	commands   []*prefixCode
+	distances  []*prefixCode
+
+	// command
+	insertLen    int
+	copyLen      int
+	implicitDist bool
+	distance     int
+	dists        [4]int
+	distIdx      int
+}
+
+// NewReader returns a Reader decompressing from r. Read errors wrap
+// ErrCorrupt when the stream is malformed.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{bits: newBitReader(r)}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for r.rpos == len(r.out) {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.decode()
+	}
+	n := copy(p, r.out[r.rpos:])
+	r.rpos += n
+	return n, nil
+}
+
+// Close releases the window, it does not close the underlying reader.
+func (r *Reader) Close() error {
+	r.out = nil
+	r.rpos = 0
+	if r.err == nil {
+		r.err = errors.New("brotli: reader closed")
+	}
+	return nil
+}
+
+// decode continues the stream until a chunk of output is ready. It returns
+// io.EOF after the last meta-block.
+func (r *Reader) decode() error {
+	r.compact()
+	target := len(r.out) + readChunk
+	for len(r.out) < target {
+		var err error
+		switch r.state {
+		case stateStreamHeader:
+			err = r.readStreamHeader()
+		case stateMetaBlockHeader:
+			err = r.readMetaBlockHeader()
+		case stateCommand:
+			err = r.readCommand()
+		case stateInsert:
+			err = r.insertLiterals(target)
+		case stateDistance:
+			err = r.readDistance()
+		case stateCopy:
+			r.copyMatch(target)
+		case stateUncompressed:
+			err = r.copyUncompressed(target)
+		case stateDone:
+			if len(r.out) > r.rpos {
+				return nil
+			}
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compact drops the history no longer reachable, keeping at least the
+// window behind the unread output.
+func (r *Reader) compact() {
+	keep := max(0, min(r.rpos, len(r.out)-r.window))
+	if keep < readChunk || keep < len(r.out)/2 {
+		return
+	}
+	r.out = append(r.out[:0], r.out[keep:]...)
+	r.rpos -= keep
+}
+
+func (r *Reader) emit(c byte) {
+	r.out = append(r.out, c)
+	r.total++
+	r.remaining--
+}
+
+func (r *Reader) readStreamHeader() error {
+	wbits := 16
+	flag, err := r.bits.readBits(1)
+	if err != nil {
+		return err
+	}
+	if flag == 1 {
+		n, err := r.bits.readBits(3)
+		if err != nil {
+			return err
+		}
+		switch {
+		case n != 0:
+			wbits = 17 + int(n)
+		default:
+			m, err := r.bits.readBits(3)
+			if err != nil {
+				return err
+			}
+			switch {
+			case m == 1:
+				return corrupt("large window streams are not supported")
+			case m != 0:
+				wbits = 8 + int(m)
+			default:
+				wbits = 17
+			}
+		}
+	}
+	r.window = 1 << wbits
+	r.maxDist = r.window - 16
+	r.dists = [4]int{16, 15, 11, 4}
+	r.distIdx = 3
+	r.state = stateMetaBlockHeader
+	return nil
+}
+
+func (r *Reader) readMetaBlockHeader() error {
+	if r.last {
+		if err := r.bits.alignToByte(); err != nil {
+			return err
+		}
+		r.state = stateDone
+		return nil
+	}
+
+	last, err := r.bits.readBits(1)
+	if err != nil {
+		return err
+	}
+	r.last = last == 1
+	if r.last {
+		empty, err := r.bits.readBits(1)
+		if err != nil {
+			return err
+		}
+		if empty == 1 {
+			return nil
+		}
+	}
+
+	nibbles, err := r.bits.readBits(2)
+	if err != nil {
+		return err
+	}
+	if nibbles == 3 {
+		return r.skipMetadata()
+	}
+	nibbles += 4
+	length, err := r.bits.readBits(uint(nibbles) * 4)
+	if err != nil {
+		return err
+	}
+	if nibbles > 4 && length>>((nibbles-1)*4) == 0 {
+		return corrupt("meta-block length with a leading zero nibble")
+	}
+	r.remaining = int(length) + 1
+
+	if !r.last {
+		uncompressed, err := r.bits.readBits(1)
+		if err != nil {
+			return err
+		}
+		if uncompressed == 1 {
+			if err := r.bits.alignToByte(); err != nil {
+				return err
+			}
+			r.state = stateUncompressed
+			return nil
+		}
+	}
+	if err := r.readCompressedHeader(); err != nil {
+		return err
+	}
+	r.state = stateCommand
+	return nil
+}
+
+// skipMetadata skips a metadata meta-block, its content means nothing to
+// the decoder.
+func (r *Reader) skipMetadata() error {
+	reserved, err := r.bits.readBits(1)
+	if err != nil {
+		return err
+	}
+	if reserved != 0 {
+		return corrupt("reserved bit set")
+	}
+	skipBytes, err := r.bits.readBits(2)
+	if err != nil {
+		return err
+	}
+	skip := 0
+	if skipBytes > 0 {
+		length, err := r.bits.readBits(uint(skipBytes) * 8)
+		if err != nil {
+			return err
+		}
+		if skipBytes > 1 && length>>((skipBytes-1)*8) == 0 {
+			return corrupt("metadata length with a leading zero byte")
+		}
+		skip = int(length) + 1
+	}
+	if err := r.bits.alignToByte(); err != nil {
+		return err
+	}
+	for range skip {
+		if _, err := r.bits.readByte(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reader) readCompressedHeader() error {
+	for category := range r.blocks {
+		block := &r.blocks[category]
+		*block = blockSwitch{current: 0, last: [2]int{1, 0}, count: 1 << 24}
+		types, err := r.readVarLenUint8()
+		if err != nil {
+			return err
+		}
+		block.types = types + 1
+		if block.types < 2 {
+			continue
+		}
+		if block.typeCode, err = r.bits.readPrefixCode(block.types + 2); err != nil {
+			return err
+		}
+		if block.lenCode, err = r.bits.readPrefixCode(blockCountAlphabet); err != nil {
+			return err
+		}
+		if block.count, err = r.readBlockCount(block); err != nil {
+			return err
+		}
+	}
+
+	postfix, err := r.bits.readBits(2)
+	if err != nil {
+		return err
+	}
+	direct, err := r.bits.readBits(4)
+	if err != nil {
+		return err
+	}
+	r.postfix = uint(postfix)
+	r.direct = int(direct) << r.postfix
+
+	r.modes = make([]contextMode, r.blocks[categoryLiteral].types)
+	for idx := range r.modes {
+		mode, err := r.bits.readBits(2)
+		if err != nil {
+			return err
+		}
+		r.modes[idx] = contextMode(mode)
+	}
+
+	literalTrees, err := r.readVarLenUint8()
+	if err != nil {
+		return err
+	}
+	if r.literalMap, err = r.readContextMap(r.blocks[categoryLiteral].types*literalContexts, literalTrees+1); err != nil {
+		return err
+	}
+	distanceTrees, err := r.readVarLenUint8()
+	if err != nil {
+		return err
+	}
+	if r.distMap, err = r.readContextMap(r.blocks[categoryDistance].types*distanceContexts, distanceTrees+1); err != nil {
+		return err
+	}
+
+	if r.literals, err = r.readPrefixCodes(literalTrees+1, literalAlphabet); err != nil {
+		return err
+	}
+	if r.commands, err = r.readPrefixCodes(r.blocks[categoryInsertCopy].types, insertCopyAlphabet); err != nil {
+		return err
+	}
+	distanceAlphabet := numDistanceShort + r.direct + 48<<r.postfix
+	r.distances, err = r.readPrefixCodes(distanceTrees+1, distanceAlphabet)
+	return err
+}
+
+func (r *Reader) readPrefixCodes(count int, alphabetSize int) ([]*prefixCode, error) {
+	codes := make([]*prefixCode, count)
+	for idx := range codes {
+		code, err := r.bits.readPrefixCode(alphabetSize)
+		if err != nil {
+			return nil, err
+		}
+		codes[idx] = code
+	}
+	return codes, nil
+}
+
+// readVarLenUint8 reads a number from 0 to 255 (RFC 7932 section 9.2).
+func (r *Reader) readVarLenUint8() (int, error) {
+	flag, err := r.bits.readBits(1)
+	if err != nil || flag == 0 {
+		return 0, err
+	}
+	n, err := r.bits.readBits(3)
+	if err != nil || n == 0 {
+		return 1, err
+	}
+	extra, err := r.bits.readBits(uint(n))
+	return 1<<n + int(extra), err
+}
+
+func (r *Reader) readBlockCount(block *blockSwitch) (uint32, error) {
+	symbol, err := r.bits.readSymbol(block.lenCode)
+	if err != nil {
+		return 0, err
+	}
+	extra, err := r.bits.readBits(uint(blockCountExtra[symbol]))
+	return blockCountBase[symbol] + extra, err
+}
+
+// readContextMap reads the tree index of every context (RFC 7932 section
+// 7.3), all zero when there is a single tree.
+func (r *Reader) readContextMap(size int, trees int) ([]uint8, error) {
+	contextMap := make([]uint8, size)
+	if trees < 2 {
+		return contextMap, nil
+	}
+
+	maxRunLength := 0
+	if flag, err := r.bits.readBits(1); err != nil {
+		return nil, err
+	} else if flag == 1 {
+		prefix, err := r.bits.readBits(4)
+		if err != nil {
+			return nil, err
+		}
+		maxRunLength = int(prefix) + 1
+	}
+	code, err := r.bits.readPrefixCode(trees + maxRunLength)
+	if err != nil {
+		return nil, err
+	}
+	for idx := 0; idx < size; {
+		symbol, err := r.bits.readSymbol(code)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case symbol == 0:
+			idx++
+		case symbol <= maxRunLength:
+			extra, err := r.bits.readBits(uint(symbol))
+			if err != nil {
+				return nil, err
+			}
+			run := 1<<symbol + int(extra)
+			if idx+run > size {
+				return nil, corrupt("context map run overflows")
+			}
+			idx += run
+		default:
+			contextMap[idx] = uint8(symbol - maxRunLength)
+			idx++
+		}
+	}
+
+	if inverse, err := r.bits.readBits(1); err != nil {
+		return nil, err
+	} else if inverse == 1 {
+		var mtf [256]uint8
+		for idx := range mtf {
+			mtf[idx] = uint8(idx)
+		}
+		for idx, index := range contextMap {
+			value := mtf[index]
+			contextMap[idx] = value
+			copy(mtf[1:index+1], mtf[:index])
+			mtf[0] = value
+		}
+	}
+	for _, tree := range contextMap {
+		if int(tree) >= trees {
+			return nil, corrupt("context map names a missing tree")
+		}
+	}
+	return contextMap, nil
+}
+
+// nextBlock counts one symbol of category, switching to the next block
+// when the current one is used up.
+func (r *Reader) nextBlock(category int) error {
+	block := &r.blocks[category]
+	if block.count == 0 {
+		symbol, err := r.bits.readSymbol(block.typeCode)
+		if err != nil {
+			return err
+		}
+		switch symbol {
+		case 0:
+			symbol = block.last[0]
+		case 1:
+			symbol = block.last[1] + 1
+		default:
+			symbol -= 2
+		}
+		if symbol >= block.types {
+			symbol -= block.types
+		}
+		block.last = [2]int{block.last[1], symbol}
+		block.current = symbol
+		if block.count, err = r.readBlockCount(block); err != nil {
+			return err
+		}
+	}
+	block.count--
+	return nil
+}
+
+func (r *Reader) readCommand() error {
+	if r.remaining <= 0 {
+		r.state = stateMetaBlockHeader
+		return nil
+	}
+	if err := r.nextBlock(categoryInsertCopy); err != nil {
+		return err
+	}
+	symbol, err := r.bits.readSymbol(r.commands[r.blocks[categoryInsertCopy].current])
+	if err != nil {
+		return err
+	}
+
+	cell := symbol >> 6
+	insertCode, copyCode := (symbol>>3)&7, symbol&7
+	r.implicitDist = cell < 2
+	if r.implicitDist {
+		copyCode += cell * 8
+	} else {
+		insertCode += insertRangeOfCell[cell-2]
+		copyCode += copyRangeOfCell[cell-2]
+	}
+	insertExtra, err := r.bits.readBits(uint(insertLengthExtra[insertCode]))
+	if err != nil {
+		return err
+	}
+	copyExtra, err := r.bits.readBits(uint(copyLengthExtra[copyCode]))
+	if err != nil {
+		return err
+	}
+	r.insertLen = int(insertLengthBase[insertCode] + insertExtra)
+	r.copyLen = int(copyLengthBase[copyCode] + copyExtra)
+	r.state = stateInsert
+	return nil
+}
+
+func (r *Reader) insertLiterals(target int) error {
+	for r.insertLen > 0 {
+		if r.remaining <= 0 {
+			return corrupt("literals overflow the meta-block")
+		}
+		if len(r.out) >= target {
+			return nil
+		}
+		if err := r.nextBlock(categoryLiteral); err != nil {
+			return err
+		}
+		blockType := r.blocks[categoryLiteral].current
+		var p1, p2 byte
+		if n := len(r.out); n >= 2 {
+			p1, p2 = r.out[n-1], r.out[n-2]
+		} else if n == 1 {
+			p1 = r.out[0]
+		}
+		context := literalContext(r.modes[blockType], p1, p2)
+		tree := r.literalMap[blockType*literalContexts+context]
+		literal, err := r.bits.readSymbol(r.literals[tree])
+		if err != nil {
+			return err
+		}
+		r.emit(byte(literal))
+		r.insertLen--
+	}
+
+	// the last command of a meta-block may end after its literals
+	if r.remaining <= 0 {
+		r.state = stateMetaBlockHeader
+		return nil
+	}
+	r.state = stateDistance
+	return nil
+}
+
+func (r *Reader) readDistance() error {
+	code := 0
+	if !r.implicitDist {
+		if err := r.nextBlock(categoryDistance); err != nil {
+			return err
+		}
+		context := min(r.copyLen, 5) - 2
+		tree := r.distMap[r.blocks[categoryDistance].current*distanceContexts+context]
+		symbol, err := r.bits.readSymbol(r.distances[tree])
+		if err != nil {
+			return err
+		}
+		code = symbol
+	}
+
+	distance, err := r.decodeDistance(code)
+	if err != nil {
+		return err
+	}
+	maxDistance := r.maxDist
+	if r.total < int64(maxDistance) {
+		maxDistance = int(r.total)
+	}
+	if distance > maxDistance {
+		return r.copyWord(distance - maxDistance - 1)
+	}
+	if code != 0 {
+		r.distIdx = (r.distIdx + 1) & 3
+		r.dists[r.distIdx] = distance
+	}
+	if r.copyLen > r.remaining {
+		return corrupt("copy overflows the meta-block")
+	}
+	r.distance = distance
+	r.state = stateCopy
+	return nil
+}
+
+// decodeDistance turns a distance code into a distance (RFC 7932 section
+// 4).
+func (r *Reader) decodeDistance(code int) (int, error) {
+	last := func(back int) int { return r.dists[(r.distIdx-back)&3] }
+	if code < numDistanceShort {
+		var distance int
+		switch {
+		case code < 4:
+			distance = last(code)
+		case code < 10:
+			delta := (code - 2) >> 1
+			if code&1 == 0 {
+				delta = -delta
+			}
+			distance = last(0) + delta
+		default:
+			delta := (code - 8) >> 1
+			if code&1 == 0 {
+				delta = -delta
+			}
+			distance = last(1) + delta
+		}
+		if distance <= 0 {
+			return 0, corrupt("invalid distance")
+		}
+		return distance, nil
+	}
+	if code < numDistanceShort+r.direct {
+		return code - numDistanceShort + 1, nil
+	}
+
+	code -= numDistanceShort + r.direct
+	extraBits := uint(1 + code>>(r.postfix+1))
+	high := code >> r.postfix
+	low := code & (1<<r.postfix - 1)
+	offset := (2+high&1)<<extraBits - 4
+	extra, err := r.bits.readBits(extraBits)
+	if err != nil {
+		return 0, err
+	}
+	return (offset+int(extra))<<r.postfix + low + r.direct + 1, nil
+}
+
+// copyWord appends a static dictionary word, id picks the word of length
+// copyLen and its transform.
+func (r *Reader) copyWord(id int) error {
+	length := r.copyLen
+	if length < minDictionaryWord || length > maxDictionaryWord {
+		return corrupt("invalid dictionary reference")
+	}
+	sizeBits := dictionarySizeBits[length]
+	index := id & (1<<sizeBits - 1)
+	transformID := id >> sizeBits
+	if transformID >= len(transforms) {
+		return corrupt("invalid dictionary transform")
+	}
+	offset := dictionaryOffsets[length] + index*length
+	word := dictionary[offset : offset+length]
+
+	start := len(r.out)
+	r.out = appendWord(r.out, word, transforms[transformID])
+	n := len(r.out) - start
+	if n > r.remaining {
+		return corrupt("dictionary word overflows the meta-block")
+	}
+	r.total += int64(n)
+	r.remaining -= n
+	r.state = stateCommand
+	return nil
+}
+
+// copyMatch copies copyLen bytes from distance back, in steps when the
+// copy overlaps its source.
+func (r *Reader) copyMatch(target int) {
+	for r.copyLen > 0 && len(r.out) < target {
+		n := min(r.copyLen, r.distance)
+		start := len(r.out) - r.distance
+		r.out = append(r.out, r.out[start:start+n]...)
+		r.copyLen -= n
+		r.remaining -= n
+		r.total += int64(n)
+	}
+	if r.copyLen == 0 {
+		r.state = stateCommand
+	}
+}
+
+func (r *Reader) copyUncompressed(target int) error {
+	for r.remaining > 0 && len(r.out) < target {
+		c, err := r.bits.readByte()
+		if err != nil {
+			return err
+		}
+		r.emit(c)
+	}
+	if r.remaining == 0 {
+		r.state = stateMetaBlockHeader
+	}
+	return nil
+}
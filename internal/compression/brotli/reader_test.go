@@ -0,0 +1,76 @@
+package brotli_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression/brotli"
+)
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "testdata", name))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return data
+}
+
+func TestReader(t *testing.T) {
+	text := readTestdata(t, "text.txt")
+
+	// the streams were made with the reference encoder
+	testCases := []struct {
+		Name     string
+		File     string
+		Expected []byte
+	}{
+		{Name: "empty", File: "empty.br", Expected: []byte{}},
+		{Name: "fastest quality", File: "text.q1.br", Expected: text},
+		{Name: "context modeling and dictionary words", File: "text.q11.br", Expected: text},
+		{Name: "uncompressed meta-block", File: "random.br", Expected: readTestdata(t, "random.bin")},
+		{Name: "output past the window", File: "repeated.w10.br", Expected: bytes.Repeat(text[:600], 256)},
+	}
+
+	for _, tc := range testCases {
+		data := readTestdata(t, tc.File)
+		decoded, err := io.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+		if err != nil || !bytes.Equal(decoded, tc.Expected) {
+			t.Errorf("[ %s ]expected %d bytes, got %d %v", tc.Name, len(tc.Expected), len(decoded), err)
+		}
+
+		reader := iotest.OneByteReader(brotli.NewReader(iotest.OneByteReader(bytes.NewReader(data))))
+		if decoded, err = io.ReadAll(reader); err != nil || !bytes.Equal(decoded, tc.Expected) {
+			t.Errorf("[ %s ]expected %d bytes a byte at a time, got %d %v", tc.Name, len(tc.Expected), len(decoded), err)
+		}
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	text := readTestdata(t, "text.q11.br")
+
+	testCases := []struct {
+		Name string
+		Data []byte
+		Err  error
+	}{
+		{Name: "no input", Err: io.ErrUnexpectedEOF},
+		{Name: "truncated", Data: text[:len(text)/2], Err: io.ErrUnexpectedEOF},
+		{Name: "missing last meta-block", Data: text[:len(text)-1], Err: io.ErrUnexpectedEOF},
+		{Name: "large window", Data: []byte{0x11}, Err: brotli.ErrCorrupt},
+		{Name: "non-zero padding", Data: []byte{0x0b, 0x02, 0x80, 'h', 'e', 'l', 'l', 'o', 0x83}, Err: brotli.ErrCorrupt},
+		{Name: "simple code with a repeated symbol", Data: []byte{0x02, 0x00, 0x00, 0x00, 0x54, 0x58, 0x18}, Err: brotli.ErrCorrupt},
+	}
+
+	for _, tc := range testCases {
+		_, err := io.ReadAll(brotli.NewReader(bytes.NewReader(tc.Data)))
+		if !errors.Is(err, tc.Err) {
+			t.Errorf("[ %s ]expected %v, got %v", tc.Name, tc.Err, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package brotli
+
+// transformKind names how a dictionary word is changed before the prefix
+// and suffix of its transform are added (RFC 7932 section 8).
+type transformKind uint8
+
+const (
+	identity transformKind = iota
+	omitLast1
+	omitLast2
+	omitLast3
+	omitLast4
+	omitLast5
+	omitLast6
+	omitLast7
+	omitLast8
+	omitLast9
+	uppercaseFirst
+	uppercaseAll
+	omitFirst1
+	omitFirst2
+	omitFirst3
+	omitFirst4
+	omitFirst5
+	omitFirst6
+	omitFirst7
+	omitFirst8
+	omitFirst9
+)
+
+type transform struct {
+	prefix string
+	kind   transformKind
+	suffix string
+}
+
+// transforms is the list of RFC 7932 appendix B, indexed by transform ID
+var transforms = [...]transform{
+	{"", identity, ""},
+	{"", identity, " "},
+	{" ", identity, " "},
+	{"", omitFirst1, ""},
+	{"", uppercaseFirst, " "},
+	{"", identity, " the "},
+	{" ", identity, ""},
+	{"s ", identity, " "},
+	{"", identity, " of "},
+	{"", uppercaseFirst, ""},
+	{"", identity, " and "},
+	{"", omitFirst2, ""},
+	{"", omitLast1, ""},
+	{", ", identity, " "},
+	{"", identity, ", "},
+	{" ", uppercaseFirst, " "},
+	{"", identity, " in "},
+	{"", identity, " to "},
+	{"e ", identity, " "},
+	{"", identity, "\""},
+	{"", identity, "."},
+	{"", identity, "\">"},
+	{"", identity, "\n"},
+	{"", omitLast3, ""},
+	{"", identity, "]"},
+	{"", identity, " for "},
+	{"", omitFirst3, ""},
+	{"", omitLast2, ""},
+	{"", identity, " a "},
+	{"", identity, " that "},
+	{" ", uppercaseFirst, ""},
+	{"", identity, ". "},
+	{".", identity, ""},
+	{" ", identity, ", "},
+	{"", omitFirst4, ""},
+	{"", identity, " with "},
+	{"", identity, "'"},
+	{"", identity, " from "},
+	{"", identity, " by "},
+	{"", omitFirst5, ""},
+	{"", omitFirst6, ""},
+	{" the ", identity, ""},
+	{"", omitLast4, ""},
+	{"", identity, ". The "},
+	{"", uppercaseAll, ""},
+	{"", identity, " on "},
+	{"", identity, " as "},
+	{"", identity, " is "},
+	{"", omitLast7, ""},
+	{"", omitLast1, "ing "},
+	{"", identity, "\n\t"},
+	{"", identity, ":"},
+	{" ", identity, ". "},
+	{"", identity, "ed "},
+	{"", omitFirst9, ""},
+	{"", omitFirst7, ""},
+	{"", omitLast6, ""},
+	{"", identity, "("},
+	{"", uppercaseFirst, ", "},
+	{"", omitLast8, ""},
+	{"", identity, " at "},
+	{"", identity, "ly "},
+	{" the ", identity, " of "},
+	{"", omitLast5, ""},
+	{"", omitLast9, ""},
+	{" ", uppercaseFirst, ", "},
+	{"", uppercaseFirst, "\""},
+	{".", identity, "("},
+	{"", uppercaseAll, " "},
+	{"", uppercaseFirst, "\">"},
+	{"", identity, "=\""},
+	{" ", identity, "."},
+	{".com/", identity, ""},
+	{" the ", identity, " of the "},
+	{"", uppercaseFirst, "'"},
+	{"", identity, ". This "},
+	{"", identity, ","},
+	{".", identity, " "},
+	{"", uppercaseFirst, "("},
+	{"", uppercaseFirst, "."},
+	{"", identity, " not "},
+	{" ", identity, "=\""},
+	{"", identity, "er "},
+	{" ", uppercaseAll, " "},
+	{"", identity, "al "},
+	{" ", uppercaseAll, ""},
+	{"", identity, "='"},
+	{"", uppercaseAll, "\""},
+	{"", uppercaseFirst, ". "},
+	{" ", identity, "("},
+	{"", identity, "ful "},
+	{" ", uppercaseFirst, ". "},
+	{"", identity, "ive "},
+	{"", identity, "less "},
+	{"", uppercaseAll, "'"},
+	{"", identity, "est "},
+	{" ", uppercaseFirst, "."},
+	{"", uppercaseAll, "\">"},
+	{" ", identity, "='"},
+	{"", uppercaseFirst, ","},
+	{"", identity, "ize "},
+	{"", uppercaseAll, "."},
+	{"\xc2\xa0", identity, ""},
+	{" ", identity, ","},
+	{"", uppercaseFirst, "=\""},
+	{"", uppercaseAll, "=\""},
+	{"", identity, "ous "},
+	{"", uppercaseAll, ", "},
+	{"", uppercaseFirst, "='"},
+	{" ", uppercaseFirst, ","},
+	{" ", uppercaseAll, "=\""},
+	{" ", uppercaseAll, ", "},
+	{"", uppercaseAll, ","},
+	{"", uppercaseAll, "("},
+	{"", uppercaseAll, ". "},
+	{" ", uppercaseAll, "."},
+	{"", uppercaseAll, "='"},
+	{" ", uppercaseAll, ". "},
+	{" ", uppercaseFirst, "=\""},
+	{" ", uppercaseAll, "='"},
+	{" ", uppercaseFirst, "='"},
+}
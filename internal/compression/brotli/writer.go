@@ -0,0 +1,259 @@
+package brotli
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+	"slices"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression/lz77"
+)
+
+const (
+	// writerWindowBits sets the window of the Writer, 256 KiB
+	writerWindowBits = 18
+	writerWindow     = 1<<writerWindowBits - 16
+	metaBlockSize    = 128 << 10
+	maxCopyLength    = 1 << 16
+	distanceAlphabet = numDistanceShort + 48
+)
+
+// the first insert-and-copy symbol of a cell, by insert and copy length
+// code ranges, for commands with an explicit distance
+var cellStart = [3][3]int{{128, 192, 384}, {256, 320, 512}, {448, 576, 640}}
+
+// command is an insert of literals followed by a copy. Its distance code
+// is -1 when no distance symbol follows, the copy repeats the last
+// distance through the symbol or is never made.
+type command struct {
+	insertCode, copyCode int
+	insertLength         int
+	copyLength           int
+	symbol               int
+	distanceCode         int
+	distanceExtra        int
+}
+
+// Writer compresses to a brotli stream. Every meta-block gets one prefix
+// code for its literals, commands and distances, and is stored when that
+// does not make it smaller.
+type Writer struct {
+	w       io.Writer
+	err     error
+	started bool
+	closed  bool
+
+	// history holds the window followed by the input not yet compressed,
+	// from pending
+	history  []byte
+	pending  int
+	matcher  lz77.Matcher
+	matches  []lz77.Match
+	commands []command
+	// lastDistance is the distance a distance code of 0 repeats
+	lastDistance int
+	bits         bitWriter
+}
+
+// NewWriter returns a Writer compressing into w. The stream is complete
+// once the Writer is closed.
+func NewWriter(w io.Writer) *Writer {
+	writer := &Writer{w: w, lastDistance: 4}
+	writer.matcher.MaxDistance = writerWindow
+	writer.matcher.MaxLength = maxCopyLength
+	return writer
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errors.New("brotli: write after close")
+	}
+	written := 0
+	for written < len(p) {
+		room := metaBlockSize - (len(w.history) - w.pending)
+		n := min(room, len(p)-written)
+		w.history = append(w.history, p[written:written+n]...)
+		written += n
+		if len(w.history)-w.pending == metaBlockSize {
+			w.writeMetaBlock()
+			if w.err = w.output(); w.err != nil {
+				return written, w.err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the input so far as a meta-block followed by an empty
+// metadata block, which pads the stream to a byte so the decoder has all
+// of it.
+func (w *Writer) Flush() error {
+	if w.err != nil || w.closed {
+		return w.err
+	}
+	w.writeMetaBlock()
+	if w.bits.nbits > 0 {
+		// ISLAST 0, MNIBBLES 0, reserved 0, MSKIPBYTES 0
+		w.bits.writeBits(0b0110, 6)
+		w.bits.alignToByte()
+	}
+	w.err = w.output()
+	return w.err
+}
+
+// Close ends the stream with an empty last meta-block, it does not close
+// the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil || w.closed {
+		return w.err
+	}
+	w.closed = true
+	w.writeMetaBlock()
+	// ISLAST and ISLASTEMPTY
+	w.bits.writeBits(0b11, 2)
+	w.bits.alignToByte()
+	w.err = w.output()
+	return w.err
+}
+
+func (w *Writer) output() error {
+	_, err := w.w.Write(w.bits.out)
+	w.bits.out = w.bits.out[:0]
+	return err
+}
+
+// writeMetaBlock compresses the pending input into a meta-block, or stores
+// it when that does not pay off.
+func (w *Writer) writeMetaBlock() {
+	if !w.started {
+		// WBITS, 17 plus the 3 bits after a 1
+		w.bits.writeBits(1|(writerWindowBits-17)<<1, 4)
+		w.started = true
+	}
+	block := w.history[w.pending:]
+	if len(block) == 0 {
+		return
+	}
+
+	saved, lastDistance := w.bits, w.lastDistance
+	w.writeMetaBlockHeader(len(block))
+	// ISUNCOMPRESSED
+	w.bits.writeBits(0, 1)
+	w.compressMetaBlock()
+	// the header of a stored meta-block takes 4 bytes at most
+	if len(w.bits.out)-len(saved.out) >= len(block)+4 {
+		w.bits, w.lastDistance = saved, lastDistance
+		w.writeMetaBlockHeader(len(block))
+		w.bits.writeBits(1, 1)
+		w.bits.alignToByte()
+		w.bits.out = append(w.bits.out, block...)
+	}
+
+	w.pending = len(w.history)
+	if drop := len(w.history) - writerWindow; drop >= metaBlockSize {
+		w.history = append(w.history[:0], w.history[drop:]...)
+		w.pending -= drop
+		w.matcher.Shift(drop)
+	}
+}
+
+// writeMetaBlockHeader writes ISLAST 0 and the length of a meta-block,
+// in as few nibbles as it fits.
+func (w *Writer) writeMetaBlockHeader(length int) {
+	nibbles := max((bits.Len(uint(length-1))+3)/4, 4)
+	w.bits.writeBits(0, 1)
+	w.bits.writeBits(uint64(nibbles-4), 2)
+	w.bits.writeBits(uint64(length-1), uint(nibbles*4))
+}
+
+// compressMetaBlock writes the pending input as the commands of a
+// compressed meta-block, with a single block type and prefix code per
+// category.
+func (w *Writer) compressMetaBlock() {
+	w.matches = w.matcher.Parse(w.matches[:0], w.history, w.pending)
+	w.commands = w.commands[:0]
+	var literalFreqs [literalAlphabet]uint32
+	var commandFreqs [insertCopyAlphabet]uint32
+	var distanceFreqs [distanceAlphabet]uint32
+	position := w.pending
+	for _, match := range w.matches {
+		for _, c := range w.history[position : position+match.Literals] {
+			literalFreqs[c]++
+		}
+		position += match.Literals + match.Length
+		if match.Literals == 0 && match.Length == 0 {
+			continue
+		}
+		cmd := w.newCommand(match)
+		commandFreqs[cmd.symbol]++
+		if cmd.distanceCode >= 0 {
+			distanceFreqs[cmd.distanceCode]++
+		}
+		w.commands = append(w.commands, cmd)
+	}
+
+	// NBLTYPESL, NBLTYPESI and NBLTYPESD of 1, NPOSTFIX and NDIRECT of 0,
+	// context mode LSB6, NTREESL and NTREESD of 1
+	w.bits.writeBits(0, 3+2+4+2+1+1)
+	literals := writePrefixCode(&w.bits, literalFreqs[:])
+	commands := writePrefixCode(&w.bits, commandFreqs[:])
+	distances := writePrefixCode(&w.bits, distanceFreqs[:])
+
+	position = w.pending
+	for _, cmd := range w.commands {
+		commands.write(&w.bits, cmd.symbol)
+		w.bits.writeBits(uint64(cmd.insertLength-int(insertLengthBase[cmd.insertCode])), uint(insertLengthExtra[cmd.insertCode]))
+		w.bits.writeBits(uint64(cmd.copyLength-int(copyLengthBase[cmd.copyCode])), uint(copyLengthExtra[cmd.copyCode]))
+		for _, c := range w.history[position : position+cmd.insertLength] {
+			literals.write(&w.bits, int(c))
+		}
+		if cmd.distanceCode >= 0 {
+			distances.write(&w.bits, cmd.distanceCode)
+			if cmd.distanceCode >= numDistanceShort {
+				w.bits.writeBits(uint64(cmd.distanceExtra), uint(1+(cmd.distanceCode-numDistanceShort)>>1))
+			}
+		}
+		position += cmd.insertLength + cmd.copyLength
+	}
+}
+
+// newCommand codes a match. The last one of a meta-block only inserts,
+// the decoder stops before its copy.
+func (w *Writer) newCommand(match lz77.Match) command {
+	// the copy of the last command is coded as the shortest one
+	cmd := command{insertLength: match.Literals, copyLength: max(match.Length, 2), distanceCode: -1}
+	cmd.insertCode = lengthCode(insertLengthBase[:], cmd.insertLength)
+	cmd.copyCode = lengthCode(copyLengthBase[:], cmd.copyLength)
+	implicit := cmd.insertCode < 8 && cmd.copyCode < 16
+	switch {
+	case match.Length > 0 && match.Distance != w.lastDistance:
+		// distance codes from 16 on are 2 bits of prefix and extra bits
+		value := match.Distance + 3
+		extraBits := bits.Len(uint(value)) - 2
+		prefix := value >> extraBits & 1
+		cmd.distanceCode = numDistanceShort + 2*(extraBits-1) + prefix
+		cmd.distanceExtra = value - (2+prefix)<<extraBits
+		w.lastDistance = match.Distance
+		implicit = false
+	case match.Length > 0 && !implicit:
+		cmd.distanceCode = 0
+	}
+
+	low := (cmd.insertCode&7)<<3 | cmd.copyCode&7
+	if implicit {
+		// cells 0 and 1 repeat the last distance
+		cmd.symbol = cmd.copyCode>>3<<6 | low
+	} else {
+		cmd.symbol = cellStart[cmd.insertCode>>3][cmd.copyCode>>3] | low
+	}
+	return cmd
+}
+
+// lengthCode is the code of a length, the last one whose base it reaches.
+func lengthCode(bases []uint32, length int) int {
+	code, _ := slices.BinarySearch(bases, uint32(length)+1)
+	return code - 1
+}
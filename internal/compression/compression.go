@@ -0,0 +1,229 @@
+package compression
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression/brotli"
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression/zstd"
+)
+
+// Identity is the coding of a representation sent as is.
+const Identity = "identity"
+
+var ErrNotAcceptable = errors.New("no acceptable content coding")
+
+// Coding is a content coding (RFC 9110 section 8.4.1), such as gzip. A
+// coding with only one of NewWriter and NewReader is used in that
+// direction only.
+type Coding struct {
+	// Name is the token used in Accept-Encoding and Content-Encoding
+	Name string
+	// NewWriter encodes everything written to the returned writer into w,
+	// the output is complete once the writer is closed
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+	// NewReader decodes what it reads from r, for request bodies
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// Registry holds the codings a server can choose from, in order of
+// preference for when the client accepts several equally.
+type Registry struct {
+	mu      sync.RWMutex
+	codings []Coding
+}
+
+// NewRegistry returns a registry with br, zstd, gzip and deflate, in that
+// order of preference. Others are added with Register.
+func NewRegistry() *Registry {
+	registry := &Registry{}
+	registry.Register(Coding{
+		Name: "deflate",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return zlib.NewWriter(w), nil
+		},
+		NewReader: zlib.NewReader,
+	})
+	registry.Register(Coding{
+		Name: "gzip",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	})
+	registry.Register(Coding{
+		Name: "zstd",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w), nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return zstd.NewReader(r), nil
+		},
+	})
+	registry.Register(Coding{
+		Name: "br",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return brotli.NewWriter(w), nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return brotli.NewReader(r), nil
+		},
+	})
+	return registry
+}
+
+// Register adds coding, replacing the one with the same name. It is
+// preferred over the codings registered before it.
+func (r *Registry) Register(coding Coding) {
+	coding.Name = strings.ToLower(coding.Name)
+	if coding.Name == "" || coding.Name == Identity || coding.NewWriter == nil && coding.NewReader == nil {
+		panic(fmt.Sprintf("compression: invalid coding %q", coding.Name))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.codings = slices.DeleteFunc(r.codings, func(existing Coding) bool {
+		return existing.Name == coding.Name
+	})
+	r.codings = slices.Insert(r.codings, 0, coding)
+}
+
+// Lookup returns the coding registered as name.
+func (r *Registry) Lookup(name string) (Coding, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name = strings.ToLower(name)
+	for _, coding := range r.codings {
+		if coding.Name == name {
+			return coding, true
+		}
+	}
+	return Coding{}, false
+}
+
+// Names lists the registered codings, most preferred first.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, len(r.codings))
+	for idx, coding := range r.codings {
+		names[idx] = coding.Name
+	}
+	return names
+}
+
+// Negotiate picks the coding for a request with the Accept-Encoding value
+// accept, present tells whether the request had the header at all. See
+// the package level Negotiate for the rules, identity is returned without
+// NewWriter. Only codings with a NewWriter take part.
+func (r *Registry) Negotiate(accept string, present bool) (Coding, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.codings))
+	for _, coding := range r.codings {
+		if coding.NewWriter != nil {
+			names = append(names, coding.Name)
+		}
+	}
+	r.mu.RUnlock()
+
+	name, err := Negotiate(accept, present, names)
+	if err != nil || name == Identity {
+		return Coding{Name: Identity}, err
+	}
+	coding, _ := r.Lookup(name)
+	return coding, nil
+}
+
+// Negotiate picks one of the available codings, listed in order of
+// preference, for the Accept-Encoding value accept. The coding with the
+// highest q-value wins, ties go to the preferred one. Identity is chosen
+// when no coding is accepted or the header is missing, ErrNotAcceptable
+// means the client excluded identity as well (RFC 9110 section 12.5.3).
+func Negotiate(accept string, present bool, available []string) (string, error) {
+	if !present {
+		return Identity, nil
+	}
+
+	weights := ParseAccept(accept)
+	star, hasStar := weights["*"]
+	weight := func(name string) float64 {
+		if q, listed := weights[name]; listed {
+			return q
+		}
+		if hasStar {
+			return star
+		}
+		return 0
+	}
+
+	best, bestQ := Identity, 0.0
+	for _, name := range available {
+		if q := weight(strings.ToLower(name)); q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+
+	identityQ, listed := weights[Identity]
+	if listed && identityQ > bestQ {
+		return Identity, nil
+	}
+	if bestQ > 0 {
+		return best, nil
+	}
+	// identity is acceptable unless excluded by name or by "*;q=0"
+	if listed && identityQ == 0 || !listed && hasStar && star == 0 {
+		return "", ErrNotAcceptable
+	}
+	return Identity, nil
+}
+
+// ParseAccept reads an Accept-Encoding value into the q-value of each
+// coding, 1 when none is given. Entries with an invalid q-value are
+// ignored, x-gzip is read as gzip.
+func ParseAccept(accept string) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, entry := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(entry, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+
+		q, valid := 1.0, true
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(key), "q") {
+				q, valid = parseQValue(strings.TrimSpace(value))
+			}
+		}
+		if valid {
+			weights[name] = q
+		}
+	}
+	return weights
+}
+
+// parseQValue reads a weight between 0 and 1 with at most three decimals
+// (RFC 9110 section 12.4.2).
+func parseQValue(value string) (float64, bool) {
+	if value == "" || len(value) > 5 || value[0] != '0' && value[0] != '1' {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(value, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
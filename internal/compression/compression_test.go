@@ -0,0 +1,277 @@
+package compression_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression"
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+)
+
+// nopCoding stands in for a coding registered by the application
+func nopCoding(name string) compression.Coding {
+	return compression.Coding{Name: name, NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return nopCloser{w}, nil
+	}}
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestNegotiate(t *testing.T) {
+	registry := compression.NewRegistry()
+	registry.Register(nopCoding("br"))
+	registry.Register(compression.Coding{Name: "zstd", NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(r), nil
+	}})
+
+	testCases := []struct {
+		Name        string
+		Accept      string
+		Missing     bool
+		Expected    string
+		ExpectError bool
+	}{
+		{Name: "no header", Missing: true, Expected: "identity"},
+		{Name: "empty header", Accept: "", Expected: "identity"},
+		{Name: "single coding", Accept: "gzip", Expected: "gzip"},
+		{Name: "preference breaks ties", Accept: "gzip, deflate, br", Expected: "br"},
+		{Name: "built in preference", Accept: "deflate, gzip", Expected: "gzip"},
+		{Name: "highest q wins", Accept: "gzip;q=0.5, deflate;q=0.8, br;q=0.1", Expected: "deflate"},
+		{Name: "q is case insensitive", Accept: "br;Q=0.2, gzip ; q=0.3", Expected: "gzip"},
+		{Name: "refused coding", Accept: "br;q=0, gzip", Expected: "gzip"},
+		{Name: "star", Accept: "*", Expected: "br"},
+		{Name: "star with exclusions", Accept: "*;q=0.5, br;q=0", Expected: "gzip"},
+		{Name: "x-gzip alias", Accept: "x-gzip", Expected: "gzip"},
+		{Name: "unknown coding", Accept: "compress", Expected: "identity"},
+		{Name: "decode only coding", Accept: "zstd", Expected: "identity"},
+		{Name: "identity preferred", Accept: "identity, gzip;q=0.5", Expected: "identity"},
+		{Name: "identity on par", Accept: "identity, gzip", Expected: "gzip"},
+		{Name: "invalid q ignored", Accept: "gzip;q=2, deflate", Expected: "deflate"},
+		{Name: "identity refused", Accept: "compress, identity;q=0", ExpectError: true},
+		{Name: "star refused", Accept: "*;q=0", ExpectError: true},
+		{Name: "star refused but identity listed", Accept: "*;q=0, identity;q=0.1", Expected: "identity"},
+	}
+
+	for _, tc := range testCases {
+		coding, err := registry.Negotiate(tc.Accept, !tc.Missing)
+		if tc.ExpectError {
+			if !errors.Is(err, compression.ErrNotAcceptable) {
+				t.Errorf("[ %s ]expected ErrNotAcceptable, got %v", tc.Name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[ %s ]unexpected error %v", tc.Name, err)
+			continue
+		}
+		if coding.Name != tc.Expected {
+			t.Errorf("[ %s ]expected %s, got %s", tc.Name, tc.Expected, coding.Name)
+		}
+		if (coding.Name == compression.Identity) != (coding.NewWriter == nil) {
+			t.Errorf("[ %s ]only identity comes without a writer", tc.Name)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	registry := compression.NewRegistry()
+	registry.Register(nopCoding("zstd"))
+	registry.Register(nopCoding("GZIP"))
+
+	names := registry.Names()
+	if len(names) != 4 || names[0] != "gzip" || names[1] != "zstd" || names[2] != "br" || names[3] != "deflate" {
+		t.Errorf("unexpected codings %v", names)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering identity should panic")
+		}
+	}()
+	registry.Register(nopCoding("identity"))
+}
+
+func TestDeflateIsZlib(t *testing.T) {
+	coding, _ := compression.NewRegistry().Lookup("deflate")
+	var buf bytes.Buffer
+	writer, _ := coding.NewWriter(&buf)
+	io.WriteString(writer, "hello")
+	writer.Close()
+
+	// deflate in HTTP is the zlib format (RFC 9110 section 8.4.1.2)
+	reader, err := zlib.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if body, _ := io.ReadAll(reader); string(body) != "hello" {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	policy := compression.DefaultPolicy()
+	testCases := []struct {
+		Name        string
+		ContentType string
+		Size        int64
+		Expected    bool
+	}{
+		{Name: "text", ContentType: "text/html; charset=utf-8", Size: 1024, Expected: true},
+		{Name: "json", ContentType: "Application/JSON", Size: 1024, Expected: true},
+		{Name: "unknown length", ContentType: "text/plain", Size: -1, Expected: true},
+		{Name: "too small", ContentType: "text/plain", Size: 100},
+		{Name: "already compressed", ContentType: "image/png", Size: 1024},
+		{Name: "octet stream", ContentType: "application/octet-stream", Size: 1024},
+		{Name: "no type", Size: 1024},
+	}
+
+	for _, tc := range testCases {
+		if allowed := policy.Allows(tc.ContentType, tc.Size); allowed != tc.Expected {
+			t.Errorf("[ %s ]expected %v, got %v", tc.Name, tc.Expected, allowed)
+		}
+	}
+}
+
+func TestOpenPrecompressed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"both.txt":        {Data: []byte("plain")},
+		"both.txt.gz":     {Data: []byte("gzip")},
+		"both.txt.br":     {Data: []byte("br")},
+		"gz.txt":          {Data: []byte("plain")},
+		"gz.txt.gz":       {Data: []byte("gzip")},
+		"plain.txt":       {Data: []byte("plain")},
+		"dir.txt.gz/file": {Data: []byte("not a sibling")},
+	}
+
+	testCases := []struct {
+		Name     string
+		File     string
+		Accept   string
+		Coding   string
+		Contents string
+		Varies   bool
+	}{
+		{Name: "brotli preferred", File: "both.txt", Accept: "gzip, br", Coding: "br", Contents: "br", Varies: true},
+		{Name: "by q-value", File: "both.txt", Accept: "gzip, br;q=0.5", Coding: "gzip", Contents: "gzip", Varies: true},
+		{Name: "only gzip there", File: "gz.txt", Accept: "br, gzip", Coding: "gzip", Contents: "gzip", Varies: true},
+		{Name: "not accepted", File: "gz.txt", Accept: "br", Varies: true},
+		{Name: "no siblings", File: "plain.txt", Accept: "gzip"},
+		{Name: "directories are no siblings", File: "dir.txt", Accept: "gzip"},
+	}
+
+	for _, tc := range testCases {
+		file, coding, varies := compression.OpenPrecompressed(fsys, tc.File, tc.Accept, true)
+		if coding != tc.Coding || varies != tc.Varies {
+			t.Errorf("[ %s ]expected %q varies %v, got %q varies %v", tc.Name, tc.Coding, tc.Varies, coding, varies)
+		}
+		if (file != nil) != (tc.Contents != "") {
+			t.Errorf("[ %s ]unexpected file %v", tc.Name, file)
+			continue
+		}
+		if file != nil {
+			contents, _ := io.ReadAll(file)
+			file.Close()
+			if string(contents) != tc.Contents {
+				t.Errorf("[ %s ]expected %q, got %q", tc.Name, tc.Contents, contents)
+			}
+		}
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		writer.Write(data)
+		writer.Close()
+		return buf.Bytes()
+	}
+	deflated := func(data []byte) []byte {
+		var buf bytes.Buffer
+		writer := zlib.NewWriter(&buf)
+		writer.Write(data)
+		writer.Close()
+		return buf.Bytes()
+	}
+
+	registry := compression.NewRegistry()
+	// a decode only coding registered by the application
+	registry.Register(compression.Coding{Name: "rev", NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		data, err := io.ReadAll(r)
+		slices.Reverse(data)
+		return io.NopCloser(bytes.NewReader(data)), err
+	}})
+
+	testCases := []struct {
+		Name     string
+		Encoding []string
+		Body     []byte
+		Status   httpcore.HttpStatus
+		Expected string
+		Err      error
+	}{
+		{Name: "no coding", Body: []byte("plain"), Status: httpcore.StatusOK, Expected: "plain"},
+		{Name: "identity", Encoding: []string{"identity"}, Body: []byte("plain"), Status: httpcore.StatusOK, Expected: "plain"},
+		{Name: "gzip", Encoding: []string{"gzip"}, Body: gzipped([]byte("hello")), Status: httpcore.StatusOK, Expected: "hello"},
+		{Name: "x-gzip", Encoding: []string{"X-GZIP"}, Body: gzipped([]byte("hello")), Status: httpcore.StatusOK, Expected: "hello"},
+		{Name: "deflate", Encoding: []string{"deflate"}, Body: deflated([]byte("hello")), Status: httpcore.StatusOK, Expected: "hello"},
+		{Name: "codings undone in reverse", Encoding: []string{"deflate, gzip"}, Body: gzipped(deflated([]byte("hello"))), Status: httpcore.StatusOK, Expected: "hello"},
+		{Name: "codings over several lines", Encoding: []string{"deflate", "gzip"}, Body: gzipped(deflated([]byte("hello"))), Status: httpcore.StatusOK, Expected: "hello"},
+		// "hello" as the reference encoders write it
+		{Name: "br", Encoding: []string{"br"}, Body: []byte("\x0b\x02\x80hello\x03"), Status: httpcore.StatusOK, Expected: "hello"},
+		{Name: "zstd", Encoding: []string{"zstd"}, Body: []byte("\x28\xb5\x2f\xfd\x04\x58\x29\x00\x00hello\xa3\x6d\x9f\x88"), Status: httpcore.StatusOK, Expected: "hello"},
+		{Name: "registered decoder", Encoding: []string{"rev"}, Body: []byte("olleh"), Status: httpcore.StatusOK, Expected: "hello"},
+		{Name: "exactly the limit", Encoding: []string{"gzip"}, Body: gzipped(bytes.Repeat([]byte("a"), 64)), Status: httpcore.StatusOK, Expected: strings.Repeat("a", 64)},
+		{Name: "over the limit", Encoding: []string{"gzip"}, Body: gzipped(bytes.Repeat([]byte("a"), 65)), Status: httpcore.StatusOK, Expected: strings.Repeat("a", 64), Err: compression.ErrBodyTooLarge},
+		{Name: "unsupported coding", Encoding: []string{"compress"}, Body: []byte("data"), Status: httpcore.StatusUnsupportedMediaType},
+		{Name: "corrupt body", Encoding: []string{"gzip"}, Body: []byte("not gzip"), Status: httpcore.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		headers := make(httpcore.HeaderMap)
+		for _, line := range tc.Encoding {
+			headers.Add("Content-Encoding", line)
+		}
+		headers.Set("Content-Length", strconv.Itoa(len(tc.Body)))
+		request := httpcore.Request{Headers: headers, Body: io.NopCloser(bytes.NewReader(tc.Body)), ContentLength: int64(len(tc.Body))}
+
+		var body []byte
+		var err error
+		handler := httpcore.Wrap(func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+			if r.Headers.Has("content-encoding") && tc.Encoding[0] != "identity" {
+				t.Errorf("[ %s ]Content-Encoding left on a decoded request", tc.Name)
+			}
+			body, err = io.ReadAll(r.Body)
+			w.SetStatus(httpcore.StatusOK)
+		}, compression.Decompress(registry, 64))
+
+		response := httpcore.NewHttpResponseWriter()
+		handler(request, &response)
+		if response.Status() != tc.Status {
+			t.Errorf("[ %s ]expected status %d, got %d", tc.Name, tc.Status, response.Status())
+			continue
+		}
+		if tc.Status == httpcore.StatusUnsupportedMediaType {
+			if accept, _ := response.GetHeader("Accept-Encoding"); accept != "rev, br, zstd, gzip, deflate" {
+				t.Errorf("[ %s ]unexpected Accept-Encoding %q", tc.Name, accept)
+			}
+			continue
+		}
+		if tc.Status != httpcore.StatusOK {
+			continue
+		}
+		if string(body) != tc.Expected || !errors.Is(err, tc.Err) {
+			t.Errorf("[ %s ]expected %q %v, got %q %v", tc.Name, tc.Expected, tc.Err, body, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+)
+
+var ErrBodyTooLarge = errors.New("decoded request body exceeds the limit")
+
+// Decompress is a middleware decoding request bodies sent with a
+// Content-Encoding, so handlers read them as sent by the application. At
+// most limit decoded bytes are read, past that the body fails with
+// ErrBodyTooLarge, which keeps a small upload from inflating without end.
+// A coding the registry cannot decode is answered with 415 and the codings
+// it can in Accept-Encoding. A nil registry stands for NewRegistry.
+func Decompress(registry *Registry, limit int64) httpcore.Middleware {
+	if registry == nil {
+		registry = NewRegistry()
+	}
+
+	return func(next httpcore.HandlerFunc) httpcore.HandlerFunc {
+		return func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+			codings := contentCodings(r.Headers.Values("content-encoding"))
+			if len(codings) == 0 {
+				next(r, w)
+				return
+			}
+
+			decoders := make([]Coding, 0, len(codings))
+			for _, name := range codings {
+				coding, ok := registry.Lookup(name)
+				if !ok || coding.NewReader == nil {
+					w.SetHeader("Accept-Encoding", strings.Join(registry.decoderNames(), ", "))
+					w.SetStatus(httpcore.StatusUnsupportedMediaType)
+					return
+				}
+				decoders = append(decoders, coding)
+			}
+
+			body, err := decodeBody(r.Body, decoders, limit)
+			if err != nil {
+				w.SetStatus(httpcore.StatusBadRequest)
+				return
+			}
+
+			r.Body = body
+			r.ContentLength = -1
+			r.Headers = cloneHeaders(r.Headers)
+			r.Headers.Del("content-encoding")
+			r.Headers.Del("content-length")
+			next(r, w)
+		}
+	}
+}
+
+// contentCodings lists the codings of a Content-Encoding header in the
+// order they were applied, identity left out.
+func contentCodings(lines []string) []string {
+	codings := make([]string, 0)
+	for _, line := range lines {
+		for _, coding := range strings.Split(line, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "x-gzip" {
+				coding = "gzip"
+			}
+			if coding != "" && coding != Identity {
+				codings = append(codings, coding)
+			}
+		}
+	}
+	return codings
+}
+
+// decodeBody undoes the codings of body, the last one applied first.
+func decodeBody(body io.ReadCloser, codings []Coding, limit int64) (io.ReadCloser, error) {
+	decoded := &decodedBody{source: body}
+	var reader io.Reader = body
+	for _, coding := range slices.Backward(codings) {
+		decoder, err := coding.NewReader(reader)
+		if err != nil {
+			decoded.Close()
+			return nil, fmt.Errorf("%s: %w", coding.Name, err)
+		}
+		decoded.decoders = append(decoded.decoders, decoder)
+		reader = decoder
+	}
+	decoded.reader = reader
+	decoded.remaining = limit
+	return decoded, nil
+}
+
+// decodedBody reads the decoded body up to a limit, closing it closes the
+// decoders and the body they read from.
+type decodedBody struct {
+	source    io.ReadCloser
+	decoders  []io.ReadCloser
+	reader    io.Reader
+	remaining int64
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	if d.remaining <= 0 {
+		// one more byte tells a body of exactly limit bytes from a larger one
+		var probe [1]byte
+		n, err := d.reader.Read(probe[:])
+		if n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n, err := d.reader.Read(p)
+	d.remaining -= int64(n)
+	return n, err
+}
+
+func (d *decodedBody) Close() error {
+	for _, decoder := range slices.Backward(d.decoders) {
+		decoder.Close()
+	}
+	return d.source.Close()
+}
+
+func (r *Registry) decoderNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.codings))
+	for _, coding := range r.codings {
+		if coding.NewReader != nil {
+			names = append(names, coding.Name)
+		}
+	}
+	return names
+}
+
+func cloneHeaders(headers httpcore.HeaderMap) httpcore.HeaderMap {
+	cloned := make(httpcore.HeaderMap, len(headers))
+	for key, values := range headers {
+		cloned[key] = values
+	}
+	return cloned
+}
@@ -0,0 +1,92 @@
+// Package huffman builds the length limited prefix codes the br and zstd
+// encoders share.
+package huffman
+
+import (
+	"math/bits"
+	"slices"
+)
+
+// node is a leaf of the tree when left is -1, with its symbol in right.
+type node struct {
+	weight uint64
+	left   int32
+	right  int32
+}
+
+// Lengths returns the code length of every symbol for the frequencies
+// freqs, none longer than maxLength. Unused symbols get 0 and a single
+// used symbol gets 1. Rare symbols are counted as more frequent until the
+// code fits, the way the reference brotli encoder limits its codes.
+func Lengths(freqs []uint32, maxLength int) []uint8 {
+	lengths := make([]uint8, len(freqs))
+	used := make([]int32, 0, len(freqs))
+	for symbol, freq := range freqs {
+		if freq > 0 {
+			used = append(used, int32(symbol))
+		}
+	}
+	switch {
+	case len(used) == 0:
+		return lengths
+	case len(used) == 1:
+		lengths[used[0]] = 1
+		return lengths
+	case bits.Len(uint(len(used)-1)) > maxLength:
+		panic("huffman: too many symbols for the length limit")
+	}
+
+	for floor := uint64(1); ; floor *= 2 {
+		if build(lengths, freqs, used, floor) <= maxLength {
+			return lengths
+		}
+	}
+}
+
+// build fills lengths with a Huffman code counting every frequency as at
+// least floor and returns the longest length.
+func build(lengths []uint8, freqs []uint32, used []int32, floor uint64) int {
+	nodes := make([]node, 0, 2*len(used))
+	for _, symbol := range used {
+		nodes = append(nodes, node{weight: max(uint64(freqs[symbol]), floor), left: -1, right: symbol})
+	}
+	slices.SortStableFunc(nodes, func(a, b node) int {
+		switch {
+		case a.weight < b.weight:
+			return -1
+		case a.weight > b.weight:
+			return 1
+		}
+		return 0
+	})
+
+	// leaves and merged nodes both come out in order of weight, the
+	// lightest two of the two queues are merged next
+	leaf, merged := 0, len(nodes)
+	lightest := func() int32 {
+		if leaf < len(used) && (merged == len(nodes) || nodes[leaf].weight <= nodes[merged].weight) {
+			leaf++
+			return int32(leaf - 1)
+		}
+		merged++
+		return int32(merged - 1)
+	}
+	for range len(used) - 1 {
+		left, right := lightest(), lightest()
+		nodes = append(nodes, node{weight: nodes[left].weight + nodes[right].weight, left: left, right: right})
+	}
+
+	longest := 0
+	depths := make([]uint8, len(nodes))
+	for idx := len(nodes) - 1; idx >= 0; idx-- {
+		n := nodes[idx]
+		if n.left == -1 {
+			lengths[n.right] = depths[idx]
+			longest = max(longest, int(depths[idx]))
+			continue
+		}
+		depths[n.left] = depths[idx] + 1
+		depths[n.right] = depths[idx] + 1
+	}
+	return longest
+}
@@ -0,0 +1,53 @@
+package huffman_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression/huffman"
+)
+
+func TestLengths(t *testing.T) {
+	// Fibonacci frequencies make the deepest tree for their count
+	fibonacci := []uint32{1, 1}
+	for len(fibonacci) < 30 {
+		fibonacci = append(fibonacci, fibonacci[len(fibonacci)-1]+fibonacci[len(fibonacci)-2])
+	}
+
+	testCases := []struct {
+		Name      string
+		Freqs     []uint32
+		MaxLength int
+		Expected  []uint8
+	}{
+		{Name: "no symbols", Freqs: []uint32{0, 0, 0}, MaxLength: 15, Expected: []uint8{0, 0, 0}},
+		{Name: "single symbol", Freqs: []uint32{0, 7, 0}, MaxLength: 15, Expected: []uint8{0, 1, 0}},
+		{Name: "two symbols", Freqs: []uint32{3, 0, 9}, MaxLength: 15, Expected: []uint8{1, 0, 1}},
+		{Name: "skewed", Freqs: []uint32{8, 4, 2, 1, 1}, MaxLength: 15, Expected: []uint8{1, 2, 3, 4, 4}},
+		{Name: "balanced", Freqs: []uint32{5, 5, 5, 5}, MaxLength: 15, Expected: []uint8{2, 2, 2, 2}},
+		{Name: "limited", Freqs: []uint32{8, 4, 2, 1, 1}, MaxLength: 3, Expected: []uint8{1, 3, 3, 3, 3}},
+		{Name: "deep tree limited", Freqs: fibonacci, MaxLength: 11},
+	}
+
+	for _, tc := range testCases {
+		lengths := huffman.Lengths(tc.Freqs, tc.MaxLength)
+		if tc.Expected != nil && !slices.Equal(lengths, tc.Expected) {
+			t.Errorf("[ %s ]expected %v, got %v", tc.Name, tc.Expected, lengths)
+		}
+
+		// the code of two or more symbols is complete, Kraft sum of 1
+		used, sum := 0, 0
+		for _, length := range lengths {
+			if int(length) > tc.MaxLength {
+				t.Errorf("[ %s ]length %d over the limit", tc.Name, length)
+			}
+			if length > 0 {
+				used++
+				sum += 1 << (tc.MaxLength - int(length))
+			}
+		}
+		if used > 1 && sum != 1<<tc.MaxLength {
+			t.Errorf("[ %s ]incomplete code %v", tc.Name, lengths)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package lz77 finds the repeated strings the br and zstd encoders turn
+// into copies.
+package lz77
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// MinLength is the shortest match found
+const MinLength = 4
+
+const (
+	hashBits  = 15
+	chainBits = 16
+	chainMask = 1<<chainBits - 1
+	// maxChain bounds the candidates tried at one position
+	maxChain = 32
+	// goodLength is long enough to stop looking for a longer match
+	goodLength = 128
+)
+
+// Match is a run of Literals bytes followed by a copy of Length bytes from
+// Distance back. The last match of a block has Length 0 and holds the
+// literals at its end.
+type Match struct {
+	Literals int
+	Length   int
+	Distance int
+}
+
+// Matcher remembers where strings were seen. It is used for one stream,
+// every block parsed in the same buffer, which keeps the window before the
+// block. Positions are stored +1, 0 is none.
+type Matcher struct {
+	// MaxDistance and MaxLength bound the copies of the format
+	MaxDistance int
+	MaxLength   int
+	// head holds the last position of a hash, chain the one before a
+	// position with the same hash, for the last 1<<chainBits positions
+	head  [1 << hashBits]int32
+	chain [1 << chainBits]int32
+}
+
+func hash(v uint32) uint32 {
+	return v * 0x9e3779b1 >> (32 - hashBits)
+}
+
+// Shift tells the matcher the first n bytes of the buffer were dropped.
+func (m *Matcher) Shift(n int) {
+	for idx, position := range m.head {
+		m.head[idx] = max(position-int32(n), 0)
+	}
+	// chain is indexed by position, rotate it along
+	shift := n & chainMask
+	slices.Reverse(m.chain[:shift])
+	slices.Reverse(m.chain[shift:])
+	slices.Reverse(m.chain[:])
+	for idx, position := range m.chain {
+		m.chain[idx] = max(position-int32(n), 0)
+	}
+}
+
+func (m *Matcher) insert(src []byte, position int) {
+	slot := &m.head[hash(binary.LittleEndian.Uint32(src[position:]))]
+	m.chain[position&chainMask] = *slot
+	*slot = int32(position + 1)
+}
+
+// longest returns the longest match for position among the strings seen
+// before it.
+func (m *Matcher) longest(src []byte, position int) (int, int) {
+	current := binary.LittleEndian.Uint32(src[position:])
+	limit := min(len(src)-position, m.MaxLength)
+	bestLength, bestDistance := 0, 0
+	candidate := int(m.head[hash(current)]) - 1
+	for tries := 0; candidate >= 0 && tries < maxChain; tries++ {
+		distance := position - candidate
+		if distance > m.MaxDistance || distance > chainMask {
+			break
+		}
+		if binary.LittleEndian.Uint32(src[candidate:]) == current && src[candidate+bestLength] == src[position+bestLength] {
+			length := MinLength
+			for length < limit && src[candidate+length] == src[position+length] {
+				length++
+			}
+			if length > bestLength {
+				bestLength, bestDistance = length, distance
+				if length >= min(limit, goodLength) {
+					break
+				}
+			}
+		}
+		next := int(m.chain[candidate&chainMask]) - 1
+		if next >= candidate {
+			break
+		}
+		candidate = next
+	}
+	return bestLength, bestDistance
+}
+
+// Parse appends the matches of src[start:] to dst, copies may reach back
+// into src[:start]. A match is put off by a byte when the next position
+// matches longer, and the parser skips ahead faster while nothing matches,
+// so incompressible data passes quickly.
+func (m *Matcher) Parse(dst []Match, src []byte, start int) []Match {
+	literalStart, position, misses := start, start, 0
+	last := len(src) - MinLength
+	for position <= last {
+		length, distance := m.longest(src, position)
+		m.insert(src, position)
+		if length < MinLength {
+			misses++
+			position += 1 + misses>>5
+			continue
+		}
+		for length < goodLength && position+1 <= last {
+			nextLength, nextDistance := m.longest(src, position+1)
+			if nextLength <= length {
+				break
+			}
+			position++
+			m.insert(src, position)
+			length, distance = nextLength, nextDistance
+		}
+
+		dst = append(dst, Match{Literals: position - literalStart, Length: length, Distance: distance})
+		for inside := position + 1; inside < position+length && inside <= last; inside++ {
+			m.insert(src, inside)
+		}
+		position += length
+		literalStart, misses = position, 0
+	}
+	return append(dst, Match{Literals: len(src) - literalStart})
+}
@@ -0,0 +1,90 @@
+package lz77_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression/lz77"
+)
+
+// expand rebuilds src[start:] from its matches.
+func expand(t *testing.T, out []byte, src []byte, start int, matches []lz77.Match) []byte {
+	t.Helper()
+	position := start
+	for _, match := range matches {
+		out = append(out, src[position:position+match.Literals]...)
+		position += match.Literals
+		for range match.Length {
+			out = append(out, out[len(out)-match.Distance])
+		}
+		position += match.Length
+	}
+	return out
+}
+
+func TestParse(t *testing.T) {
+	text, err := os.ReadFile(filepath.Join("..", "testdata", "text.txt"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	testCases := []struct {
+		Name        string
+		Input       []byte
+		MaxDistance int
+		MaxLength   int
+		// MaxMatches bounds the matches of repetitive input
+		MaxMatches int
+	}{
+		{Name: "empty", Input: []byte{}, MaxDistance: 1 << 16, MaxLength: 258, MaxMatches: 1},
+		{Name: "too short to match", Input: []byte("abc"), MaxDistance: 1 << 16, MaxLength: 258, MaxMatches: 1},
+		{Name: "run", Input: bytes.Repeat([]byte("a"), 1000), MaxDistance: 1 << 16, MaxLength: 258, MaxMatches: 6},
+		{Name: "repeated text", Input: bytes.Repeat(text[:500], 20), MaxDistance: 1 << 16, MaxLength: 1 << 16, MaxMatches: 200},
+		{Name: "text", Input: text, MaxDistance: 1 << 16, MaxLength: 258},
+		{Name: "short distances", Input: bytes.Repeat(text[:500], 4), MaxDistance: 100, MaxLength: 258},
+	}
+
+	for _, tc := range testCases {
+		matcher := &lz77.Matcher{MaxDistance: tc.MaxDistance, MaxLength: tc.MaxLength}
+		matches := matcher.Parse(nil, tc.Input, 0)
+		for _, match := range matches[:len(matches)-1] {
+			if match.Length < lz77.MinLength || match.Length > tc.MaxLength || match.Distance > tc.MaxDistance {
+				t.Errorf("[ %s ]match out of bounds %+v", tc.Name, match)
+			}
+		}
+		if last := matches[len(matches)-1]; last.Length != 0 {
+			t.Errorf("[ %s ]expected the last match to hold only literals, got %+v", tc.Name, last)
+		}
+		if tc.MaxMatches > 0 && len(matches) > tc.MaxMatches {
+			t.Errorf("[ %s ]expected at most %d matches, got %d", tc.Name, tc.MaxMatches, len(matches))
+		}
+		if out := expand(t, nil, tc.Input, 0, matches); !bytes.Equal(out, tc.Input) {
+			t.Errorf("[ %s ]matches do not rebuild the input", tc.Name)
+		}
+	}
+}
+
+func TestShift(t *testing.T) {
+	text, err := os.ReadFile(filepath.Join("..", "testdata", "text.txt"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	input := bytes.Repeat(text, 3)
+	matcher := &lz77.Matcher{MaxDistance: len(text), MaxLength: 258}
+
+	// the first copy is parsed, dropped, and the rest matched against it
+	window := input[:2*len(text)]
+	matches := matcher.Parse(nil, window, 0)
+	out := expand(t, nil, window, 0, matches)
+	matcher.Shift(len(text))
+	window = input[len(text):]
+	matches = matcher.Parse(nil, window, len(text))
+	if len(matches) > len(text)/200 {
+		t.Errorf("expected the shifted window to match, got %d matches", len(matches))
+	}
+	if out = expand(t, out, window, len(text), matches); !bytes.Equal(out, input) {
+		t.Errorf("matches do not rebuild the input after Shift")
+	}
+}
@@ -0,0 +1,72 @@
+package compression
+
+import (
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+)
+
+// Policy decides which responses are worth compressing.
+type Policy struct {
+	// MinSize is the smallest body compressed, in bytes. Below it the
+	// coding overhead outweighs the savings.
+	MinSize int64
+	// Types lists the media types compressed, "text/*" stands for every
+	// subtype. Responses without a Content-Type are not compressed.
+	Types []string
+}
+
+// DefaultPolicy compresses text formats from 256 bytes on. Images, archives
+// and other binary formats are compressed already.
+func DefaultPolicy() Policy {
+	return Policy{
+		MinSize: 256,
+		Types: []string{
+			"text/*",
+			"application/json",
+			"application/javascript",
+			"application/xml",
+			"application/xhtml+xml",
+			"application/rss+xml",
+			"application/atom+xml",
+			"application/ld+json",
+			"application/manifest+json",
+			"application/problem+json",
+			"application/wasm",
+			"image/svg+xml",
+		},
+	}
+}
+
+// Allows reports whether a body of size bytes with contentType is
+// compressed, a negative size stands for a length not known yet.
+func (p Policy) Allows(contentType string, size int64) bool {
+	if size >= 0 && size < p.MinSize {
+		return false
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	for _, pattern := range p.Types {
+		pattern = strings.ToLower(pattern)
+		if pattern == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Disable is a middleware turning compression off for the routes it is
+// used on, such as ones serving data that has to reach the client as is.
+func Disable(next httpcore.HandlerFunc) httpcore.HandlerFunc {
+	return func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.DisableCompression()
+		next(r, w)
+	}
+}
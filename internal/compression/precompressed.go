@@ -0,0 +1,45 @@
+package compression
+
+import (
+	"io/fs"
+)
+
+// precompressed siblings by coding, in order of preference
+var siblings = []struct {
+	coding string
+	suffix string
+}{
+	{coding: "br", suffix: ".br"},
+	{coding: "gzip", suffix: ".gz"},
+}
+
+// OpenPrecompressed opens the sibling of name holding its content already
+// encoded, name+".br" for br or name+".gz" for gzip, picking the one the
+// Accept-Encoding value accept prefers. It returns a nil file when no
+// sibling is acceptable. varies reports whether name has any sibling, the
+// response then varies on Accept-Encoding.
+func OpenPrecompressed(fsys fs.FS, name string, accept string, present bool) (file fs.File, coding string, varies bool) {
+	available := make([]string, 0, len(siblings))
+	for _, sibling := range siblings {
+		if info, err := fs.Stat(fsys, name+sibling.suffix); err == nil && info.Mode().IsRegular() {
+			available = append(available, sibling.coding)
+		}
+	}
+	if len(available) == 0 {
+		return nil, "", false
+	}
+
+	coding, err := Negotiate(accept, present, available)
+	if err != nil || coding == Identity {
+		return nil, "", true
+	}
+	for _, sibling := range siblings {
+		if sibling.coding != coding {
+			continue
+		}
+		if file, err := fsys.Open(name + sibling.suffix); err == nil {
+			return file, coding, true
+		}
+	}
+	return nil, "", true
+}
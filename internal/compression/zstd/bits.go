@@ -0,0 +1,116 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// backwardReader reads a bitstream from its end, the way FSE and Huffman
+// streams are written (RFC 8878 section 4.1). pos counts the bits left,
+// reading past the start yields zeros and a negative pos.
+type backwardReader struct {
+	data []byte
+	pos  int
+}
+
+// init starts reading data below its end mark, the highest set bit of the
+// last byte.
+func (b *backwardReader) init(data []byte) error {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return corrupt("bitstream without end mark")
+	}
+	b.data = data
+	b.pos = len(data)*8 - 8 + bits.Len8(data[len(data)-1]) - 1
+	return nil
+}
+
+// peek returns the next n bits, up to 56, without consuming them.
+func (b *backwardReader) peek(n uint) uint64 {
+	if n == 0 || b.pos <= 0 {
+		return 0
+	}
+	lo, shift := b.pos-int(n), uint(0)
+	if lo < 0 {
+		lo, shift = 0, uint(-lo)
+	}
+	start := lo >> 3
+	var v uint64
+	if start+8 <= len(b.data) {
+		v = binary.LittleEndian.Uint64(b.data[start:])
+	} else {
+		for idx := len(b.data) - 1; idx >= start; idx-- {
+			v = v<<8 | uint64(b.data[idx])
+		}
+	}
+	v >>= uint(lo & 7)
+	return v << shift & (1<<n - 1)
+}
+
+func (b *backwardReader) readBits(n uint) uint64 {
+	v := b.peek(n)
+	b.pos -= int(n)
+	return v
+}
+
+// overflowed reports a read past the start of the stream.
+func (b *backwardReader) overflowed() bool {
+	return b.pos < 0
+}
+
+// forwardReader reads a bitstream least significant bit first, the way
+// FSE distributions are written.
+type forwardReader struct {
+	data []byte
+	pos  int
+}
+
+// peek returns the next n bits, up to 32, reading zeros past the end.
+func (f *forwardReader) peek(n uint) uint32 {
+	var v uint64
+	start := f.pos >> 3
+	for idx := min(start+5, len(f.data)) - 1; idx >= start; idx-- {
+		v = v<<8 | uint64(f.data[idx])
+	}
+	v >>= uint(f.pos & 7)
+	return uint32(v & (1<<n - 1))
+}
+
+func (f *forwardReader) readBits(n uint) uint32 {
+	v := f.peek(n)
+	f.pos += int(n)
+	return v
+}
+
+// bytesRead is the number of bytes the bits read so far touch.
+func (f *forwardReader) bytesRead() int {
+	return (f.pos + 7) >> 3
+}
+
+// bitWriter writes a bitstream least significant bit first. Read with a
+// backwardReader, the bits written last come out first.
+type bitWriter struct {
+	out   []byte
+	value uint64
+	nbits uint
+}
+
+// writeBits writes the low n bits of v, up to 32.
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	w.value |= (v & (1<<n - 1)) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.value))
+		w.value >>= 8
+		w.nbits -= 8
+	}
+}
+
+// close writes the end mark a backwardReader starts below and pads the
+// last byte.
+func (w *bitWriter) close() []byte {
+	w.writeBits(1, 1)
+	if w.nbits > 0 {
+		w.writeBits(0, 8-w.nbits)
+	}
+	return w.out
+}
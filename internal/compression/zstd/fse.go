@@ -0,0 +1,216 @@
+package zstd
+
+import (
+	"math/bits"
+	"slices"
+)
+
+// fseEntry is a state of an FSE table, the state that follows it is base
+// plus the next bits bits of the stream.
+type fseEntry struct {
+	symbol uint8
+	bits   uint8
+	base   uint16
+}
+
+// fseTable decodes a finite state entropy coded stream (RFC 8878 section
+// 4.1).
+type fseTable struct {
+	log     uint
+	entries []fseEntry
+}
+
+// lessThanOne is the normalized count of a symbol with a probability below
+// 1/2^log, it still takes one state.
+const lessThanOne = -1
+
+// readDistribution reads the normalized counts of an FSE table from the
+// start of src and returns them with their accuracy log and the bytes
+// taken (RFC 8878 section 4.1.1).
+func readDistribution(src []byte, maxSymbol int, maxLog uint) ([]int16, uint, int, error) {
+	f := forwardReader{data: src}
+	log := uint(f.readBits(4)) + 5
+	if log > maxLog {
+		return nil, 0, 0, corrupt("accuracy log too large")
+	}
+
+	var counts []int16
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := log + 1
+	for remaining > 1 {
+		if len(counts) > maxSymbol {
+			return nil, 0, 0, corrupt("too many symbols")
+		}
+		largest := 2*threshold - 1 - remaining
+		count := int(f.peek(nbBits - 1))
+		if count < largest {
+			f.pos += int(nbBits) - 1
+		} else {
+			count = int(f.readBits(nbBits))
+			if count >= threshold {
+				count -= largest
+			}
+		}
+		count--
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		if remaining < 1 {
+			return nil, 0, 0, corrupt("distribution overflows the table")
+		}
+		counts = append(counts, int16(count))
+
+		// a zero is followed by 2 bit repeat flags for more zeros
+		for repeat := count == 0; repeat; {
+			zeros := int(f.readBits(2))
+			for range zeros {
+				counts = append(counts, 0)
+			}
+			repeat = zeros == 3
+		}
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if len(counts) > maxSymbol+1 {
+		return nil, 0, 0, corrupt("too many symbols")
+	}
+	if f.bytesRead() > len(src) {
+		return nil, 0, 0, corrupt("truncated distribution")
+	}
+	return counts, log, f.bytesRead(), nil
+}
+
+// newFSETable spreads the symbols of a distribution over the states.
+func newFSETable(counts []int16, log uint) (*fseTable, error) {
+	size := 1 << log
+	table := &fseTable{log: log, entries: make([]fseEntry, size)}
+	next := make([]int, len(counts))
+	high := size - 1
+	for symbol, count := range counts {
+		if count == lessThanOne {
+			table.entries[high].symbol = uint8(symbol)
+			high--
+			next[symbol] = 1
+		} else {
+			next[symbol] = int(count)
+		}
+	}
+
+	step := size>>1 + size>>3 + 3
+	position := 0
+	for symbol, count := range counts {
+		for range max(count, 0) {
+			table.entries[position].symbol = uint8(symbol)
+			for position = (position + step) & (size - 1); position > high; {
+				position = (position + step) & (size - 1)
+			}
+		}
+	}
+	if position != 0 {
+		return nil, corrupt("invalid distribution")
+	}
+
+	for idx := range table.entries {
+		entry := &table.entries[idx]
+		state := next[entry.symbol]
+		next[entry.symbol]++
+		entry.bits = uint8(log - uint(bits.Len(uint(state))-1))
+		entry.base = uint16(state<<entry.bits - size)
+	}
+	return table, nil
+}
+
+// rleTable is the table of a single symbol, its one state takes no bits
+func rleTable(symbol uint8) *fseTable {
+	return &fseTable{entries: []fseEntry{{symbol: symbol}}}
+}
+
+// next moves state past its symbol.
+func (t *fseTable) next(state int, b *backwardReader) int {
+	entry := t.entries[state]
+	return int(entry.base) + int(b.readBits(uint(entry.bits)))
+}
+
+// fseSymbolTransform moves the encoder state past a symbol, the way
+// FSE_buildCTable of the reference encoder lays it out.
+type fseSymbolTransform struct {
+	findState int
+	nbBits    uint32
+}
+
+// fseEncoder writes symbols a fseTable of the same distribution decodes.
+type fseEncoder struct {
+	log        uint
+	states     []uint16
+	transforms []fseSymbolTransform
+}
+
+func newFSEEncoder(counts []int16, log uint) *fseEncoder {
+	size := 1 << log
+	encoder := &fseEncoder{log: log, states: make([]uint16, size), transforms: make([]fseSymbolTransform, len(counts))}
+
+	// the states of every symbol, in the order the decoder table spreads them
+	decoder, err := newFSETable(counts, log)
+	if err != nil {
+		panic(err)
+	}
+	cumulative := make([]int, len(counts)+1)
+	for symbol, count := range counts {
+		cumulative[symbol+1] = cumulative[symbol] + max(int(count), 1)
+		if count == 0 {
+			cumulative[symbol+1]--
+		}
+	}
+	next := slices.Clone(cumulative)
+	for state, entry := range decoder.entries {
+		encoder.states[next[entry.symbol]] = uint16(size + state)
+		next[entry.symbol]++
+	}
+
+	total := 0
+	for symbol, count := range counts {
+		transform := &encoder.transforms[symbol]
+		switch count {
+		case 0:
+			transform.nbBits = uint32(log+1)<<16 - uint32(size)
+		case lessThanOne, 1:
+			transform.nbBits = uint32(log)<<16 - uint32(size)
+			transform.findState = total - 1
+			total++
+		default:
+			maxBits := log - uint(bits.Len(uint(count-1))-1)
+			transform.nbBits = uint32(maxBits)<<16 - uint32(count)<<maxBits
+			transform.findState = total - int(count)
+			total += int(count)
+		}
+	}
+	return encoder
+}
+
+// begin returns the state that ends the stream with symbol, the cheapest
+// one.
+func (e *fseEncoder) begin(symbol uint8) uint32 {
+	transform := e.transforms[symbol]
+	nbBits := (transform.nbBits + 1<<15) >> 16
+	value := nbBits<<16 - transform.nbBits
+	return uint32(e.states[int(value>>nbBits)+transform.findState])
+}
+
+// encode writes the bits that lead from the state of symbol to state and
+// returns the state of symbol.
+func (e *fseEncoder) encode(w *bitWriter, state uint32, symbol uint8) uint32 {
+	transform := e.transforms[symbol]
+	nbBits := (state + transform.nbBits) >> 16
+	w.writeBits(uint64(state), uint(nbBits))
+	return uint32(e.states[int(state>>nbBits)+transform.findState])
+}
+
+// end writes the state the decoder starts from.
+func (e *fseEncoder) end(w *bitWriter, state uint32) {
+	w.writeBits(uint64(state), e.log)
+}
@@ -0,0 +1,371 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"slices"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression/huffman"
+)
+
+const (
+	maxHuffmanBits      = 11
+	maxHuffmanWeightLog = 6
+	maxBlockSize        = 128 << 10
+)
+
+type huffmanEntry struct {
+	symbol uint8
+	bits   uint8
+}
+
+// huffmanTable decodes the literals of a block, indexed by the next
+// maxBits bits of the stream.
+type huffmanTable struct {
+	maxBits uint
+	entries []huffmanEntry
+}
+
+// readHuffmanTable reads a Huffman tree description and returns the table
+// with the bytes it took (RFC 8878 section 4.2.1).
+func readHuffmanTable(src []byte) (*huffmanTable, int, error) {
+	if len(src) == 0 {
+		return nil, 0, corrupt("missing Huffman tree")
+	}
+	header := int(src[0])
+	if header < 128 {
+		if 1+header > len(src) {
+			return nil, 0, corrupt("truncated Huffman tree")
+		}
+		weights, err := readWeights(src[1 : 1+header])
+		if err != nil {
+			return nil, 0, err
+		}
+		table, err := newHuffmanTable(weights)
+		return table, 1 + header, err
+	}
+
+	count := header - 127
+	size := 1 + (count+1)/2
+	if size > len(src) {
+		return nil, 0, corrupt("truncated Huffman tree")
+	}
+	weights := make([]uint8, count)
+	for idx := range weights {
+		weights[idx] = src[1+idx/2] >> 4
+		if idx%2 == 1 {
+			weights[idx] = src[1+idx/2] & 0xf
+		}
+	}
+	table, err := newHuffmanTable(weights)
+	return table, size, err
+}
+
+// readWeights decodes FSE compressed weights, two states take turns until
+// the stream runs out.
+func readWeights(src []byte) ([]uint8, error) {
+	counts, log, n, err := readDistribution(src, maxHuffmanBits+1, maxHuffmanWeightLog)
+	if err != nil {
+		return nil, err
+	}
+	table, err := newFSETable(counts, log)
+	if err != nil {
+		return nil, err
+	}
+	var b backwardReader
+	if err := b.init(src[n:]); err != nil {
+		return nil, err
+	}
+
+	states := [2]int{int(b.readBits(log)), int(b.readBits(log))}
+	var weights []uint8
+	for turn := 0; ; turn ^= 1 {
+		if len(weights) >= 255 {
+			return nil, corrupt("too many Huffman weights")
+		}
+		weights = append(weights, table.entries[states[turn]].symbol)
+		states[turn] = table.next(states[turn], &b)
+		if b.overflowed() {
+			return append(weights, table.entries[states[turn^1]].symbol), nil
+		}
+	}
+}
+
+// newHuffmanTable builds the table of weights, the weight of the last
+// symbol is implied by the others.
+func newHuffmanTable(weights []uint8) (*huffmanTable, error) {
+	if len(weights) > 255 {
+		return nil, corrupt("too many Huffman weights")
+	}
+	total := 0
+	for _, weight := range weights {
+		if weight > maxHuffmanBits {
+			return nil, corrupt("invalid Huffman weight")
+		}
+		if weight > 0 {
+			total += 1 << (weight - 1)
+		}
+	}
+	if total == 0 {
+		return nil, corrupt("empty Huffman tree")
+	}
+	maxBits := uint(bits.Len(uint(total)))
+	leftover := 1<<maxBits - total
+	if maxBits > maxHuffmanBits || leftover&(leftover-1) != 0 {
+		return nil, corrupt("invalid Huffman weights")
+	}
+	weights = append(weights, uint8(bits.Len(uint(leftover))))
+
+	table := &huffmanTable{maxBits: maxBits, entries: make([]huffmanEntry, 1<<maxBits)}
+	position := 0
+	for weight := uint8(1); uint(weight) <= maxBits; weight++ {
+		for symbol, w := range weights {
+			if w != weight {
+				continue
+			}
+			entry := huffmanEntry{symbol: uint8(symbol), bits: uint8(maxBits + 1 - uint(weight))}
+			for range 1 << (weight - 1) {
+				table.entries[position] = entry
+				position++
+			}
+		}
+	}
+	return table, nil
+}
+
+// decode fills dst from one Huffman coded stream, which has to end with
+// the last symbol.
+func (t *huffmanTable) decode(dst []byte, src []byte) error {
+	var b backwardReader
+	if err := b.init(src); err != nil {
+		return err
+	}
+	for idx := range dst {
+		entry := t.entries[b.peek(t.maxBits)]
+		b.pos -= int(entry.bits)
+		dst[idx] = entry.symbol
+	}
+	if b.pos != 0 {
+		return corrupt("Huffman stream size mismatch")
+	}
+	return nil
+}
+
+// decodeStreams fills dst from the four streams of src, split by a jump
+// table of the sizes of the first three.
+func (t *huffmanTable) decodeStreams(dst []byte, src []byte) error {
+	if len(src) < 10 {
+		return corrupt("truncated jump table")
+	}
+	segment := (len(dst) + 3) / 4
+	if 3*segment > len(dst) {
+		return corrupt("too few literals for four streams")
+	}
+	src, jumps := src[6:], src[:6]
+	for idx := range 4 {
+		size := len(src)
+		if idx < 3 {
+			size = int(binary.LittleEndian.Uint16(jumps[idx*2:]))
+			if size > len(src) {
+				return corrupt("invalid jump table")
+			}
+		}
+		out := dst[:min(segment, len(dst))]
+		if idx == 3 {
+			out = dst
+		}
+		if err := t.decode(out, src[:size]); err != nil {
+			return err
+		}
+		dst, src = dst[len(out):], src[size:]
+	}
+	return nil
+}
+
+// huffmanEncoder holds the code of every literal, the way the decoder
+// table assigns them: by weight, then by symbol.
+type huffmanEncoder struct {
+	codes   [256]uint16
+	lengths [256]uint8
+	// description is the Huffman tree description of the code
+	description []byte
+}
+
+// newHuffmanEncoder builds a code for the literal frequencies freqs, which
+// have at least two symbols. It returns nil when the tree cannot be
+// described.
+func newHuffmanEncoder(freqs []uint32) *huffmanEncoder {
+	lengths := huffman.Lengths(freqs, maxHuffmanBits)
+	maxBits, last := uint8(0), 0
+	for symbol, length := range lengths {
+		if length > 0 {
+			maxBits, last = max(maxBits, length), symbol
+		}
+	}
+
+	encoder := &huffmanEncoder{}
+	weights := make([]uint8, last)
+	for symbol, length := range lengths[:last] {
+		if length > 0 {
+			weights[symbol] = maxBits + 1 - length
+		}
+	}
+	start := 0
+	for weight := uint8(1); weight <= maxBits; weight++ {
+		for symbol, length := range lengths {
+			if length > 0 && maxBits+1-length == weight {
+				encoder.codes[symbol] = uint16(start >> (weight - 1))
+				encoder.lengths[symbol] = length
+				start += 1 << (weight - 1)
+			}
+		}
+	}
+
+	encoder.description = compressWeights(weights)
+	if len(weights) <= 128 && (encoder.description == nil || len(encoder.description) > 1+(len(weights)+1)/2) {
+		encoder.description = []byte{byte(127 + len(weights))}
+		for idx := 0; idx < len(weights); idx += 2 {
+			pair := weights[idx] << 4
+			if idx+1 < len(weights) {
+				pair |= weights[idx+1]
+			}
+			encoder.description = append(encoder.description, pair)
+		}
+	}
+	if encoder.description == nil {
+		return nil
+	}
+	return encoder
+}
+
+// compressWeights writes the weights FSE compressed. It returns nil when
+// they do not fit or do not read back.
+func compressWeights(weights []uint8) []byte {
+	var freqs [maxHuffmanBits + 1]int
+	largest := 0
+	for _, weight := range weights {
+		freqs[weight]++
+		largest = max(largest, freqs[weight])
+	}
+	if largest == len(weights) || len(weights) < 2 {
+		return nil
+	}
+
+	counts := normalize(freqs[:], len(weights), maxHuffmanWeightLog)
+	description := []byte{0}
+	description = appendDistribution(description, counts, maxHuffmanWeightLog)
+	encoder := newFSEEncoder(counts, maxHuffmanWeightLog)
+
+	// two states take turns, the first one takes the even weights
+	var w bitWriter
+	var states [2]uint32
+	turn := (len(weights) - 1) & 1
+	states[turn] = encoder.begin(weights[len(weights)-1])
+	states[turn^1] = encoder.begin(weights[len(weights)-2])
+	for idx := len(weights) - 3; idx >= 0; idx-- {
+		states[idx&1] = encoder.encode(&w, states[idx&1], weights[idx])
+	}
+	encoder.end(&w, states[1])
+	encoder.end(&w, states[0])
+	description = append(description, w.close()...)
+
+	size := len(description) - 1
+	if size >= 128 {
+		return nil
+	}
+	description[0] = byte(size)
+	if decoded, err := readWeights(description[1:]); err != nil || !slices.Equal(decoded, weights) {
+		return nil
+	}
+	return description
+}
+
+// normalize scales the symbol frequencies freqs of total to sum up to
+// 1<<log, every present symbol keeping at least 1.
+func normalize(freqs []int, total int, log uint) []int16 {
+	last := 0
+	for symbol, freq := range freqs {
+		if freq > 0 {
+			last = symbol
+		}
+	}
+	counts := make([]int16, last+1)
+	sum, largest := 0, 0
+	for symbol := range counts {
+		if freqs[symbol] == 0 {
+			continue
+		}
+		counts[symbol] = int16(max(freqs[symbol]<<log/total, 1))
+		sum += int(counts[symbol])
+		if counts[symbol] > counts[largest] {
+			largest = symbol
+		}
+	}
+	for ; sum > 1<<log; sum-- {
+		for counts[largest] <= 1 {
+			largest = (largest + 1) % len(counts)
+		}
+		counts[largest]--
+	}
+	counts[largest] += int16(1<<log - sum)
+	return counts
+}
+
+// appendDistribution writes the normalized counts of an FSE table, the
+// reverse of readDistribution.
+func appendDistribution(dst []byte, counts []int16, log uint) []byte {
+	var w bitWriter
+	w.out = dst
+	w.writeBits(uint64(log-5), 4)
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := log + 1
+	for symbol := 0; symbol < len(counts) && remaining > 1; {
+		count := int(counts[symbol])
+		symbol++
+		largest := 2*threshold - 1 - remaining
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		value := count + 1
+		if value >= threshold {
+			value += largest
+		}
+		if value < largest {
+			w.writeBits(uint64(value), nbBits-1)
+		} else {
+			w.writeBits(uint64(value), nbBits)
+		}
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+
+		if count == 0 {
+			zeros := 0
+			for symbol+zeros < len(counts) && counts[symbol+zeros] == 0 {
+				zeros++
+			}
+			symbol += zeros
+			for ; zeros >= 3; zeros -= 3 {
+				w.writeBits(3, 2)
+			}
+			w.writeBits(uint64(zeros), 2)
+		}
+	}
+	if w.nbits > 0 {
+		w.writeBits(0, 8-w.nbits)
+	}
+	return w.out
+}
+
+// encode writes literals to w backwards, so they decode in order.
+func (e *huffmanEncoder) encode(w *bitWriter, literals []byte) []byte {
+	for idx := len(literals) - 1; idx >= 0; idx-- {
+		c := literals[idx]
+		w.writeBits(uint64(e.codes[c]), uint(e.lengths[c]))
+	}
+	return w.close()
+}
@@ -0,0 +1,424 @@
+// Package zstd implements the Zstandard format of RFC 8878, the zstd
+// content coding.
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrCorrupt is wrapped by the errors of a malformed stream.
+var ErrCorrupt = errors.New("zstd: corrupt stream")
+
+// ErrWindowTooLarge is returned for frames that need more than MaxWindow
+// bytes of history.
+var ErrWindowTooLarge = errors.New("zstd: window too large")
+
+// MaxWindow is the largest window the Reader accepts, the size RFC 8878
+// recommends decoders support.
+const MaxWindow = 8 << 20
+
+func corrupt(reason string) error {
+	return fmt.Errorf("%w: %s", ErrCorrupt, reason)
+}
+
+const (
+	frameMagic         = 0xfd2fb528
+	skippableMagic     = 0x184d2a50
+	skippableMagicMask = 0xfffffff0
+)
+
+// block types
+const (
+	blockRaw = iota
+	blockRLE
+	blockCompressed
+)
+
+// literals section types
+const (
+	literalsRaw = iota
+	literalsRLE
+	literalsCompressed
+	literalsTreeless
+)
+
+// Reader decompresses a sequence of zstd frames. Dictionaries are not
+// supported.
+type Reader struct {
+	r   io.Reader
+	err error
+	// out holds the history of the frame followed by the bytes Read has not
+	// returned yet, from rpos
+	out      []byte
+	rpos     int
+	block    []byte
+	literals []byte
+	frames   int
+
+	// frame
+	inFrame     bool
+	lastBlock   bool
+	window      int
+	checksum    bool
+	contentSize int64
+	frameSize   int64
+	hash        xxh64
+	huffman     *huffmanTable
+	tables      [3]*fseTable
+	reps        [3]int
+}
+
+// NewReader returns a Reader decompressing from r. Read errors wrap
+// ErrCorrupt when the stream is malformed.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for r.rpos == len(r.out) {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.decode()
+	}
+	n := copy(p, r.out[r.rpos:])
+	r.rpos += n
+	return n, nil
+}
+
+// Close releases the window, it does not close the underlying reader.
+func (r *Reader) Close() error {
+	r.out, r.block, r.literals = nil, nil, nil
+	r.rpos = 0
+	if r.err == nil {
+		r.err = errors.New("zstd: reader closed")
+	}
+	return nil
+}
+
+// decode reads the next frame header, block or frame end. It returns
+// io.EOF once the input ends between frames.
+func (r *Reader) decode() error {
+	r.compact()
+	switch {
+	case !r.inFrame:
+		return r.readFrameHeader()
+	case r.lastBlock:
+		return r.readFrameEnd()
+	default:
+		return r.readBlock()
+	}
+}
+
+// compact drops the history no longer reachable, keeping at least the
+// window behind the unread output.
+func (r *Reader) compact() {
+	keep := max(0, min(r.rpos, len(r.out)-r.window))
+	if keep < maxBlockSize || keep < len(r.out)/2 {
+		return
+	}
+	r.out = append(r.out[:0], r.out[keep:]...)
+	r.rpos -= keep
+}
+
+func (r *Reader) readFull(p []byte) error {
+	if _, err := io.ReadFull(r.r, p); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *Reader) readFrameHeader() error {
+	var magic [4]byte
+	if n, err := io.ReadFull(r.r, magic[:]); err != nil {
+		if n == 0 && errors.Is(err, io.EOF) && r.frames > 0 {
+			return io.EOF
+		}
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	r.frames++
+
+	switch number := binary.LittleEndian.Uint32(magic[:]); {
+	case number&skippableMagicMask == skippableMagic:
+		if err := r.readFull(magic[:]); err != nil {
+			return err
+		}
+		size := int64(binary.LittleEndian.Uint32(magic[:]))
+		if n, err := io.CopyN(io.Discard, r.r, size); n < size {
+			if err == nil || errors.Is(err, io.EOF) {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		return nil
+	case number != frameMagic:
+		return corrupt("unknown magic number")
+	}
+
+	var descriptor [1]byte
+	if err := r.readFull(descriptor[:]); err != nil {
+		return err
+	}
+	contentSizeFlag := descriptor[0] >> 6
+	singleSegment := descriptor[0]&0x20 != 0
+	if descriptor[0]&0x08 != 0 {
+		return corrupt("reserved frame header bit set")
+	}
+	dictionarySize := [4]int{0, 1, 2, 4}[descriptor[0]&3]
+	contentSizeSize := [4]int{0, 2, 4, 8}[contentSizeFlag]
+	if singleSegment && contentSizeFlag == 0 {
+		contentSizeSize = 1
+	}
+	windowSize := 1
+	if singleSegment {
+		windowSize = 0
+	}
+
+	header := make([]byte, windowSize+dictionarySize+contentSizeSize)
+	if err := r.readFull(header); err != nil {
+		return err
+	}
+	if windowSize > 0 {
+		exponent, mantissa := header[0]>>3, int64(header[0]&7)
+		base := int64(1) << (10 + exponent)
+		window := base + base/8*mantissa
+		if window > MaxWindow {
+			return ErrWindowTooLarge
+		}
+		r.window = int(window)
+	}
+	for _, c := range header[windowSize : windowSize+dictionarySize] {
+		if c != 0 {
+			return errors.New("zstd: dictionaries are not supported")
+		}
+	}
+	r.contentSize = -1
+	if contentSizeSize > 0 {
+		field := header[windowSize+dictionarySize:]
+		var size [8]byte
+		copy(size[:], field)
+		r.contentSize = int64(binary.LittleEndian.Uint64(size[:]))
+		if contentSizeSize == 2 {
+			r.contentSize += 256
+		}
+		if r.contentSize < 0 {
+			return ErrWindowTooLarge
+		}
+	}
+	if singleSegment {
+		if r.contentSize > MaxWindow {
+			return ErrWindowTooLarge
+		}
+		r.window = int(r.contentSize)
+	}
+
+	r.inFrame, r.lastBlock = true, false
+	r.checksum = descriptor[0]&0x04 != 0
+	r.frameSize = 0
+	r.hash.reset()
+	r.huffman = nil
+	r.tables = [3]*fseTable{}
+	r.reps = [3]int{1, 4, 8}
+	return nil
+}
+
+func (r *Reader) readFrameEnd() error {
+	if r.contentSize >= 0 && r.frameSize != r.contentSize {
+		return corrupt("frame content size mismatch")
+	}
+	if r.checksum {
+		var checksum [4]byte
+		if err := r.readFull(checksum[:]); err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(checksum[:]) != uint32(r.hash.sum()) {
+			return corrupt("checksum mismatch")
+		}
+	}
+	r.inFrame = false
+	return nil
+}
+
+func (r *Reader) readBlock() error {
+	var header [3]byte
+	if err := r.readFull(header[:]); err != nil {
+		return err
+	}
+	value := uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16
+	r.lastBlock = value&1 != 0
+	size := int(value >> 3)
+	blockMax := min(r.window, maxBlockSize)
+
+	start := len(r.out)
+	switch value >> 1 & 3 {
+	case blockRaw:
+		if size > blockMax {
+			return corrupt("block too large")
+		}
+		r.out = append(r.out, make([]byte, size)...)
+		if err := r.readFull(r.out[start:]); err != nil {
+			return err
+		}
+		r.frameSize += int64(size)
+	case blockRLE:
+		if size > blockMax {
+			return corrupt("block too large")
+		}
+		var c [1]byte
+		if err := r.readFull(c[:]); err != nil {
+			return err
+		}
+		for range size {
+			r.out = append(r.out, c[0])
+		}
+		r.frameSize += int64(size)
+	case blockCompressed:
+		if size > blockMax {
+			return corrupt("block too large")
+		}
+		if cap(r.block) < size {
+			r.block = make([]byte, size, maxBlockSize)
+		}
+		r.block = r.block[:size]
+		if err := r.readFull(r.block); err != nil {
+			return err
+		}
+		if err := r.decodeBlock(r.block); err != nil {
+			return err
+		}
+		if len(r.out)-start > blockMax {
+			return corrupt("block too large")
+		}
+	default:
+		return corrupt("reserved block type")
+	}
+
+	if r.contentSize >= 0 && r.frameSize > r.contentSize {
+		return corrupt("frame content size mismatch")
+	}
+	if r.checksum {
+		r.hash.write(r.out[start:])
+	}
+	return nil
+}
+
+// decodeBlock appends the content of a compressed block, its literals
+// followed by its sequences (RFC 8878 section 3.1.1.3).
+func (r *Reader) decodeBlock(src []byte) error {
+	literals, n, err := r.readLiterals(src)
+	if err != nil {
+		return err
+	}
+	count, size, err := readSequenceCount(src[n:])
+	if err != nil {
+		return err
+	}
+	n += size
+	if count == 0 {
+		if n != len(src) {
+			return corrupt("data after the sequence count")
+		}
+		r.out = append(r.out, literals...)
+		r.frameSize += int64(len(literals))
+		return nil
+	}
+
+	size, err = r.readSequenceTables(src[n:])
+	if err != nil {
+		return err
+	}
+	return r.executeSequences(src[n+size:], count, literals)
+}
+
+// readLiterals reads the literals section at the start of src and returns
+// the literals with the size of the section.
+func (r *Reader) readLiterals(src []byte) ([]byte, int, error) {
+	if len(src) == 0 {
+		return nil, 0, corrupt("missing literals section")
+	}
+	kind, format := src[0]&3, src[0]>>2&3
+
+	if kind == literalsRaw || kind == literalsRLE {
+		size, n := int(src[0]>>3), 1
+		switch format {
+		case 1:
+			if len(src) < 2 {
+				return nil, 0, corrupt("truncated literals header")
+			}
+			size, n = int(src[0]>>4)+int(src[1])<<4, 2
+		case 3:
+			if len(src) < 3 {
+				return nil, 0, corrupt("truncated literals header")
+			}
+			size, n = int(src[0]>>4)+int(src[1])<<4+int(src[2])<<12, 3
+		}
+		if size > maxBlockSize {
+			return nil, 0, corrupt("too many literals")
+		}
+		if kind == literalsRaw {
+			if n+size > len(src) {
+				return nil, 0, corrupt("truncated literals")
+			}
+			return src[n : n+size], n + size, nil
+		}
+		if n >= len(src) {
+			return nil, 0, corrupt("truncated literals")
+		}
+		r.literals = r.literals[:0]
+		for range size {
+			r.literals = append(r.literals, src[n])
+		}
+		return r.literals, n + 1, nil
+	}
+
+	n, sizeBits := [4]int{3, 3, 4, 5}[format], [4]uint{10, 10, 14, 18}[format]
+	if len(src) < n {
+		return nil, 0, corrupt("truncated literals header")
+	}
+	var field [8]byte
+	copy(field[:], src[:n])
+	value := binary.LittleEndian.Uint64(field[:])
+	size := int(value >> 4 & (1<<sizeBits - 1))
+	compressedSize := int(value >> (4 + sizeBits) & (1<<sizeBits - 1))
+	if size > maxBlockSize {
+		return nil, 0, corrupt("too many literals")
+	}
+	if n+compressedSize > len(src) {
+		return nil, 0, corrupt("truncated literals")
+	}
+
+	streams := src[n : n+compressedSize]
+	if kind == literalsCompressed {
+		table, tableSize, err := readHuffmanTable(streams)
+		if err != nil {
+			return nil, 0, err
+		}
+		r.huffman, streams = table, streams[tableSize:]
+	} else if r.huffman == nil {
+		return nil, 0, corrupt("no Huffman tree to repeat")
+	}
+
+	if cap(r.literals) < size {
+		r.literals = make([]byte, size, maxBlockSize)
+	}
+	r.literals = r.literals[:size]
+	var err error
+	if format == 0 {
+		err = r.huffman.decode(r.literals, streams)
+	} else {
+		err = r.huffman.decodeStreams(r.literals, streams)
+	}
+	return r.literals, n + compressedSize, err
+}
@@ -0,0 +1,85 @@
+package zstd_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression/zstd"
+)
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "testdata", name))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return data
+}
+
+func TestReader(t *testing.T) {
+	text := readTestdata(t, "text.txt")
+	random := readTestdata(t, "random.bin")
+	skippable := []byte{0x5a, 0x2a, 0x4d, 0x18, 3, 0, 0, 0, 'a', 'b', 'c'}
+
+	// the frames were made with the reference encoder
+	testCases := []struct {
+		Name     string
+		Data     []byte
+		Expected []byte
+	}{
+		{Name: "default level", Data: readTestdata(t, "text.l3.zst"), Expected: text},
+		{Name: "compressed tables", Data: readTestdata(t, "text.l19.zst"), Expected: text},
+		{Name: "no checksum or content size", Data: readTestdata(t, "text.nocheck.zst"), Expected: text},
+		{Name: "raw blocks", Data: readTestdata(t, "random.zst"), Expected: random},
+		{Name: "output past the window", Data: readTestdata(t, "repeated.w10.zst"), Expected: bytes.Repeat(text[:600], 256)},
+		{
+			Name:     "several frames",
+			Data:     bytes.Join([][]byte{readTestdata(t, "text.l3.zst"), skippable, readTestdata(t, "random.zst")}, nil),
+			Expected: append(append([]byte{}, text...), random...),
+		},
+	}
+
+	for _, tc := range testCases {
+		decoded, err := io.ReadAll(zstd.NewReader(bytes.NewReader(tc.Data)))
+		if err != nil || !bytes.Equal(decoded, tc.Expected) {
+			t.Errorf("[ %s ]expected %d bytes, got %d %v", tc.Name, len(tc.Expected), len(decoded), err)
+		}
+
+		reader := iotest.OneByteReader(zstd.NewReader(iotest.OneByteReader(bytes.NewReader(tc.Data))))
+		if decoded, err = io.ReadAll(reader); err != nil || !bytes.Equal(decoded, tc.Expected) {
+			t.Errorf("[ %s ]expected %d bytes a byte at a time, got %d %v", tc.Name, len(tc.Expected), len(decoded), err)
+		}
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	text := readTestdata(t, "text.l3.zst")
+	flipped := readTestdata(t, "random.zst")
+	flipped[len(flipped)/2] ^= 1
+
+	testCases := []struct {
+		Name string
+		Data []byte
+		Err  error
+	}{
+		{Name: "no input", Err: io.ErrUnexpectedEOF},
+		{Name: "truncated", Data: text[:len(text)/2], Err: io.ErrUnexpectedEOF},
+		{Name: "missing checksum", Data: text[:len(text)-2], Err: io.ErrUnexpectedEOF},
+		{Name: "not zstd", Data: []byte("plain text"), Err: zstd.ErrCorrupt},
+		{Name: "checksum mismatch", Data: flipped, Err: zstd.ErrCorrupt},
+		{Name: "reserved block type", Data: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x00, 0x07, 0x00, 0x00}, Err: zstd.ErrCorrupt},
+		{Name: "window too large", Data: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x70, 0x01, 0x00, 0x00}, Err: zstd.ErrWindowTooLarge},
+	}
+
+	for _, tc := range testCases {
+		_, err := io.ReadAll(zstd.NewReader(bytes.NewReader(tc.Data)))
+		if !errors.Is(err, tc.Err) {
+			t.Errorf("[ %s ]expected %v, got %v", tc.Name, tc.Err, err)
+		}
+	}
+}
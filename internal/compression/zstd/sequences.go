@@ -0,0 +1,375 @@
+package zstd
+
+import (
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// sequence codes map to a base plus extra bits (RFC 8878 section 3.1.1.3.2.1)
+var (
+	literalLengthBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	literalLengthExtra = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	matchLengthBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	matchLengthExtra = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// the default distributions of the predefined mode
+var (
+	literalLengthDefault = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	matchLengthDefault = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	offsetDefault = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+// the sequence tables come in this order
+const (
+	literalLengths = iota
+	offsets
+	matchLengths
+)
+
+// sequenceKind describes the table of one sequence field.
+type sequenceKind struct {
+	maxSymbol  int
+	maxLog     uint
+	defaults   []int16
+	defaultLog uint
+	predefined *fseTable
+}
+
+var sequenceKinds = [3]sequenceKind{
+	literalLengths: {maxSymbol: 35, maxLog: 9, defaults: literalLengthDefault, defaultLog: 6, predefined: mustFSETable(literalLengthDefault, 6)},
+	offsets:        {maxSymbol: 31, maxLog: 8, defaults: offsetDefault, defaultLog: 5, predefined: mustFSETable(offsetDefault, 5)},
+	matchLengths:   {maxSymbol: 52, maxLog: 9, defaults: matchLengthDefault, defaultLog: 6, predefined: mustFSETable(matchLengthDefault, 6)},
+}
+
+func mustFSETable(counts []int16, log uint) *fseTable {
+	table, err := newFSETable(counts, log)
+	if err != nil {
+		panic(err)
+	}
+	return table
+}
+
+// compression modes of the sequence tables
+const (
+	modePredefined = iota
+	modeRLE
+	modeCompressed
+	modeRepeat
+)
+
+// readSequenceCount reads the number of sequences of a block.
+func readSequenceCount(src []byte) (int, int, error) {
+	switch {
+	case len(src) == 0:
+		return 0, 0, corrupt("missing sequences section")
+	case src[0] < 128:
+		return int(src[0]), 1, nil
+	case src[0] < 255:
+		if len(src) < 2 {
+			return 0, 0, corrupt("truncated sequence count")
+		}
+		return int(src[0]-128)<<8 + int(src[1]), 2, nil
+	default:
+		if len(src) < 3 {
+			return 0, 0, corrupt("truncated sequence count")
+		}
+		return int(src[1]) + int(src[2])<<8 + 0x7f00, 3, nil
+	}
+}
+
+// readSequenceTables reads the tables of the sequences, repeated tables are
+// the ones of the previous block.
+func (r *Reader) readSequenceTables(src []byte) (int, error) {
+	if len(src) == 0 {
+		return 0, corrupt("missing compression modes")
+	}
+	modes := src[0]
+	if modes&3 != 0 {
+		return 0, corrupt("reserved compression mode bits set")
+	}
+	n := 1
+	for field, kind := range sequenceKinds {
+		switch (modes >> (6 - 2*field)) & 3 {
+		case modePredefined:
+			r.tables[field] = kind.predefined
+		case modeRLE:
+			if n >= len(src) {
+				return 0, corrupt("truncated RLE symbol")
+			}
+			if int(src[n]) > kind.maxSymbol {
+				return 0, corrupt("invalid RLE symbol")
+			}
+			r.tables[field] = rleTable(src[n])
+			n++
+		case modeCompressed:
+			counts, log, size, err := readDistribution(src[n:], kind.maxSymbol, kind.maxLog)
+			if err != nil {
+				return 0, err
+			}
+			if r.tables[field], err = newFSETable(counts, log); err != nil {
+				return 0, err
+			}
+			n += size
+		case modeRepeat:
+			if r.tables[field] == nil {
+				return 0, corrupt("no table to repeat")
+			}
+		}
+	}
+	return n, nil
+}
+
+// executeSequences decodes the sequences of src and appends the block they
+// make out of literals to the output.
+func (r *Reader) executeSequences(src []byte, count int, literals []byte) error {
+	var b backwardReader
+	if err := b.init(src); err != nil {
+		return err
+	}
+	tables := &r.tables
+	var states [3]int
+	for field, table := range tables {
+		states[field] = int(b.readBits(table.log))
+	}
+
+	start := len(r.out)
+	for idx := range count {
+		llCode := tables[literalLengths].entries[states[literalLengths]].symbol
+		ofCode := tables[offsets].entries[states[offsets]].symbol
+		mlCode := tables[matchLengths].entries[states[matchLengths]].symbol
+		offsetValue := 1<<ofCode + int(b.readBits(uint(ofCode)))
+		matchLength := int(matchLengthBase[mlCode]) + int(b.readBits(uint(matchLengthExtra[mlCode])))
+		literalLength := int(literalLengthBase[llCode]) + int(b.readBits(uint(literalLengthExtra[llCode])))
+		if idx < count-1 {
+			states[literalLengths] = tables[literalLengths].next(states[literalLengths], &b)
+			states[matchLengths] = tables[matchLengths].next(states[matchLengths], &b)
+			states[offsets] = tables[offsets].next(states[offsets], &b)
+		}
+		if b.overflowed() {
+			return corrupt("sequences overflow their bitstream")
+		}
+
+		offset := r.resolveOffset(offsetValue, literalLength)
+		if literalLength > len(literals) {
+			return corrupt("sequence takes more literals than the block has")
+		}
+		if len(r.out)-start+literalLength+matchLength > maxBlockSize {
+			return corrupt("block too large")
+		}
+		r.out = append(r.out, literals[:literalLength]...)
+		literals = literals[literalLength:]
+		r.frameSize += int64(literalLength)
+		if offset == 0 || int64(offset) > r.frameSize || offset > r.window {
+			return corrupt("invalid offset")
+		}
+		r.copyMatch(offset, matchLength)
+	}
+	if b.pos != 0 {
+		return corrupt("sequence bitstream size mismatch")
+	}
+	if len(r.out)-start+len(literals) > maxBlockSize {
+		return corrupt("block too large")
+	}
+	r.out = append(r.out, literals...)
+	r.frameSize += int64(len(literals))
+	return nil
+}
+
+// resolveOffset turns an offset value into an offset, values up to 3 pick
+// a recent offset (RFC 8878 section 3.1.1.5).
+func (r *Reader) resolveOffset(value int, literalLength int) int {
+	reps := &r.reps
+	if value > 3 {
+		offset := value - 3
+		*reps = [3]int{offset, reps[0], reps[1]}
+		return offset
+	}
+	idx := value - 1
+	if literalLength == 0 {
+		idx++
+	}
+	switch idx {
+	case 0:
+		return reps[0]
+	case 1:
+		*reps = [3]int{reps[1], reps[0], reps[2]}
+	case 2:
+		*reps = [3]int{reps[2], reps[0], reps[1]}
+	default:
+		*reps = [3]int{reps[0] - 1, reps[0], reps[1]}
+	}
+	return reps[0]
+}
+
+// copyMatch appends length bytes from offset back, in steps when the copy
+// overlaps its source.
+func (r *Reader) copyMatch(offset int, length int) {
+	r.frameSize += int64(length)
+	for length > 0 {
+		n := min(length, offset)
+		start := len(r.out) - offset
+		r.out = append(r.out, r.out[start:start+n]...)
+		length -= n
+	}
+}
+
+// sequence is a literal length, match length and offset value to encode
+type sequence struct {
+	literalLength int
+	matchLength   int
+	offsetValue   int
+}
+
+// predefinedEncoders write the fields with the default distributions
+var predefinedEncoders = [3]*fseEncoder{
+	literalLengths: newFSEEncoder(literalLengthDefault, 6),
+	offsets:        newFSEEncoder(offsetDefault, 5),
+	matchLengths:   newFSEEncoder(matchLengthDefault, 6),
+}
+
+// lengthCode is the code of a length, the last one whose base it reaches.
+func lengthCode(bases []uint32, length int) uint8 {
+	code, _ := slices.BinarySearch(bases, uint32(length)+1)
+	return uint8(code - 1)
+}
+
+// tableCost estimates the bits the symbols of freqs take when coded with
+// the distribution counts. It reports false when a symbol has no state.
+func tableCost(freqs []int, counts []int16, log uint) (float64, bool) {
+	cost := 0.0
+	for symbol, freq := range freqs {
+		if freq == 0 {
+			continue
+		}
+		if symbol >= len(counts) || counts[symbol] == 0 {
+			return 0, false
+		}
+		cost += float64(freq) * (float64(log) - math.Log2(float64(max(counts[symbol], 1))))
+	}
+	return cost, true
+}
+
+// chooseTable picks the predefined table of a field or one fitted to its
+// symbols, whichever takes fewer bits with its description. It returns the
+// mode, the encoder and the description to write.
+func chooseTable(kind sequenceKind, field int, freqs []int, count int) (int, *fseEncoder, []byte) {
+	predefined, ok := tableCost(freqs, kind.defaults, kind.defaultLog)
+	if !ok {
+		predefined = math.Inf(1)
+	}
+
+	distinct := 0
+	for _, freq := range freqs {
+		if freq > 0 {
+			distinct++
+		}
+	}
+	log := min(max(uint(bits.Len(uint(count-1)))-2, 5), kind.maxLog)
+	for 1<<log < distinct && log < kind.maxLog {
+		log++
+	}
+	counts := normalize(freqs, count, log)
+	description := appendDistribution(nil, counts, log)
+	if custom, _ := tableCost(freqs, counts, log); custom+float64(8*len(description)) < predefined {
+		return modeCompressed, newFSEEncoder(counts, log), description
+	}
+	return modePredefined, predefinedEncoders[field], nil
+}
+
+// appendSequences writes the sequences section of a block (RFC 8878
+// section 3.1.1.3.2).
+func appendSequences(dst []byte, sequences []sequence) []byte {
+	count := len(sequences)
+	switch {
+	case count < 128:
+		dst = append(dst, byte(count))
+	case count < 0x7f00:
+		dst = append(dst, byte(count>>8+128), byte(count))
+	default:
+		dst = append(dst, 255, byte(count-0x7f00), byte((count-0x7f00)>>8))
+	}
+	if count == 0 {
+		return dst
+	}
+
+	codes := make([][3]uint8, count)
+	var freqs [3][]int
+	for field, kind := range sequenceKinds {
+		freqs[field] = make([]int, kind.maxSymbol+1)
+	}
+	for idx, seq := range sequences {
+		code := &codes[idx]
+		code[literalLengths] = lengthCode(literalLengthBase[:], seq.literalLength)
+		code[offsets] = uint8(bits.Len(uint(seq.offsetValue)) - 1)
+		code[matchLengths] = lengthCode(matchLengthBase[:], seq.matchLength)
+		for field := range code {
+			freqs[field][code[field]]++
+		}
+	}
+	var encoders [3]*fseEncoder
+	modes := len(dst)
+	dst = append(dst, 0)
+	for field, kind := range sequenceKinds {
+		var mode int
+		var description []byte
+		mode, encoders[field], description = chooseTable(kind, field, freqs[field], count)
+		dst[modes] |= byte(mode << (6 - 2*field))
+		dst = append(dst, description...)
+	}
+
+	// the decoder reads the bitstream backwards, from the first sequence
+	w := bitWriter{out: dst}
+	var states [3]uint32
+	ll, of, ml := encoders[literalLengths], encoders[offsets], encoders[matchLengths]
+	for idx := count - 1; idx >= 0; idx-- {
+		seq, code := sequences[idx], codes[idx]
+		if idx == count-1 {
+			states[matchLengths] = ml.begin(code[matchLengths])
+			states[offsets] = of.begin(code[offsets])
+			states[literalLengths] = ll.begin(code[literalLengths])
+		} else {
+			states[offsets] = of.encode(&w, states[offsets], code[offsets])
+			states[matchLengths] = ml.encode(&w, states[matchLengths], code[matchLengths])
+			states[literalLengths] = ll.encode(&w, states[literalLengths], code[literalLengths])
+		}
+		w.writeBits(uint64(seq.literalLength-int(literalLengthBase[code[literalLengths]])), uint(literalLengthExtra[code[literalLengths]]))
+		w.writeBits(uint64(seq.matchLength-int(matchLengthBase[code[matchLengths]])), uint(matchLengthExtra[code[matchLengths]]))
+		w.writeBits(uint64(seq.offsetValue-1<<code[offsets]), uint(code[offsets]))
+	}
+	ml.end(&w, states[matchLengths])
+	of.end(&w, states[offsets])
+	ll.end(&w, states[literalLengths])
+	return w.close()
+}
@@ -0,0 +1,247 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression/lz77"
+)
+
+const (
+	// writerWindowLog sets the window of the Writer, 256 KiB
+	writerWindowLog = 18
+	writerWindow    = 1 << writerWindowLog
+	maxMatchLength  = 1 << 16
+	// fewer literals than this are not worth a Huffman tree
+	minHuffmanLiterals = 64
+)
+
+// Writer compresses to a single zstd frame, checksummed and without a
+// content size, so it streams. Every block gets a Huffman tree for its
+// literals and the cheaper of the predefined and its own sequence tables.
+type Writer struct {
+	w       io.Writer
+	err     error
+	started bool
+	closed  bool
+
+	// history holds the window followed by the input not yet compressed,
+	// from pending
+	history   []byte
+	pending   int
+	matcher   lz77.Matcher
+	matches   []lz77.Match
+	sequences []sequence
+	literals  []byte
+	hash      xxh64
+	out       []byte
+}
+
+// NewWriter returns a Writer compressing into w. The frame is complete
+// once the Writer is closed.
+func NewWriter(w io.Writer) *Writer {
+	writer := &Writer{w: w}
+	writer.matcher.MaxDistance = writerWindow
+	writer.matcher.MaxLength = maxMatchLength
+	writer.hash.reset()
+	return writer
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errors.New("zstd: write after close")
+	}
+	w.hash.write(p)
+	written := 0
+	for written < len(p) {
+		room := maxBlockSize - (len(w.history) - w.pending)
+		n := min(room, len(p)-written)
+		w.history = append(w.history, p[written:written+n]...)
+		written += n
+		if len(w.history)-w.pending == maxBlockSize {
+			if w.err = w.writeBlock(false); w.err != nil {
+				return written, w.err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the input so far as a block, the decoder has all of it
+// once the block arrives.
+func (w *Writer) Flush() error {
+	if w.err != nil || w.closed {
+		return w.err
+	}
+	if len(w.history) > w.pending || !w.started {
+		w.err = w.writeBlock(false)
+	}
+	return w.err
+}
+
+// Close ends the frame with the last block and the checksum, it does not
+// close the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil || w.closed {
+		return w.err
+	}
+	w.closed = true
+	w.err = w.writeBlock(true)
+	return w.err
+}
+
+// writeBlock compresses the pending input into a block, or stores it
+// when that does not pay off.
+func (w *Writer) writeBlock(last bool) error {
+	if !w.started {
+		w.out = binary.LittleEndian.AppendUint32(w.out[:0], frameMagic)
+		// a checksum and the window, no content size
+		w.out = append(w.out, 0x04, (writerWindowLog-10)<<3)
+		w.started = true
+	}
+
+	block := w.history[w.pending:]
+	header := uint32(0)
+	if last {
+		header = 1
+	}
+	start := len(w.out)
+	w.out = append(w.out, 0, 0, 0)
+	if len(block) > 0 {
+		w.out = w.compressBlock(w.out)
+	}
+	if size := len(w.out) - start - 3; size > 0 && size < len(block) {
+		header |= blockCompressed<<1 | uint32(size)<<3
+	} else {
+		w.out = append(w.out[:start+3], block...)
+		header |= blockRaw<<1 | uint32(len(block))<<3
+	}
+	w.out[start], w.out[start+1], w.out[start+2] = byte(header), byte(header>>8), byte(header>>16)
+	if last {
+		w.out = binary.LittleEndian.AppendUint32(w.out, uint32(w.hash.sum()))
+	}
+
+	w.pending = len(w.history)
+	if drop := len(w.history) - writerWindow; drop >= maxBlockSize {
+		w.history = append(w.history[:0], w.history[drop:]...)
+		w.pending -= drop
+		w.matcher.Shift(drop)
+	}
+	_, err := w.w.Write(w.out)
+	w.out = w.out[:0]
+	return err
+}
+
+// compressBlock appends the literals and sequences of the pending input.
+func (w *Writer) compressBlock(dst []byte) []byte {
+	w.matches = w.matcher.Parse(w.matches[:0], w.history, w.pending)
+	w.sequences, w.literals = w.sequences[:0], w.literals[:0]
+	position := w.pending
+	for _, match := range w.matches {
+		w.literals = append(w.literals, w.history[position:position+match.Literals]...)
+		position += match.Literals + match.Length
+		if match.Length > 0 {
+			w.sequences = append(w.sequences, sequence{
+				literalLength: match.Literals,
+				matchLength:   match.Length,
+				// offset values up to 3 are repeat codes
+				offsetValue: match.Distance + 3,
+			})
+		}
+	}
+	dst = appendLiterals(dst, w.literals)
+	return appendSequences(dst, w.sequences)
+}
+
+// appendLiterals writes the literals section of a block (RFC 8878 section
+// 3.1.1.3.1), Huffman coded when that is smaller.
+func appendLiterals(dst []byte, literals []byte) []byte {
+	var freqs [256]uint32
+	distinct := 0
+	for _, c := range literals {
+		if freqs[c] == 0 {
+			distinct++
+		}
+		freqs[c]++
+	}
+	switch {
+	case distinct == 1 && len(literals) > 1:
+		return append(appendLiteralsHeader(dst, literalsRLE, len(literals)), literals[0])
+	case len(literals) < minHuffmanLiterals || distinct < 2:
+		return append(appendLiteralsHeader(dst, literalsRaw, len(literals)), literals...)
+	}
+
+	encoder := newHuffmanEncoder(freqs[:])
+	if encoder == nil {
+		return append(appendLiteralsHeader(dst, literalsRaw, len(literals)), literals...)
+	}
+	// room for the largest header, moved once its size is known
+	const headerRoom = 5
+	start := len(dst)
+	dst = append(dst, make([]byte, headerRoom)...)
+	dst = append(dst, encoder.description...)
+	single := len(literals) < 1024
+	if single {
+		dst = encoder.encode(&bitWriter{out: dst}, literals)
+	}
+	if compressed := len(dst) - start - headerRoom; !single || compressed >= 1024 {
+		dst = appendStreams(dst[:start+headerRoom+len(encoder.description)], encoder, literals)
+		single = false
+	}
+
+	compressed := len(dst) - start - headerRoom
+	format, sizeBits, headerSize := 0, uint(10), 3
+	switch largest := max(len(literals), compressed); {
+	case single:
+	case largest < 1024:
+		format = 1
+	case largest < 16384:
+		format, sizeBits, headerSize = 2, 14, 4
+	default:
+		format, sizeBits, headerSize = 3, 18, 5
+	}
+	if compressed+headerSize >= len(literals)+3 {
+		return append(appendLiteralsHeader(dst[:start], literalsRaw, len(literals)), literals...)
+	}
+	header := uint64(literalsCompressed) | uint64(format)<<2 | uint64(len(literals))<<4 | uint64(compressed)<<(4+sizeBits)
+	var field [8]byte
+	binary.LittleEndian.PutUint64(field[:], header)
+	copy(dst[start:], field[:headerSize])
+	copy(dst[start+headerSize:], dst[start+headerRoom:])
+	return dst[:len(dst)-(headerRoom-headerSize)]
+}
+
+// appendStreams writes literals as four Huffman streams behind their jump
+// table.
+func appendStreams(dst []byte, encoder *huffmanEncoder, literals []byte) []byte {
+	jumps := len(dst)
+	dst = append(dst, make([]byte, 6)...)
+	segment := (len(literals) + 3) / 4
+	for idx := range 4 {
+		part := literals[min(idx*segment, len(literals)):min((idx+1)*segment, len(literals))]
+		if idx == 3 {
+			part = literals[3*segment:]
+		}
+		start := len(dst)
+		dst = encoder.encode(&bitWriter{out: dst}, part)
+		if idx < 3 {
+			binary.LittleEndian.PutUint16(dst[jumps+2*idx:], uint16(len(dst)-start))
+		}
+	}
+	return dst
+}
+
+func appendLiteralsHeader(dst []byte, kind int, size int) []byte {
+	switch {
+	case size < 32:
+		return append(dst, byte(kind|size<<3))
+	case size < 4096:
+		return append(dst, byte(kind|1<<2|size<<4), byte(size>>4))
+	default:
+		return append(dst, byte(kind|3<<2|size<<4), byte(size>>4), byte(size>>12))
+	}
+}
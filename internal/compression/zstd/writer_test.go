@@ -0,0 +1,90 @@
+package zstd_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression/zstd"
+)
+
+func TestWriter(t *testing.T) {
+	text := readTestdata(t, "text.txt")
+	random := make([]byte, 300<<10)
+	rand.NewChaCha8([32]byte{}).Read(random)
+	long := bytes.Repeat(text, 60)
+
+	testCases := []struct {
+		Name  string
+		Input []byte
+		Chunk int
+		Flush bool
+	}{
+		{Name: "empty", Input: []byte{}},
+		{Name: "one byte", Input: []byte("x")},
+		{Name: "text", Input: text},
+		{Name: "text in small writes", Input: text, Chunk: 100},
+		{Name: "flushed writes", Input: text, Chunk: 1000, Flush: true},
+		{Name: "incompressible", Input: random},
+		{Name: "longer than the window", Input: long, Chunk: 50000},
+		{Name: "mixed", Input: append(append(text[:4000:4000], random[:5000]...), long[:200000]...)},
+	}
+
+	for _, tc := range testCases {
+		var compressed bytes.Buffer
+		writer := zstd.NewWriter(&compressed)
+		chunk := max(tc.Chunk, len(tc.Input), 1)
+		for start := 0; start < len(tc.Input); start += chunk {
+			if _, err := writer.Write(tc.Input[start:min(start+chunk, len(tc.Input))]); err != nil {
+				t.Fatalf("[ %s ]unexpected error %v", tc.Name, err)
+			}
+			if tc.Flush {
+				writer.Flush()
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("[ %s ]unexpected error %v", tc.Name, err)
+		}
+		if len(tc.Input) > 1000 && compressed.Len() > len(tc.Input)+len(tc.Input)/100 {
+			t.Errorf("[ %s ]%d bytes grew to %d", tc.Name, len(tc.Input), compressed.Len())
+		}
+
+		decoded, err := io.ReadAll(zstd.NewReader(&compressed))
+		if err != nil || !bytes.Equal(decoded, tc.Input) {
+			t.Errorf("[ %s ]expected %d bytes back, got %d %v", tc.Name, len(tc.Input), len(decoded), err)
+		}
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	parts := []string{"hello ", "hello world, ", "hello world"}
+	pipeReader, pipeWriter := io.Pipe()
+	writer := zstd.NewWriter(pipeWriter)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, part := range parts {
+			writer.Write([]byte(part))
+			writer.Flush()
+		}
+		writer.Close()
+		pipeWriter.Close()
+	}()
+
+	// everything written before a Flush decodes while the stream goes on
+	reader := zstd.NewReader(pipeReader)
+	for _, part := range parts {
+		got := make([]byte, len(part))
+		if _, err := io.ReadFull(reader, got); err != nil || string(got) != part {
+			t.Errorf("expected %q after Flush, got %q %v", part, got, err)
+		}
+	}
+	if rest, err := io.ReadAll(reader); err != nil || len(rest) > 0 {
+		t.Errorf("expected the end of the stream, got %q %v", rest, err)
+	}
+	<-done
+	if _, err := writer.Write([]byte("late")); err == nil {
+		t.Errorf("expected an error writing after Close")
+	}
+}
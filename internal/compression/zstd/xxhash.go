@@ -0,0 +1,94 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// the content checksum of a frame is the low half of XXH64 with seed 0
+const (
+	prime64_1 = 11400714785074694791
+	prime64_2 = 14029467366897019727
+	prime64_3 = 1609587929392839161
+	prime64_4 = 9650029242287828579
+	prime64_5 = 2870177450012600261
+)
+
+// xxh64 hashes a stream in stripes of 32 bytes.
+type xxh64 struct {
+	lanes [4]uint64
+	total uint64
+	buf   [32]byte
+	n     int
+}
+
+func (h *xxh64) reset() {
+	p1, p2 := uint64(prime64_1), uint64(prime64_2)
+	*h = xxh64{lanes: [4]uint64{p1 + p2, p2, 0, -p1}}
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	acc += input * prime64_2
+	return bits.RotateLeft64(acc, 31) * prime64_1
+}
+
+func (h *xxh64) stripe(p []byte) {
+	for idx := range h.lanes {
+		h.lanes[idx] = xxhRound(h.lanes[idx], binary.LittleEndian.Uint64(p[idx*8:]))
+	}
+}
+
+func (h *xxh64) write(p []byte) {
+	h.total += uint64(len(p))
+	if h.n > 0 {
+		n := copy(h.buf[h.n:], p)
+		h.n += n
+		p = p[n:]
+		if h.n < len(h.buf) {
+			return
+		}
+		h.stripe(h.buf[:])
+		h.n = 0
+	}
+	for ; len(p) >= len(h.buf); p = p[len(h.buf):] {
+		h.stripe(p)
+	}
+	h.n = copy(h.buf[:], p)
+}
+
+func (h *xxh64) sum() uint64 {
+	var sum uint64
+	if h.total >= 32 {
+		sum = bits.RotateLeft64(h.lanes[0], 1) + bits.RotateLeft64(h.lanes[1], 7) +
+			bits.RotateLeft64(h.lanes[2], 12) + bits.RotateLeft64(h.lanes[3], 18)
+		for _, lane := range h.lanes {
+			sum ^= xxhRound(0, lane)
+			sum = sum*prime64_1 + prime64_4
+		}
+	} else {
+		sum = prime64_5
+	}
+	sum += h.total
+
+	p := h.buf[:h.n]
+	for ; len(p) >= 8; p = p[8:] {
+		sum ^= xxhRound(0, binary.LittleEndian.Uint64(p))
+		sum = bits.RotateLeft64(sum, 27)*prime64_1 + prime64_4
+	}
+	if len(p) >= 4 {
+		sum ^= uint64(binary.LittleEndian.Uint32(p)) * prime64_1
+		sum = bits.RotateLeft64(sum, 23)*prime64_2 + prime64_3
+		p = p[4:]
+	}
+	for _, c := range p {
+		sum ^= uint64(c) * prime64_5
+		sum = bits.RotateLeft64(sum, 11) * prime64_1
+	}
+
+	sum ^= sum >> 33
+	sum *= prime64_2
+	sum ^= sum >> 29
+	sum *= prime64_3
+	sum ^= sum >> 32
+	return sum
+}
@@ -0,0 +1,206 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/common"
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression"
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+)
+
+// Options tunes how a FileServer answers.
+type Options struct {
+	// Index is the file served for a directory, "index.html" when empty.
+	Index string
+	// Listing renders the entries of a directory without an index file, as
+	// HTML or as JSON when the client accepts it. Such directories are not
+	// found otherwise.
+	Listing bool
+}
+
+// FileServer serves the files of a directory tree.
+type FileServer struct {
+	fsys    fs.FS
+	options Options
+}
+
+func NewFileServer(fsys fs.FS, options Options) *FileServer {
+	if options.Index == "" {
+		options.Index = "index.html"
+	}
+	return &FileServer{fsys: fsys, options: options}
+}
+
+// Handler returns a handler serving the file named by the path param,
+// usually a catch-all such as "*filepath". A route without the param
+// serves the root directory.
+func (s *FileServer) Handler(param string) httpcore.HandlerFunc {
+	return func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		name := r.PathParams[param]
+		if name == "" {
+			name = "."
+		}
+		s.serve(name, r, w)
+	}
+}
+
+func (s *FileServer) serve(name string, r httpcore.Request, w *httpcore.HttpResponseWriter) {
+	if !fs.ValidPath(name) {
+		w.SetStatus(httpcore.StatusNotFound)
+		return
+	}
+
+	// a name the file system refuses to resolve, such as a symlink out of
+	// an os.Root, is not found either
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		w.SetStatus(httpcore.StatusNotFound)
+		if errors.Is(err, fs.ErrPermission) {
+			w.SetStatus(httpcore.StatusForbidden)
+		}
+		return
+	}
+
+	// relative links resolve against a directory only with the trailing
+	// slash, a file never has one
+	trailingSlash := strings.HasSuffix(r.RawPath, "/")
+	if info.IsDir() != trailingSlash {
+		location := r.RawPath + "/"
+		if trailingSlash {
+			location = strings.TrimRight(r.RawPath, "/")
+		}
+		if r.RawQuery != "" {
+			location += "?" + r.RawQuery
+		}
+		w.SetHeader("Location", location)
+		w.SetStatus(httpcore.StatusMovedPermanently)
+		return
+	}
+
+	if !info.IsDir() {
+		s.serveFile(name, info, r, w)
+		return
+	}
+
+	index := path.Join(name, s.options.Index)
+	if indexInfo, err := fs.Stat(s.fsys, index); err == nil && indexInfo.Mode().IsRegular() {
+		s.serveFile(index, indexInfo, r, w)
+		return
+	}
+	if !s.options.Listing {
+		w.SetStatus(httpcore.StatusNotFound)
+		return
+	}
+	s.serveListing(name, r, w)
+}
+
+func (s *FileServer) serveFile(name string, info fs.FileInfo, r httpcore.Request, w *httpcore.HttpResponseWriter) {
+	if !info.Mode().IsRegular() {
+		w.SetStatus(httpcore.StatusNotFound)
+		return
+	}
+
+	modTime := info.ModTime()
+	if notModified(r, modTime) {
+		w.SetStatus(httpcore.StatusNotModified)
+		return
+	}
+
+	contentType, err := s.contentType(name)
+	if err != nil {
+		w.SetStatus(errorStatus(err))
+		return
+	}
+
+	// a .br or .gz sibling saves compressing the file on every request
+	accept, present := r.Headers.Lookup("accept-encoding")
+	file, coding, varies := compression.OpenPrecompressed(s.fsys, name, accept, present)
+	if varies {
+		w.SetHeader("Vary", "Accept-Encoding")
+	}
+	if file != nil {
+		w.SetHeader("Content-Encoding", coding)
+	} else if file, err = s.fsys.Open(name); err != nil {
+		w.SetStatus(errorStatus(err))
+		return
+	}
+	defer file.Close()
+
+	// the sibling has a size of its own
+	if info, err = file.Stat(); err != nil {
+		w.SetStatus(errorStatus(err))
+		return
+	}
+
+	w.SetHeader("Content-Type", contentType)
+	w.SetHeader("Content-Length", fmt.Sprintf("%d", info.Size()))
+	if !isZeroTime(modTime) {
+		w.SetHeader("Last-Modified", modTime.UTC().Format(httpcore.TimeFormat))
+	}
+	w.SetStatus(httpcore.StatusOK)
+	if r.Method == common.HEAD {
+		return
+	}
+	if _, err := io.Copy(w.BodyWriter(), file); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// contentType names the media type of a file by its extension, or by
+// sniffing its first bytes when the extension is unknown.
+func (s *FileServer) contentType(name string) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType, nil
+	}
+
+	file, err := s.fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	return DetectContentType(head[:n]), nil
+}
+
+// notModified reports whether the client's copy, dated by
+// If-Modified-Since, is still current. The header has a one second
+// resolution.
+func notModified(r httpcore.Request, modTime time.Time) bool {
+	since, ok := r.Headers.Lookup("if-modified-since")
+	if !ok || isZeroTime(modTime) {
+		return false
+	}
+	date, err := time.Parse(httpcore.TimeFormat, since)
+	if err != nil {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(date)
+}
+
+// isZeroTime tells a file system without modification times apart
+func isZeroTime(t time.Time) bool {
+	return t.IsZero() || t.Equal(time.Unix(0, 0))
+}
+
+func errorStatus(err error) httpcore.HttpStatus {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return httpcore.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return httpcore.StatusForbidden
+	}
+	fmt.Println(err)
+	return httpcore.StatusInternalServerError
+}
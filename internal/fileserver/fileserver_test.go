@@ -0,0 +1,307 @@
+package fileserver_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/common"
+	"github.com/codecrafters-io/http-server-starter-go/internal/fileserver"
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+)
+
+var modTime = time.Date(2024, time.March, 9, 16, 30, 15, 0, time.UTC)
+
+var fsys = fstest.MapFS{
+	"style.css":             {Data: []byte("body { color: red; }"), ModTime: modTime},
+	"page":                  {Data: []byte("  <!DOCTYPE html><p>hello</p>"), ModTime: modTime},
+	"image":                 {Data: []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR"), ModTime: modTime},
+	"notes":                 {Data: []byte("just some notes"), ModTime: modTime},
+	"blob":                  {Data: []byte("\x00\x01\x02\x03"), ModTime: modTime},
+	"app.js":                {Data: []byte("console.log(1)"), ModTime: modTime},
+	"app.js.gz":             {Data: []byte("gzipped"), ModTime: modTime},
+	"docs/api/spec.json":    {Data: []byte(`{"openapi":"3.1.0"}`), ModTime: modTime},
+	"docs/<b>&a:b.txt":      {Data: []byte("odd name"), ModTime: modTime},
+	"site/index.html":       {Data: []byte("<html>home</html>"), ModTime: modTime},
+	"site/about/index.html": {Data: []byte("<html>about</html>"), ModTime: modTime},
+	"undated":               {Data: []byte("no time")},
+}
+
+// serve runs handler as if mounted on "/files/*filename"
+func serve(t *testing.T, handler httpcore.HandlerFunc, method common.Method, target string, headers httpcore.HeaderMap) *http.Response {
+	t.Helper()
+	if headers == nil {
+		headers = make(httpcore.HeaderMap)
+	}
+	request, err := httpcore.NewRequest(method, target, headers, nil, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	request.PathParams = map[string]string{}
+	if name := strings.Trim(strings.TrimPrefix(request.Path, "/files"), "/"); name != "" {
+		request.PathParams["filename"] = name
+	}
+
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	stream := httpcore.NewHttp1StreamWriter(writer)
+	if method == common.HEAD {
+		stream = stream.WithoutBody()
+	}
+	response := httpcore.NewStreamingResponseWriter(stream)
+	handler(*request, &response)
+	if err := response.Finish(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	writer.Flush()
+
+	parsed, err := http.ReadResponse(bufio.NewReader(&buf), &http.Request{Method: string(method)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { parsed.Body.Close() })
+	return parsed
+}
+
+func TestFileServer(t *testing.T) {
+	handler := fileserver.NewFileServer(fsys, fileserver.Options{}).Handler("filename")
+	lastModified := modTime.Format(httpcore.TimeFormat)
+
+	testCases := []struct {
+		Name     string
+		Method   common.Method
+		Target   string
+		Headers  httpcore.HeaderMap
+		Status   int
+		Expected map[string]string
+		Body     string
+	}{
+		{
+			Name: "Type by extension", Target: "/files/style.css", Status: 200, Body: "body { color: red; }",
+			Expected: map[string]string{"Content-Type": mime.TypeByExtension(".css"), "Content-Length": "20", "Last-Modified": lastModified},
+		},
+		{Name: "Sniffed html", Target: "/files/page", Status: 200, Body: "  <!DOCTYPE html><p>hello</p>", Expected: map[string]string{"Content-Type": "text/html; charset=utf-8"}},
+		{Name: "Sniffed png", Target: "/files/image", Status: 200, Expected: map[string]string{"Content-Type": "image/png"}},
+		{Name: "Sniffed text", Target: "/files/notes", Status: 200, Body: "just some notes", Expected: map[string]string{"Content-Type": "text/plain; charset=utf-8"}},
+		{Name: "Sniffed binary", Target: "/files/blob", Status: 200, Expected: map[string]string{"Content-Type": "application/octet-stream"}},
+		{Name: "Nested path", Target: "/files/docs/api/spec.json", Status: 200, Body: `{"openapi":"3.1.0"}`, Expected: map[string]string{"Content-Type": "application/json"}},
+		{Name: "Escaped name", Target: "/files/docs/%3Cb%3E&a:b.txt", Status: 200, Body: "odd name"},
+		{Name: "Index of the root", Target: "/files/site/", Status: 200, Body: "<html>home</html>", Expected: map[string]string{"Content-Type": mime.TypeByExtension(".html")}},
+		{Name: "Nested index", Target: "/files/site/about/", Status: 200, Body: "<html>about</html>"},
+		{Name: "Directory without slash", Target: "/files/site/about?x=1", Status: 301, Expected: map[string]string{"Location": "/files/site/about/?x=1"}},
+		{Name: "File with slash", Target: "/files/notes/", Status: 301, Expected: map[string]string{"Location": "/files/notes"}},
+		{Name: "Listing off", Target: "/files/docs/", Status: 404},
+		{Name: "Missing file", Target: "/files/missing.txt", Status: 404},
+		{Name: "Missing in nested directory", Target: "/files/docs/none/x", Status: 404},
+		{
+			Name: "Precompressed sibling", Target: "/files/app.js", Headers: httpcore.HeaderMap{"accept-encoding": {"gzip"}}, Status: 200, Body: "gzipped",
+			Expected: map[string]string{"Content-Type": mime.TypeByExtension(".js"), "Content-Encoding": "gzip", "Vary": "Accept-Encoding", "Content-Length": "7"},
+		},
+		{Name: "Sibling not accepted", Target: "/files/app.js", Status: 200, Body: "console.log(1)", Expected: map[string]string{"Vary": "Accept-Encoding"}},
+		{Name: "Not modified", Target: "/files/notes", Headers: httpcore.HeaderMap{"if-modified-since": {lastModified}}, Status: 304},
+		{
+			Name: "Modified since", Target: "/files/notes", Headers: httpcore.HeaderMap{"if-modified-since": {modTime.Add(-time.Second).Format(httpcore.TimeFormat)}},
+			Status: 200, Body: "just some notes",
+		},
+		{Name: "Without modification time", Target: "/files/undated", Status: 200, Body: "no time", Expected: map[string]string{"Last-Modified": ""}},
+		{
+			Name: "Head", Method: common.HEAD, Target: "/files/notes", Status: 200,
+			Expected: map[string]string{"Content-Length": "15", "Last-Modified": lastModified},
+		},
+	}
+
+	for _, tc := range testCases {
+		method := tc.Method
+		if method == "" {
+			method = common.GET
+		}
+		response := serve(t, handler, method, tc.Target, tc.Headers)
+		if response.StatusCode != tc.Status {
+			t.Errorf("[ %s ]expected status %d, got %d", tc.Name, tc.Status, response.StatusCode)
+			continue
+		}
+		for key, value := range tc.Expected {
+			if got := response.Header.Get(key); got != value {
+				t.Errorf("[ %s ]expected %s %q, got %q", tc.Name, key, value, got)
+			}
+		}
+		body, _ := io.ReadAll(response.Body)
+		if tc.Body != "" && string(body) != tc.Body {
+			t.Errorf("[ %s ]expected body %q, got %q", tc.Name, tc.Body, body)
+		}
+		if method == common.HEAD && len(body) != 0 {
+			t.Errorf("[ %s ]expected no body, got %q", tc.Name, body)
+		}
+	}
+}
+
+func TestInvalidPaths(t *testing.T) {
+	handler := fileserver.NewFileServer(fsys, fileserver.Options{Listing: true}).Handler("filename")
+
+	for _, name := range []string{"../style.css", "docs/../style.css", "/style.css", "docs//api"} {
+		request := httpcore.Request{Method: common.GET, Headers: make(httpcore.HeaderMap), PathParams: map[string]string{"filename": name}}
+		response := httpcore.NewHttpResponseWriter()
+		handler(request, &response)
+		if response.Status() != httpcore.StatusNotFound {
+			t.Errorf("[ %s ]expected status 404, got %d", name, response.Status())
+		}
+	}
+}
+
+func TestSymlinkOutOfRoot(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	directory := t.TempDir()
+	if err := os.WriteFile(filepath.Join(directory, "notes"), []byte("notes"), 0o644); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for name, target := range map[string]string{"up": outside, "link": filepath.Join(outside, "secret")} {
+		if err := os.Symlink(target, filepath.Join(directory, name)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	root, err := os.OpenRoot(directory)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer root.Close()
+	handler := fileserver.NewFileServer(root.FS(), fileserver.Options{Listing: true}).Handler("filename")
+
+	testCases := []struct {
+		Name   string
+		Target string
+		Status int
+	}{
+		{Name: "File in the root", Target: "/files/notes", Status: 200},
+		{Name: "Through a directory symlink", Target: "/files/up/secret", Status: 404},
+		{Name: "Listing through a directory symlink", Target: "/files/up/", Status: 404},
+		{Name: "File symlink", Target: "/files/link", Status: 404},
+	}
+
+	for _, tc := range testCases {
+		response := serve(t, handler, common.GET, tc.Target, nil)
+		if response.StatusCode != tc.Status {
+			t.Errorf("[ %s ]expected status %d, got %d", tc.Name, tc.Status, response.StatusCode)
+		}
+		if body, _ := io.ReadAll(response.Body); strings.Contains(string(body), "secret") {
+			t.Errorf("[ %s ]served from outside the root: %q", tc.Name, body)
+		}
+	}
+}
+
+func TestListing(t *testing.T) {
+	handler := fileserver.NewFileServer(fsys, fileserver.Options{Listing: true}).Handler("filename")
+
+	response := serve(t, handler, common.GET, "/files/docs/", nil)
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != 200 || response.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("unexpected response %d %q", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	for _, expected := range []string{
+		"<title>Index of /files/docs/</title>",
+		`<a href="./%3Cb%3E&amp;a:b.txt">&lt;b&gt;&amp;a:b.txt</a>`,
+		`<a href="./api/">api/</a>`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected %q in listing\n%s", expected, body)
+		}
+	}
+	if response.Header.Get("Vary") != "Accept" {
+		t.Errorf("expected Vary Accept, got %q", response.Header.Get("Vary"))
+	}
+
+	// an index file takes precedence
+	response = serve(t, handler, common.GET, "/files/site/", nil)
+	if body, _ := io.ReadAll(response.Body); string(body) != "<html>home</html>" {
+		t.Errorf("expected the index file, got %q", body)
+	}
+
+	testCases := []struct {
+		Name   string
+		Accept string
+		JSON   bool
+	}{
+		{Name: "No Accept"},
+		{Name: "Json", Accept: "application/json", JSON: true},
+		{Name: "Browser", Accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+		{Name: "Json by q-value", Accept: "text/html;q=0.5, application/json", JSON: true},
+		{Name: "Html by q-value", Accept: "text/html, application/json;q=0.9"},
+		{Name: "Json over wildcard", Accept: "application/json, */*;q=0.1", JSON: true},
+		{Name: "Any", Accept: "*/*"},
+	}
+
+	for _, tc := range testCases {
+		headers := make(httpcore.HeaderMap)
+		if tc.Accept != "" {
+			headers.Set("Accept", tc.Accept)
+		}
+		response := serve(t, handler, common.GET, "/files/docs/", headers)
+		isJSON := response.Header.Get("Content-Type") == "application/json"
+		if isJSON != tc.JSON {
+			t.Errorf("[ %s ]expected json %v, got Content-Type %q", tc.Name, tc.JSON, response.Header.Get("Content-Type"))
+			continue
+		}
+		if !isJSON {
+			continue
+		}
+
+		var entries []struct {
+			Name     string    `json:"name"`
+			Dir      bool      `json:"dir"`
+			Size     int64     `json:"size"`
+			Modified time.Time `json:"modified"`
+		}
+		if err := json.NewDecoder(response.Body).Decode(&entries); err != nil {
+			t.Errorf("[ %s ]unexpected error %v", tc.Name, err)
+			continue
+		}
+		if len(entries) != 2 || entries[0].Name != "<b>&a:b.txt" || entries[0].Size != 8 || !entries[0].Modified.Equal(modTime) ||
+			entries[1].Name != "api" || !entries[1].Dir {
+			t.Errorf("[ %s ]unexpected entries %+v", tc.Name, entries)
+		}
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Data     string
+		Expected string
+	}{
+		{Name: "Empty", Data: "", Expected: "text/plain; charset=utf-8"},
+		{Name: "Html", Data: "\n<HTML><body>", Expected: "text/html; charset=utf-8"},
+		{Name: "Html comment", Data: "<!-- x -->", Expected: "text/html; charset=utf-8"},
+		{Name: "Tag prefix", Data: "<abbr>", Expected: "text/plain; charset=utf-8"},
+		{Name: "Xml", Data: "<?xml version=\"1.0\"?>", Expected: "text/xml; charset=utf-8"},
+		{Name: "Pdf", Data: "%PDF-1.7", Expected: "application/pdf"},
+		{Name: "Jpeg", Data: "\xFF\xD8\xFF\xE0", Expected: "image/jpeg"},
+		{Name: "Gif", Data: "GIF89a....", Expected: "image/gif"},
+		{Name: "Webp", Data: "RIFF\x10\x00\x00\x00WEBPVP8", Expected: "image/webp"},
+		{Name: "Mp4", Data: "\x00\x00\x00\x18ftypmp42", Expected: "video/mp4"},
+		{Name: "Gzip", Data: "\x1F\x8B\x08\x00", Expected: "application/x-gzip"},
+		{Name: "Zip", Data: "PK\x03\x04", Expected: "application/zip"},
+		{Name: "Wasm", Data: "\x00asm\x01\x00\x00\x00", Expected: "application/wasm"},
+		{Name: "Utf-8 text", Data: "grüße\tüber\r\n", Expected: "text/plain; charset=utf-8"},
+		{Name: "Utf-16 bom", Data: "\xFF\xFEh\x00", Expected: "text/plain; charset=utf-16le"},
+		{Name: "Control bytes", Data: "text\x00more", Expected: "application/octet-stream"},
+		{Name: "Invalid utf-8", Data: "caf\xE9 au lait", Expected: "application/octet-stream"},
+		{Name: "Rune cut off by the sample", Data: strings.Repeat("a", 511) + "\xC3\xBC", Expected: "text/plain; charset=utf-8"},
+	}
+
+	for _, tc := range testCases {
+		if got := fileserver.DetectContentType([]byte(tc.Data)); got != tc.Expected {
+			t.Errorf("[ %s ]expected %q, got %q", tc.Name, tc.Expected, got)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package fileserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+)
+
+// entry is a directory entry as listed to the client
+type entry struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modified"`
+}
+
+// Href links the entry relative to its directory, the "./" keeps a name
+// with a colon from reading as a scheme.
+func (e entry) Href() string {
+	href := "./" + url.PathEscape(e.Name)
+	if e.Dir {
+		href += "/"
+	}
+	return href
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of {{.Path}}</title>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<ul>
+{{- range .Entries}}
+<li><a href="{{.Href}}">{{.Name}}{{if .Dir}}/{{end}}</a></li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+func (s *FileServer) serveListing(name string, r httpcore.Request, w *httpcore.HttpResponseWriter) {
+	dirEntries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		w.SetStatus(errorStatus(err))
+		return
+	}
+
+	entries := make([]entry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if err != nil {
+			// removed since it was read
+			continue
+		}
+		listed := entry{Name: dirEntry.Name(), Dir: dirEntry.IsDir(), ModTime: info.ModTime().UTC()}
+		if !listed.Dir {
+			listed.Size = info.Size()
+		}
+		entries = append(entries, listed)
+	}
+
+	// the representation depends on Accept
+	w.SetHeader("Vary", "Accept")
+	var body bytes.Buffer
+	if prefersJSON(r.Headers.Get("accept")) {
+		if err := json.NewEncoder(&body).Encode(entries); err != nil {
+			w.SetStatus(errorStatus(err))
+			return
+		}
+		w.SetHeader("Content-Type", "application/json")
+	} else {
+		data := struct {
+			Path    string
+			Entries []entry
+		}{Path: r.Path, Entries: entries}
+		if err := listingTemplate.Execute(&body, data); err != nil {
+			w.SetStatus(errorStatus(err))
+			return
+		}
+		w.SetHeader("Content-Type", "text/html; charset=utf-8")
+	}
+	w.SetStatus(httpcore.StatusOK)
+	w.Write(body.Bytes())
+}
+
+// prefersJSON reports whether the Accept value accept ranks JSON above
+// HTML. The most specific media range matching a type gives its q-value,
+// a missing header leaves HTML.
+func prefersJSON(accept string) bool {
+	return acceptQuality(accept, "application/json") > acceptQuality(accept, "text/html")
+}
+
+func acceptQuality(accept string, mediaType string) float64 {
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+	for _, item := range strings.Split(accept, ",") {
+		params := strings.Split(item, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+
+		rangeSpecificity := -1
+		switch mediaRange {
+		case mediaType:
+			rangeSpecificity = 2
+		case mainType + "/*":
+			rangeSpecificity = 1
+		case "*/*":
+			rangeSpecificity = 0
+		}
+		if rangeSpecificity <= specificity {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0
+			}
+			q = parsed
+		}
+		quality, specificity = q, rangeSpecificity
+	}
+	return quality
+}
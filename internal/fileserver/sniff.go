@@ -0,0 +1,138 @@
+package fileserver
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// sniffLen is the most DetectContentType looks at
+const sniffLen = 512
+
+// signature matches the first bytes of a format. Bytes set in mask are
+// compared, a nil mask compares all of them.
+type signature struct {
+	mask        []byte
+	pattern     []byte
+	contentType string
+}
+
+func (s signature) match(data []byte) bool {
+	if len(data) < len(s.pattern) {
+		return false
+	}
+	for idx, b := range s.pattern {
+		mask := byte(0xFF)
+		if s.mask != nil {
+			mask = s.mask[idx]
+		}
+		if data[idx]&mask != b {
+			return false
+		}
+	}
+	return true
+}
+
+var signatures = []signature{
+	{pattern: []byte("%PDF-"), contentType: "application/pdf"},
+	{pattern: []byte("%!PS-Adobe-"), contentType: "application/postscript"},
+	{pattern: []byte("\x89PNG\x0D\x0A\x1A\x0A"), contentType: "image/png"},
+	{pattern: []byte("\xFF\xD8\xFF"), contentType: "image/jpeg"},
+	{pattern: []byte("GIF87a"), contentType: "image/gif"},
+	{pattern: []byte("GIF89a"), contentType: "image/gif"},
+	{pattern: []byte("BM"), contentType: "image/bmp"},
+	{pattern: []byte("\x00\x00\x01\x00"), contentType: "image/x-icon"},
+	{
+		mask:        []byte("\xFF\xFF\xFF\xFF\x00\x00\x00\x00\xFF\xFF\xFF\xFF"),
+		pattern:     []byte("RIFF\x00\x00\x00\x00WEBP"),
+		contentType: "image/webp",
+	},
+	{
+		mask:        []byte("\xFF\xFF\xFF\xFF\x00\x00\x00\x00\xFF\xFF\xFF\xFF"),
+		pattern:     []byte("RIFF\x00\x00\x00\x00WAVE"),
+		contentType: "audio/wave",
+	},
+	{pattern: []byte("ID3"), contentType: "audio/mpeg"},
+	{pattern: []byte("OggS\x00"), contentType: "application/ogg"},
+	{pattern: []byte("fLaC"), contentType: "audio/flac"},
+	{pattern: []byte("\x1A\x45\xDF\xA3"), contentType: "video/webm"},
+	{
+		mask:        []byte("\x00\x00\x00\x00\xFF\xFF\xFF\xFF"),
+		pattern:     []byte("\x00\x00\x00\x00ftyp"),
+		contentType: "video/mp4",
+	},
+	{pattern: []byte("wOFF"), contentType: "font/woff"},
+	{pattern: []byte("wOF2"), contentType: "font/woff2"},
+	{pattern: []byte("\x00\x01\x00\x00"), contentType: "font/ttf"},
+	{pattern: []byte("OTTO"), contentType: "font/otf"},
+	{pattern: []byte("\x1F\x8B\x08"), contentType: "application/x-gzip"},
+	{pattern: []byte("PK\x03\x04"), contentType: "application/zip"},
+	{pattern: []byte("Rar!\x1A\x07"), contentType: "application/x-rar-compressed"},
+	{pattern: []byte("\x00asm"), contentType: "application/wasm"},
+}
+
+// markup starts, compared case insensitively after leading white space and
+// followed by a space or ">"
+var htmlTags = []string{
+	"<!DOCTYPE HTML", "<HTML", "<HEAD", "<SCRIPT", "<IFRAME", "<H1", "<DIV",
+	"<FONT", "<TABLE", "<A", "<STYLE", "<TITLE", "<B", "<BODY", "<BR", "<P", "<!--",
+}
+
+// DetectContentType guesses the media type of data from at most its first
+// 512 bytes, following the outline of the WHATWG MIME sniffing standard.
+// Unknown binary data is application/octet-stream.
+func DetectContentType(data []byte) string {
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+
+	// a byte order mark settles text
+	switch {
+	case bytes.HasPrefix(data, []byte("\xFE\xFF")):
+		return "text/plain; charset=utf-16be"
+	case bytes.HasPrefix(data, []byte("\xFF\xFE")):
+		return "text/plain; charset=utf-16le"
+	case bytes.HasPrefix(data, []byte("\xEF\xBB\xBF")):
+		return "text/plain; charset=utf-8"
+	}
+
+	text := bytes.TrimLeft(data, "\t\n\x0C\r ")
+	for _, tag := range htmlTags {
+		if len(text) <= len(tag) || !bytes.EqualFold(text[:len(tag)], []byte(tag)) {
+			continue
+		}
+		if next := text[len(tag)]; next == ' ' || next == '>' {
+			return "text/html; charset=utf-8"
+		}
+	}
+	if bytes.HasPrefix(text, []byte("<?xml")) {
+		return "text/xml; charset=utf-8"
+	}
+
+	for _, sig := range signatures {
+		if sig.match(data) {
+			return sig.contentType
+		}
+	}
+
+	if isText(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// isText reports whether data holds no binary data bytes, only a rune cut
+// off at the end of the sample may be incomplete.
+func isText(data []byte) bool {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			return len(data) < utf8.UTFMax && !utf8.FullRune(data)
+		}
+		switch {
+		case r <= 0x08, r == 0x0B, 0x0E <= r && r <= 0x1A, 0x1C <= r && r <= 0x1F:
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package httpcore
+
+import (
+	"errors"
+	"io"
+)
+
+// maxDrainBytes is how much of an unread body is discarded so the
+// connection can be reused, larger leftovers close the connection instead.
+const maxDrainBytes = 256 << 10
+
+var (
+	ErrBodyClosed     = errors.New("read on closed request body")
+	ErrBodyNotDrained = errors.New("request body was not fully read")
+)
+
+// NoBody is the body of requests without content.
+var NoBody = noBody{}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+// body reads a request body lazily from the connection. It never hands out
+// more than the framing allows, so the next request on the connection stays
+// intact as long as the body is read to the end or drained by Close.
+type body struct {
+	src    io.Reader
+	closed bool
+	eof    bool
+}
+
+func newBody(src io.Reader) *body {
+	return &body{src: src}
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+	if b.eof {
+		return 0, io.EOF
+	}
+
+	n, err := b.src.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// Close discards what is left of the body. ErrBodyNotDrained is returned
+// when the remainder is too large or broken, the connection must not be
+// reused in that case.
+func (b *body) Close() error {
+	if b.closed {
+		return nil
+	}
+	defer func() { b.closed = true }()
+
+	if b.eof {
+		return nil
+	}
+
+	n, err := io.Copy(io.Discard, io.LimitReader(b, maxDrainBytes+1))
+	if err != nil || n > maxDrainBytes || !b.eof {
+		return ErrBodyNotDrained
+	}
+	return nil
+}
+
+// lengthReader reads exactly remaining bytes, reporting a short body as
+// io.ErrUnexpectedEOF rather than a clean end.
+type lengthReader struct {
+	src       io.Reader
+	remaining int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+
+	n, err := l.src.Read(p)
+	l.remaining -= int64(n)
+	if err == io.EOF {
+		if l.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	if err == nil && l.remaining == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,130 @@
+package httpcore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const maxChunkLineLength = 4096
+
+var ErrMalformedChunk = errors.New("malformed chunked encoding")
+
+// chunkedReader decodes a body sent with "Transfer-Encoding: chunked" as
+// described in RFC 9112 section 7.1. Chunk extensions are read and dropped,
+// and trailer fields are stored in trailers once the last chunk is reached.
+type chunkedReader struct {
+	reader    *bufio.Reader
+	trailers  HeaderMap
+	remaining uint64
+	done      bool
+	err       error
+}
+
+func newChunkedReader(reader *bufio.Reader, trailers HeaderMap) *chunkedReader {
+	return &chunkedReader{reader: reader, trailers: trailers}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.done {
+		return 0, io.EOF
+	}
+
+	if c.remaining == 0 {
+		size, err := c.readChunkSize()
+		if err != nil {
+			c.err = err
+			return 0, err
+		}
+		if size == 0 {
+			if err := readHeaderLines(c.reader, c.trailers); err != nil {
+				c.err = fmt.Errorf("failed to read trailers: %w", err)
+				return 0, c.err
+			}
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = size
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	c.remaining -= uint64(n)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		c.err = err
+		return n, err
+	}
+
+	if c.remaining == 0 {
+		if err := c.readChunkTerminator(); err != nil {
+			c.err = err
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (c *chunkedReader) readChunkSize() (uint64, error) {
+	line, err := readLimitedLine(c.reader, maxChunkLineLength)
+	if err != nil {
+		return 0, err
+	}
+
+	// chunk extensions (";name=value") carry no meaning for us
+	sizeField, _, _ := bytes.Cut(line, []byte(";"))
+	sizeField = bytes.TrimRight(sizeField, " \t")
+	if len(sizeField) == 0 || len(sizeField) > 16 {
+		return 0, ErrMalformedChunk
+	}
+
+	size, err := strconv.ParseUint(string(sizeField), 16, 64)
+	if err != nil {
+		return 0, ErrMalformedChunk
+	}
+	return size, nil
+}
+
+func (c *chunkedReader) readChunkTerminator() error {
+	line, err := readLimitedLine(c.reader, maxChunkLineLength)
+	if err != nil {
+		return err
+	}
+	if len(line) != 0 {
+		return ErrMalformedChunk
+	}
+	return nil
+}
+
+// readLimitedLine reads a single CRLF (or bare LF) terminated line without
+// the line ending, failing when the line grows beyond limit.
+func readLimitedLine(reader *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		fragment, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = append(line, fragment...)
+		if len(line) > limit {
+			return nil, ErrMalformedChunk
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
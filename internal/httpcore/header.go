@@ -0,0 +1,135 @@
+package httpcore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HeaderMap holds the field lines of a header or trailer section, keyed by
+// lowercased field name. A field sent more than once keeps every value in
+// the order received.
+type HeaderMap map[string][]string
+
+// Get returns the field value of key, its lines combined as one value
+// (RFC 9110 section 5.3): joined with ", ", Cookie with "; ". Set-Cookie
+// cannot be combined, the first line is returned, see Values.
+func (h HeaderMap) Get(key string) string {
+	key = strings.ToLower(key)
+	values := h[key]
+	switch {
+	case len(values) == 0:
+		return ""
+	case key == "set-cookie":
+		return values[0]
+	case key == "cookie":
+		return strings.Join(values, "; ")
+	}
+	return strings.Join(values, ", ")
+}
+
+// Lookup is Get also reporting whether the field is present at all.
+func (h HeaderMap) Lookup(key string) (string, bool) {
+	_, exists := h[strings.ToLower(key)]
+	return h.Get(key), exists
+}
+
+// Values returns the lines of key as received.
+func (h HeaderMap) Values(key string) []string {
+	return h[strings.ToLower(key)]
+}
+
+func (h HeaderMap) Has(key string) bool {
+	_, exists := h[strings.ToLower(key)]
+	return exists
+}
+
+// Add appends a line to key.
+func (h HeaderMap) Add(key string, value string) {
+	key = strings.ToLower(key)
+	h[key] = append(h[key], value)
+}
+
+// Set replaces every line of key with value.
+func (h HeaderMap) Set(key string, value string) {
+	h[strings.ToLower(key)] = []string{value}
+}
+
+func (h HeaderMap) Del(key string) {
+	delete(h, strings.ToLower(key))
+}
+
+// TimeFormat is the date format of HTTP fields like Date and Last-Modified
+// (RFC 9110 section 5.6.7), times have to be in UTC.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+var ErrInvalidHeaderField = errors.New("invalid header field")
+
+// names whose usual spelling is not the canonical one
+var headerKeyExceptions = map[string]string{
+	"etag":                     "ETag",
+	"www-authenticate":         "WWW-Authenticate",
+	"content-md5":              "Content-MD5",
+	"te":                       "TE",
+	"dnt":                      "DNT",
+	"sec-websocket-accept":     "Sec-WebSocket-Accept",
+	"sec-websocket-extensions": "Sec-WebSocket-Extensions",
+	"sec-websocket-key":        "Sec-WebSocket-Key",
+	"sec-websocket-protocol":   "Sec-WebSocket-Protocol",
+	"sec-websocket-version":    "Sec-WebSocket-Version",
+}
+
+// CanonicalHeaderKey returns key as it is sent on the wire, the first letter
+// and every letter following a hyphen uppercased, "content-type" becomes
+// "Content-Type". Invalid names are returned unchanged.
+func CanonicalHeaderKey(key string) string {
+	if !isToken(key) {
+		return key
+	}
+	lower := strings.ToLower(key)
+	if exception, ok := headerKeyExceptions[lower]; ok {
+		return exception
+	}
+
+	canonical := []byte(lower)
+	upper := true
+	for idx, c := range canonical {
+		if upper && 'a' <= c && c <= 'z' {
+			canonical[idx] = c - 'a' + 'A'
+		}
+		upper = c == '-'
+	}
+	return string(canonical)
+}
+
+// ValidHeaderField checks a field before it is sent: the name has to be a
+// token and the value may not hold CR, LF or other control characters
+// besides tab, which would let it inject fields or a body.
+func ValidHeaderField(key string, value string) error {
+	if !isToken(key) {
+		return fmt.Errorf("%w: bad name %q", ErrInvalidHeaderField, key)
+	}
+	for idx := 0; idx < len(value); idx++ {
+		if c := value[idx]; c < ' ' && c != '\t' || c == 0x7f {
+			return fmt.Errorf("%w: bad value for %s", ErrInvalidHeaderField, key)
+		}
+	}
+	return nil
+}
+
+// isToken reports whether s is a token (RFC 9110 section 5.6.2).
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for idx := 0; idx < len(s); idx++ {
+		c := s[idx]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
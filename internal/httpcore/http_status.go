@@ -141,3 +141,8 @@ var httpStatusMessages = map[HttpStatus]string{
 	StatusNotExtended:                   "Not Extended",
 	StatusNetworkAuthenticationRequired: "Network Authentication Required",
 }
+
+// StatusText returns the reason phrase of status, empty for unknown codes.
+func StatusText(status HttpStatus) string {
+	return httpStatusMessages[status]
+}
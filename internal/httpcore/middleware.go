@@ -0,0 +1,40 @@
+package httpcore
+
+// Middleware wraps the next handler of a route. It runs code before and
+// after calling next, or answers the request itself by not calling it.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Chain runs handlers in order until one of them produced a response, the
+// way several handlers given to a single route are run.
+func Chain(handlers ...HandlerFunc) HandlerFunc {
+	if len(handlers) == 1 {
+		return handlers[0]
+	}
+	return func(r Request, w *HttpResponseWriter) {
+		for _, handler := range handlers {
+			handler(r, w)
+			if w.IsReadyForResponse() {
+				return
+			}
+		}
+	}
+}
+
+// Wrap applies middlewares to handler, the first one is the outermost.
+func Wrap(handler HandlerFunc, middlewares ...Middleware) HandlerFunc {
+	for idx := len(middlewares) - 1; idx >= 0; idx-- {
+		handler = middlewares[idx](handler)
+	}
+	return handler
+}
+
+// After returns a middleware running hook once the handler returned. A
+// streamed response has already sent its headers by then.
+func After(hook HandlerFunc) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(r Request, w *HttpResponseWriter) {
+			next(r, w)
+			hook(r, w)
+		}
+	}
+}
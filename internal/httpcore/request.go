@@ -3,8 +3,13 @@ package httpcore
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -13,13 +18,26 @@ import (
 
 type HandlerFunc func(w Request, r *HttpResponseWriter)
 
+var ErrUnsupportedTransferEncoding = errors.New("unsupported transfer encoding")
+
 type Request struct {
-	Method     common.Method
-	Path       string
-	Headers    HeaderMap
-	Body       []byte
-	Query      map[string]string
-	PathParams map[string]string
+	Method common.Method
+	// Path is the decoded path with dot segments removed, RawPath the same
+	// path still escaped, so an encoded "/" can be told from a separator
+	Path          string
+	RawPath       string
+	RawQuery      string
+	Headers       HeaderMap
+	Body          io.ReadCloser
+	ContentLength int64
+	// Query holds every value of repeated keys, Get returns the first
+	Query            url.Values
+	PathParams       map[string]string
+	TransferEncoding []string
+	// Trailers is filled in once a chunked Body has been read to the end
+	Trailers HeaderMap
+	// TLS is set for requests received over TLS
+	TLS *tls.ConnectionState
 }
 
 func ParseRequest(reader *bufio.Reader) (*Request, error) {
@@ -36,70 +54,179 @@ func ParseRequest(reader *bufio.Reader) (*Request, error) {
 	}
 
 	method := common.Method(string(requestLineItems[0]))
-	requestPath, queryMap := getQueryMapFromPath(string(requestLineItems[1]))
+	target, err := parseTarget(method, string(requestLineItems[1]))
+	if err != nil {
+		return nil, err
+	}
 	headerMap := make(HeaderMap)
 
 	// Read headers
+	if err := readHeaderLines(reader, headerMap); err != nil {
+		return nil, fmt.Errorf("failed to read header line: %w", err)
+	}
+	if target.authority != "" {
+		// the authority of the target replaces Host (RFC 9112 section 3.2.2)
+		headerMap.Set("host", target.authority)
+	}
+
+	transferEncoding, err := parseTransferEncoding(headerMap)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.ReadCloser = NoBody
+	var contentLength int64
+	trailers := make(HeaderMap)
+	if len(transferEncoding) > 0 {
+		// Transfer-Encoding overrides Content-Length (RFC 9112 section 6.3)
+		headerMap.Del("content-length")
+
+		body = newBody(newChunkedReader(reader, trailers))
+		contentLength = -1
+	} else if headerMap.Has("content-length") {
+		// Read body if Content-Length exists
+		contentLength, err = parseContentLength(headerMap.Values("content-length"))
+		if err != nil {
+			return nil, err
+		}
+
+		if contentLength > 0 {
+			body = newBody(&lengthReader{src: reader, remaining: contentLength})
+		}
+	}
+
+	return &Request{
+		Method:   method,
+		Path:     target.path,
+		RawPath:  target.rawPath,
+		RawQuery: target.rawQuery,
+		Headers:  headerMap,
+		Body:     body,
+		Query:    target.query,
+
+		ContentLength:    contentLength,
+		TransferEncoding: transferEncoding,
+		Trailers:         trailers,
+	}, nil
+}
+
+// NewRequest builds a request that was not parsed from an HTTP/1 stream,
+// such as one received on an HTTP/2 stream. A nil body means no body.
+func NewRequest(method common.Method, target string, headers HeaderMap, body io.ReadCloser, contentLength int64) (*Request, error) {
+	if body == nil {
+		body = NoBody
+	}
+	parsed, err := parseTarget(method, target)
+	if err != nil {
+		return nil, err
+	}
+	return &Request{
+		Method:        method,
+		Path:          parsed.path,
+		RawPath:       parsed.rawPath,
+		RawQuery:      parsed.rawQuery,
+		Headers:       headers,
+		Body:          body,
+		ContentLength: contentLength,
+		Query:         parsed.query,
+		Trailers:      make(HeaderMap),
+	}, nil
+}
+
+// ClientCertificate returns the client certificate when the peer presented
+// one that was verified against the configured authorities.
+func (r Request) ClientCertificate() *x509.Certificate {
+	chain := r.VerifiedChain()
+	if len(chain) == 0 {
+		return nil
+	}
+	return chain[0]
+}
+
+// VerifiedChain returns the verified client certificate chain, leaf first.
+func (r Request) VerifiedChain() []*x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0]
+}
+
+func (r Request) ClientSubject() (pkix.Name, bool) {
+	certificate := r.ClientCertificate()
+	if certificate == nil {
+		return pkix.Name{}, false
+	}
+	return certificate.Subject, true
+}
+
+func readHeaderLines(reader *bufio.Reader, headerMap HeaderMap) error {
 	for {
 		headerLineBytes, err := reader.ReadBytes('\n')
 		if err != nil {
-			return nil, fmt.Errorf("failed to read header line: %w", err)
+			return err
 		}
 		headerLineBytes = bytes.TrimRight(headerLineBytes, "\r\n")
 
 		if len(headerLineBytes) == 0 {
 			// Empty line signals end of headers
-			break
+			return nil
 		}
 
 		key, value, found := bytes.Cut(headerLineBytes, []byte(": "))
 		if found {
-			headerMap[strings.ToLower(string(key))] = string(value)
+			headerMap.Add(string(key), string(value))
 		}
 	}
+}
 
-	// Read body if Content-Length exists
-	var body []byte
-	if contentLengthStr, ok := headerMap["content-length"]; ok {
-		contentLength, err := strconv.Atoi(contentLengthStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid Content-Length: %w", err)
-		}
-
-		body = make([]byte, contentLength)
-		_, err = io.ReadFull(reader, body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read body: %w", err)
+// parseContentLength reads the Content-Length lines of a request. A list of
+// identical lengths is accepted as the one length (RFC 9110 section 8.6).
+func parseContentLength(lines []string) (int64, error) {
+	var length string
+	for _, line := range lines {
+		for _, value := range strings.Split(line, ",") {
+			value = strings.TrimSpace(value)
+			if length != "" && value != length {
+				return 0, fmt.Errorf("conflicting Content-Length: %q", strings.Join(lines, ", "))
+			}
+			length = value
 		}
 	}
 
-	return &Request{
-		Method:  method,
-		Path:    requestPath,
-		Headers: headerMap,
-		Body:    body,
-		Query:   queryMap,
-	}, nil
+	contentLength, err := strconv.ParseInt(length, 10, 64)
+	if err != nil || contentLength < 0 {
+		return 0, fmt.Errorf("invalid Content-Length: %q", length)
+	}
+	return contentLength, nil
 }
 
-func getQueryMapFromPath(urlPath string) (string, map[string]string) {
-	queryMap := make(map[string]string)
-	queryLineIdx := strings.Index(urlPath, "?")
-
-	if queryLineIdx == -1 {
-		return urlPath, nil
+// parseTransferEncoding returns the codings listed in Transfer-Encoding.
+// Only chunked is understood, any other coding is unsupported wherever it
+// appears. A coding after chunked is malformed rather than unsupported, the
+// length of the body cannot be determined (RFC 9112 section 6.3).
+func parseTransferEncoding(headerMap HeaderMap) ([]string, error) {
+	value, ok := headerMap.Lookup("transfer-encoding")
+	if !ok {
+		return nil, nil
 	}
 
-	path, query, _ := strings.Cut(urlPath, "?")
-	for _, substr := range strings.Split(query, "&") {
-		key, value, found := strings.Cut(substr, "=")
-		if !found {
-			queryMap[substr] = ""
-		} else {
-			queryMap[key] = value
+	codings := make([]string, 0)
+	for _, coding := range strings.Split(value, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" || coding == "identity" {
+			continue
 		}
-
+		if len(codings) > 0 && coding != "chunked" {
+			return nil, fmt.Errorf("transfer coding %s follows chunked", coding)
+		}
+		if coding != "chunked" {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedTransferEncoding, coding)
+		}
+		codings = append(codings, coding)
 	}
 
-	return path, queryMap
+	if len(codings) > 1 {
+		return nil, fmt.Errorf("%w: chunked applied more than once", ErrUnsupportedTransferEncoding)
+	}
+	return codings, nil
 }
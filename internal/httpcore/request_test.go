@@ -1,9 +1,17 @@
 package httpcore_test
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"strings"
 	"testing"
 
+	"github.com/codecrafters-io/http-server-starter-go/internal/common"
 	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
 )
 
@@ -12,10 +20,11 @@ func TestRequest(t *testing.T) {
 		Name        string
 		RawRequest  []byte
 		Path        string
-		Method      httpcore.Method
+		Method      common.Method
 		QueryMap    map[string]string
 		Headers     map[string]string
 		Body        []byte
+		Trailers    map[string]string
 		ExpectError bool
 	}{
 		{
@@ -32,14 +41,14 @@ func TestRequest(t *testing.T) {
 			Name:        "Empty request with only request line",
 			RawRequest:  []byte("GET / HTTP/1.1\r\n\r\n"),
 			Path:        "/",
-			Method:      httpcore.GET,
+			Method:      common.GET,
 			ExpectError: false,
 		},
 		{
 			Name:       "Empty request with only request line and query",
 			RawRequest: []byte("GET /some-query?q=1&y=2 HTTP/1.1\r\n\r\n"),
 			Path:       "/some-query",
-			Method:     httpcore.GET,
+			Method:     common.GET,
 			QueryMap: map[string]string{
 				"q": "1",
 				"y": "2",
@@ -50,33 +59,35 @@ func TestRequest(t *testing.T) {
 			Name:       "Request with headers",
 			RawRequest: []byte("GET / HTTP/1.1\r\nContent-Type: text/plain\r\nServer: Go-server\r\n\r\n"),
 			Path:       "/",
-			Method:     httpcore.GET,
+			Method:     common.GET,
 			Headers: map[string]string{
-				"Content-Type": "text/plain",
-				"Server":       "Go-server",
+				"content-type": "text/plain",
+				"server":       "Go-server",
 			},
 			ExpectError: false,
 		},
 		{
 			Name:       "Request with headers and body",
-			RawRequest: []byte("GET / HTTP/1.1\r\nContent-Type: text/plain\r\nServer: Go-server\r\n\r\nHello World!"),
+			RawRequest: []byte("GET / HTTP/1.1\r\nContent-Type: text/plain\r\nServer: Go-server\r\nContent-Length: 12\r\n\r\nHello World!"),
 			Path:       "/",
-			Method:     httpcore.GET,
+			Method:     common.GET,
 			Headers: map[string]string{
-				"Content-Type": "text/plain",
-				"Server":       "Go-server",
+				"content-type":   "text/plain",
+				"server":         "Go-server",
+				"content-length": "12",
 			},
 			Body:        []byte("Hello World!"),
 			ExpectError: false,
 		},
 		{
 			Name:       "Request with headers, query and body",
-			RawRequest: []byte("GET /path?long=10&lat=20.3 HTTP/1.1\r\nContent-Type: text/plain\r\nServer: Go-server\r\n\r\nHello World!"),
+			RawRequest: []byte("GET /path?long=10&lat=20.3 HTTP/1.1\r\nContent-Type: text/plain\r\nServer: Go-server\r\nContent-Length: 12\r\n\r\nHello World!"),
 			Path:       "/path",
-			Method:     httpcore.GET,
+			Method:     common.GET,
 			Headers: map[string]string{
-				"Content-Type": "text/plain",
-				"Server":       "Go-server",
+				"content-type":   "text/plain",
+				"server":         "Go-server",
+				"content-length": "12",
 			},
 			Body: []byte("Hello World!"),
 			QueryMap: map[string]string{
@@ -85,12 +96,93 @@ func TestRequest(t *testing.T) {
 			},
 			ExpectError: false,
 		},
+		{
+			Name:       "Repeated query keys and header lines",
+			RawRequest: []byte("GET /tags?tag=a&tag=b HTTP/1.1\r\nAccept: text/html\r\nAccept: application/json\r\n\r\n"),
+			Path:       "/tags",
+			Method:     common.GET,
+			Headers: map[string]string{
+				"accept": "text/html, application/json",
+			},
+			QueryMap: map[string]string{
+				"tag": "a, b",
+			},
+		},
+		{
+			Name:        "Should return error on conflicting Content-Length",
+			RawRequest:  []byte("POST / HTTP/1.1\r\nContent-Length: 2\r\nContent-Length: 3\r\n\r\nabc"),
+			ExpectError: true,
+		},
+		{
+			Name:       "Repeated identical Content-Length",
+			RawRequest: []byte("POST / HTTP/1.1\r\nContent-Length: 3, 3\r\n\r\nabc"),
+			Path:       "/",
+			Method:     common.POST,
+			Body:       []byte("abc"),
+		},
+		{
+			Name:       "Request with chunked body",
+			RawRequest: []byte("POST /files/a HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nHello\r\n7\r\n World!\r\n0\r\n\r\n"),
+			Path:       "/files/a",
+			Method:     common.POST,
+			Body:       []byte("Hello World!"),
+			Trailers:   map[string]string{},
+		},
+		{
+			Name:       "Request with chunk extensions and trailers",
+			RawRequest: []byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nTrailer: Checksum\r\n\r\nc;name=value\r\nHello World!\r\n0;last\r\nChecksum: abc\r\n\r\n"),
+			Path:       "/",
+			Method:     common.POST,
+			Body:       []byte("Hello World!"),
+			Trailers: map[string]string{
+				"checksum": "abc",
+			},
+		},
+		{
+			Name:       "Transfer-Encoding takes precedence over Content-Length",
+			RawRequest: []byte("POST / HTTP/1.1\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\n"),
+			Path:       "/",
+			Method:     common.POST,
+			Headers: map[string]string{
+				"transfer-encoding": "chunked",
+			},
+			Body: []byte("hi"),
+		},
+		{
+			Name:        "Should return error on negative Content-Length",
+			RawRequest:  []byte("POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n"),
+			ExpectError: true,
+		},
+		{
+			Name:        "Should return error on invalid chunk size",
+			RawRequest:  []byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nHello\r\n0\r\n\r\n"),
+			ExpectError: true,
+		},
+		{
+			Name:        "Should return error on missing chunk terminator",
+			RawRequest:  []byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhello\r\n0\r\n\r\n"),
+			ExpectError: true,
+		},
+		{
+			Name:        "Should return error on truncated chunked body",
+			RawRequest:  []byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nHel"),
+			ExpectError: true,
+		},
+		{
+			Name:        "Should return error on unsupported transfer coding",
+			RawRequest:  []byte("POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n"),
+			ExpectError: true,
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
 			tc := tc
-			response, err := httpcore.ParseRequest(tc.RawRequest)
+			response, err := httpcore.ParseRequest(bufio.NewReader(bytes.NewReader(tc.RawRequest)))
+			var body []byte
+			if err == nil {
+				body, err = io.ReadAll(response.Body)
+			}
 			if tc.ExpectError && err == nil {
 				t.Errorf("[ %s ]Was expecting error but no error was returned", tc.Name)
 				t.Fail()
@@ -98,7 +190,7 @@ func TestRequest(t *testing.T) {
 				t.Errorf("[ %s ]Was not expecting error but error (%v) was returned", tc.Name, err)
 				t.Fail()
 			} else if !tc.ExpectError && err == nil {
-				if tc.Body != nil && !bytes.Equal(tc.Body, response.Body) {
+				if tc.Body != nil && !bytes.Equal(tc.Body, body) {
 					t.Errorf("[ %s ]actual body and expected body are separate", tc.Name)
 					t.Fail()
 				}
@@ -106,6 +198,10 @@ func TestRequest(t *testing.T) {
 					t.Errorf("[ %s ]actual headers and expected headers are separate", tc.Name)
 					t.Fail()
 				}
+				if tc.Trailers != nil && !mapsAreEqual(tc.Trailers, response.Trailers) {
+					t.Errorf("[ %s ]actual trailers and expected trailers are separate", tc.Name)
+					t.Fail()
+				}
 				if tc.QueryMap != nil && !mapsAreEqual(tc.QueryMap, response.Query) {
 					t.Errorf("[ %s ]actual query and expected query are separate", tc.Name)
 					t.Fail()
@@ -123,7 +219,211 @@ func TestRequest(t *testing.T) {
 	}
 }
 
-func mapsAreEqual(map1, map2 map[string]string) bool {
+func TestTransferEncodingOrder(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		Value       string
+		Unsupported bool
+		Malformed   bool
+	}{
+		{Name: "chunked", Value: "chunked"},
+		{Name: "identity is skipped", Value: "identity, chunked"},
+		{Name: "unsupported coding before chunked", Value: "gzip, chunked", Unsupported: true},
+		{Name: "coding after chunked", Value: "chunked, gzip", Malformed: true},
+		{Name: "chunked twice", Value: "chunked, chunked", Unsupported: true},
+	}
+
+	for _, tc := range testCases {
+		raw := "POST / HTTP/1.1\r\nTransfer-Encoding: " + tc.Value + "\r\n\r\n0\r\n\r\n"
+		_, err := httpcore.ParseRequest(bufio.NewReader(strings.NewReader(raw)))
+		if (err != nil) != (tc.Unsupported || tc.Malformed) {
+			t.Errorf("[ %s ]unexpected error %v", tc.Name, err)
+			continue
+		}
+		// a 501 for unsupported codings, a 400 for malformed framing
+		if errors.Is(err, httpcore.ErrUnsupportedTransferEncoding) != tc.Unsupported {
+			t.Errorf("[ %s ]expected unsupported %v, got %v", tc.Name, tc.Unsupported, err)
+		}
+	}
+}
+
+func TestRequestTarget(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		RequestLine string
+		Path        string
+		RawPath     string
+		QueryMap    map[string]string
+		Host        string
+		ExpectError bool
+	}{
+		{Name: "decoded path", RequestLine: "GET /echo/hello%20world%E2%9C%93 HTTP/1.1", Path: "/echo/hello world✓", RawPath: "/echo/hello%20world%E2%9C%93"},
+		{Name: "encoded slash kept in raw path", RequestLine: "GET /files/a%2fb HTTP/1.1", Path: "/files/a/b", RawPath: "/files/a%2Fb"},
+		{Name: "unreserved escapes decoded", RequestLine: "GET /%7Euser/%61 HTTP/1.1", Path: "/~user/a", RawPath: "/~user/a"},
+		{Name: "dot segments", RequestLine: "GET /a/./b/../c/ HTTP/1.1", Path: "/a/c/", RawPath: "/a/c/"},
+		{Name: "escaped dot segments", RequestLine: "GET /files/%2e%2E/%2E%2e/etc/passwd HTTP/1.1", Path: "/etc/passwd", RawPath: "/etc/passwd"},
+		{Name: "no climbing above root", RequestLine: "GET /../../a HTTP/1.1", Path: "/a", RawPath: "/a"},
+		{Name: "merged slashes", RequestLine: "GET //a///b HTTP/1.1", Path: "/a/b", RawPath: "/a/b"},
+		{
+			Name: "decoded query", RequestLine: "GET /search?q=hello+world&tag=caf%C3%A9&flag&bad=%zz HTTP/1.1", Path: "/search", RawPath: "/search",
+			QueryMap: map[string]string{"q": "hello world", "tag": "café", "flag": "", "bad": "%zz"},
+		},
+		{Name: "absolute form", RequestLine: "GET http://example.test:8080/a/b?x=1 HTTP/1.1", Path: "/a/b", RawPath: "/a/b", Host: "example.test:8080", QueryMap: map[string]string{"x": "1"}},
+		{Name: "absolute form without path", RequestLine: "GET HTTPS://example.test HTTP/1.1", Path: "/", RawPath: "/", Host: "example.test"},
+		{Name: "authority form", RequestLine: "CONNECT example.test:443 HTTP/1.1", Host: "example.test:443"},
+		{Name: "asterisk form", RequestLine: "OPTIONS * HTTP/1.1", Path: "*", RawPath: "*"},
+		{Name: "asterisk form for GET", RequestLine: "GET * HTTP/1.1", ExpectError: true},
+		{Name: "bad escape", RequestLine: "GET /a%2 HTTP/1.1", ExpectError: true},
+		{Name: "fragment", RequestLine: "GET /a#b HTTP/1.1", ExpectError: true},
+		{Name: "relative path", RequestLine: "GET a/b HTTP/1.1", ExpectError: true},
+		{Name: "unknown scheme", RequestLine: "GET ftp://example.test/a HTTP/1.1", ExpectError: true},
+		{Name: "user info", RequestLine: "GET http://user@example.test/ HTTP/1.1", ExpectError: true},
+		{Name: "literal space", RequestLine: "GET /a b HTTP/1.1", ExpectError: true},
+	}
+
+	for _, tc := range testCases {
+		raw := tc.RequestLine + "\r\nHost: origin.test\r\n\r\n"
+		request, err := httpcore.ParseRequest(bufio.NewReader(strings.NewReader(raw)))
+		if tc.ExpectError {
+			if err == nil {
+				t.Errorf("[ %s ]Was expecting error but no error was returned", tc.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[ %s ]Was not expecting error but error (%v) was returned", tc.Name, err)
+			continue
+		}
+		if request.Path != tc.Path || request.RawPath != tc.RawPath {
+			t.Errorf("[ %s ]expected path %q raw %q, got %q raw %q", tc.Name, tc.Path, tc.RawPath, request.Path, request.RawPath)
+		}
+		if tc.QueryMap != nil && !mapsAreEqual(tc.QueryMap, request.Query) {
+			t.Errorf("[ %s ]expected query %v, got %v", tc.Name, tc.QueryMap, request.Query)
+		}
+		host := tc.Host
+		if host == "" {
+			host = "origin.test"
+		}
+		if request.Headers.Get("host") != host {
+			t.Errorf("[ %s ]expected host %q, got %q", tc.Name, host, request.Headers.Get("host"))
+		}
+	}
+}
+
+func TestHeaderMap(t *testing.T) {
+	headers := make(httpcore.HeaderMap)
+	headers.Add("Accept", "text/html")
+	headers.Add("accept", "application/json;q=0.9")
+	headers.Add("Cookie", "a=1")
+	headers.Add("Cookie", "b=2")
+	headers.Add("Set-Cookie", "a=1; Path=/")
+	headers.Add("Set-Cookie", "b=2; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+
+	testCases := []struct {
+		Name     string
+		Key      string
+		Expected string
+		Values   int
+	}{
+		{Name: "folded with commas", Key: "ACCEPT", Expected: "text/html, application/json;q=0.9", Values: 2},
+		{Name: "cookies folded with semicolons", Key: "cookie", Expected: "a=1; b=2", Values: 2},
+		{Name: "set-cookie is never folded", Key: "Set-Cookie", Expected: "a=1; Path=/", Values: 2},
+		{Name: "missing", Key: "X-Missing", Expected: "", Values: 0},
+	}
+
+	for _, tc := range testCases {
+		if value := headers.Get(tc.Key); value != tc.Expected {
+			t.Errorf("[ %s ]expected %q, got %q", tc.Name, tc.Expected, value)
+		}
+		if values := headers.Values(tc.Key); len(values) != tc.Values {
+			t.Errorf("[ %s ]expected %d values, got %v", tc.Name, tc.Values, values)
+		}
+	}
+
+	headers.Set("Accept", "*/*")
+	headers.Del("COOKIE")
+	if headers.Get("accept") != "*/*" || headers.Has("cookie") {
+		t.Errorf("unexpected headers after Set and Del %v", headers)
+	}
+}
+
+func TestRequestBodyOnKeepAliveConnection(t *testing.T) {
+	raw := "POST /a HTTP/1.1\r\nContent-Length: 5\r\n\r\nHello" +
+		"POST /b HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n" +
+		"GET /c HTTP/1.1\r\n\r\n"
+	reader := bufio.NewReader(strings.NewReader(raw))
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		request, err := httpcore.ParseRequest(reader)
+		if err != nil {
+			t.Fatalf("[ %s ]unexpected error %v", path, err)
+		}
+		if request.Path != path {
+			t.Fatalf("expected path %s, got %s", path, request.Path)
+		}
+		// bodies are left unread, Close has to drain them
+		if err := request.Body.Close(); err != nil {
+			t.Fatalf("[ %s ]unexpected error on close %v", path, err)
+		}
+	}
+}
+
+func TestRequestBodyIsBounded(t *testing.T) {
+	raw := "POST / HTTP/1.1\r\nContent-Length: 10737418240\r\n\r\n" + strings.Repeat("a", 1<<20)
+	request, err := httpcore.ParseRequest(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if request.ContentLength != 10737418240 {
+		t.Errorf("unexpected content length %d", request.ContentLength)
+	}
+
+	if err := request.Body.Close(); !errors.Is(err, httpcore.ErrBodyNotDrained) {
+		t.Errorf("expected ErrBodyNotDrained, got %v", err)
+	}
+	if _, err := request.Body.Read(make([]byte, 1)); !errors.Is(err, httpcore.ErrBodyClosed) {
+		t.Errorf("expected ErrBodyClosed, got %v", err)
+	}
+}
+
+func TestRequestBodyTruncated(t *testing.T) {
+	raw := "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nHello"
+	request, err := httpcore.ParseRequest(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := io.ReadAll(request.Body); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestRequestClientCertificate(t *testing.T) {
+	leaf := &x509.Certificate{Subject: pkix.Name{CommonName: "svc-a", Organization: []string{"internal"}}}
+	root := &x509.Certificate{Subject: pkix.Name{CommonName: "test-ca"}}
+
+	request := httpcore.Request{}
+	if _, ok := request.ClientSubject(); ok {
+		t.Errorf("plain text request should not have a client subject")
+	}
+
+	request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+	if request.ClientCertificate() != nil {
+		t.Errorf("unverified peer certificates should not be exposed as client certificate")
+	}
+
+	request.TLS.VerifiedChains = [][]*x509.Certificate{{leaf, root}}
+	subject, ok := request.ClientSubject()
+	if !ok || subject.CommonName != "svc-a" {
+		t.Errorf("unexpected client subject %v", subject)
+	}
+	if chain := request.VerifiedChain(); len(chain) != 2 || chain[1] != root {
+		t.Errorf("unexpected verified chain %v", chain)
+	}
+}
+
+// mapsAreEqual compares expected values with the lines of a header or query
+// map, several lines are compared joined with ", ".
+func mapsAreEqual[M ~map[string][]string](map1 map[string]string, map2 M) bool {
 	// First check if the lengths are the same
 	if len(map1) != len(map2) {
 		return false
@@ -131,7 +431,7 @@ func mapsAreEqual(map1, map2 map[string]string) bool {
 
 	// Then, check if each key-value pair is the same
 	for key, value := range map1 {
-		if map2[key] != value {
+		if strings.Join(map2[key], ", ") != value {
 			return false
 		}
 	}
@@ -1,37 +1,205 @@
 package httpcore
 
-import "fmt"
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+)
 
-type HeaderMap map[string]string
+var ErrNoStream = errors.New("response writer is not attached to a connection")
+
+type HeaderField struct {
+	Key   string
+	Value string
+}
 
 type HttpResponseWriter struct {
 	statusCode    *HttpStatus
 	statusMessage string
 	headers       HeaderMap
+	headerOrder   []string
+	trailers      HeaderMap
+	trailerOrder  []string
 	Body          []byte
+
+	stream    StreamWriter
+	wrapped   StreamWriter
+	streaming bool
+	finished  bool
+	hijacked  bool
+
+	noCompression bool
 }
 
+// NewHttpResponseWriter returns a writer that buffers the whole response in
+// memory, it is turned into bytes with ToResponseByte.
 func NewHttpResponseWriter() HttpResponseWriter {
 	return HttpResponseWriter{
-		headers: make(HeaderMap),
+		headers:  make(HeaderMap),
+		trailers: make(HeaderMap),
 	}
 }
 
+// NewStreamingResponseWriter returns a writer backed by stream. Handlers can
+// keep using Write for small bodies or switch to streaming with BodyWriter
+// and Flush, in which case the status line and headers are sent on the first
+// write and the body goes to the connection as it is produced.
+func NewStreamingResponseWriter(stream StreamWriter) HttpResponseWriter {
+	w := NewHttpResponseWriter()
+	w.stream = stream
+	return w
+}
+
 func (w HttpResponseWriter) IsReadyForResponse() bool {
-	return w.Body != nil || w.statusCode != nil
+	return w.Body != nil || w.statusCode != nil || w.streaming || w.hijacked
 }
 
 func (w HttpResponseWriter) IsStatusSet() bool {
 	return w.statusCode != nil
 }
 
+func (w HttpResponseWriter) IsStreaming() bool {
+	return w.streaming
+}
+
+func (w HttpResponseWriter) IsHijacked() bool {
+	return w.hijacked
+}
+
+// DisableCompression asks the server to send the body in the coding the
+// handler wrote it in.
+func (w *HttpResponseWriter) DisableCompression() {
+	w.noCompression = true
+}
+
+func (w HttpResponseWriter) IsCompressionDisabled() bool {
+	return w.noCompression
+}
+
+// Hijack hands the connection over to the caller, nothing more is written to
+// it by the server once the handler returns. It fails when the response
+// already started or the protocol does not allow it, like HTTP/2.
+func (w *HttpResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.stream.(Hijacker)
+	if !ok || w.streaming || w.hijacked {
+		return nil, nil, ErrNotHijackable
+	}
+
+	conn, readWriter, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	w.finished = true
+	return conn, readWriter, nil
+}
+
+// CloseNotify returns a channel closed when the client goes away, nil when
+// the stream cannot tell. Over HTTP/1.1 the connection is closed after the
+// response.
+func (w *HttpResponseWriter) CloseNotify() <-chan struct{} {
+	notifier, ok := w.stream.(CloseNotifier)
+	if !ok {
+		return nil
+	}
+	gone := notifier.CloseNotify()
+	if http1, ok := w.stream.(*Http1StreamWriter); ok && !http1.Reusable() {
+		w.SetHeader("Connection", "close")
+	}
+	return gone
+}
+
 func (w *HttpResponseWriter) SetStatus(httpStatus HttpStatus) {
+	if w.streaming {
+		return
+	}
 	w.statusCode = &httpStatus
 	w.statusMessage = httpStatusMessages[httpStatus]
 }
 
-func (w *HttpResponseWriter) SetHeader(key string, value string) {
-	w.headers[key] = value
+// SetHeader replaces every line of the header key with value. The name is
+// sent canonicalized. A field failing ValidHeaderField is not set and its
+// error, wrapping ErrInvalidHeaderField, is returned.
+func (w *HttpResponseWriter) SetHeader(key string, value string) error {
+	if w.streaming {
+		return nil
+	}
+	if err := ValidHeaderField(key, value); err != nil {
+		return err
+	}
+	if !w.headers.Has(key) {
+		w.headerOrder = append(w.headerOrder, CanonicalHeaderKey(key))
+	}
+	w.headers.Set(key, value)
+	return nil
+}
+
+// AddHeader adds a line to the header key, each line is sent on its own,
+// as needed for Set-Cookie. Invalid fields are rejected as by SetHeader.
+func (w *HttpResponseWriter) AddHeader(key string, value string) error {
+	if w.streaming {
+		return nil
+	}
+	if err := ValidHeaderField(key, value); err != nil {
+		return err
+	}
+	if !w.headers.Has(key) {
+		w.headerOrder = append(w.headerOrder, CanonicalHeaderKey(key))
+	}
+	w.headers.Add(key, value)
+	return nil
+}
+
+// GetHeader returns the combined value of the header key, see HeaderMap.Get.
+func (w *HttpResponseWriter) GetHeader(key string) (string, bool) {
+	return w.headers.Lookup(key)
+}
+
+// HeaderValues returns every line of the header key.
+func (w *HttpResponseWriter) HeaderValues(key string) []string {
+	return w.headers.Values(key)
+}
+
+func (w *HttpResponseWriter) DeleteHeader(key string) {
+	if w.streaming {
+		return
+	}
+	if !w.headers.Has(key) {
+		return
+	}
+	w.headers.Del(key)
+	for idx, name := range w.headerOrder {
+		if strings.EqualFold(name, key) {
+			w.headerOrder = append(w.headerOrder[:idx], w.headerOrder[idx+1:]...)
+			break
+		}
+	}
+}
+
+// SetTrailer sets a field sent after the body. Trailers set before the
+// response starts streaming are announced in the Trailer header. Invalid
+// fields are rejected as by SetHeader.
+func (w *HttpResponseWriter) SetTrailer(key string, value string) error {
+	if err := ValidHeaderField(key, value); err != nil {
+		return err
+	}
+	key = CanonicalHeaderKey(key)
+	if !w.trailers.Has(key) {
+		w.trailerOrder = append(w.trailerOrder, key)
+		if !w.streaming {
+			if announced, ok := w.headers.Lookup("Trailer"); ok {
+				w.SetHeader("Trailer", announced+", "+key)
+			} else {
+				w.SetHeader("Trailer", key)
+			}
+		}
+	}
+	w.trailers.Set(key, value)
+	return nil
 }
 
 func (w *HttpResponseWriter) Write(body []byte) {
@@ -39,22 +207,164 @@ func (w *HttpResponseWriter) Write(body []byte) {
 	w.Body = body
 }
 
+// BodyWriter returns a writer streaming straight to the connection. The
+// status line and headers are sent on the first write, so they have to be
+// set before. Without a Content-Length header the body is sent chunked.
+func (w *HttpResponseWriter) BodyWriter() io.Writer {
+	return responseBodyWriter{w}
+}
+
+// Flush sends the status line and headers if they were not sent yet and
+// pushes everything written so far to the client.
+func (w *HttpResponseWriter) Flush() error {
+	if err := w.startStreaming(); err != nil {
+		return err
+	}
+	return w.out().Flush()
+}
+
+// Finish completes the response on the attached stream, either by ending a
+// streamed body or by sending the buffered one.
+func (w *HttpResponseWriter) Finish() error {
+	if w.stream == nil {
+		return ErrNoStream
+	}
+	if w.finished {
+		return nil
+	}
+
+	if !w.streaming {
+		if !w.headers.Has("Content-Length") && len(w.trailers) == 0 && bodyAllowed(w.status()) {
+			w.SetHeader("Content-Length", fmt.Sprintf("%d", len(w.Body)))
+		}
+		if err := w.startStreaming(); err != nil {
+			return err
+		}
+		if len(w.Body) > 0 {
+			if err := w.out().WriteData(w.Body); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.finished = true
+	return w.out().End(orderedFields(w.trailers, w.trailerOrder))
+}
+
+func (w *HttpResponseWriter) startStreaming() error {
+	if w.stream == nil {
+		return ErrNoStream
+	}
+	if w.streaming {
+		return nil
+	}
+
+	status := w.status()
+	w.SetStatus(status)
+	w.streaming = true
+	return w.out().WriteHead(status, w.statusMessage, w.headerFields())
+}
+
+// WrapStream routes the response through the stream returned by wrap,
+// such as one encoding the body, before it reaches the connection. It has
+// no effect once the response started.
+func (w *HttpResponseWriter) WrapStream(wrap func(StreamWriter) StreamWriter) {
+	if w.stream == nil || w.streaming {
+		return
+	}
+	w.wrapped = wrap(w.out())
+}
+
+// out is the stream the response is written to, Hijack and CloseNotify
+// still go to the connection's own stream.
+func (w *HttpResponseWriter) out() StreamWriter {
+	if w.wrapped != nil {
+		return w.wrapped
+	}
+	return w.stream
+}
+
+// Status returns the status set so far, 200 when none was.
+func (w HttpResponseWriter) Status() HttpStatus {
+	return w.status()
+}
+
+func (w HttpResponseWriter) status() HttpStatus {
+	if w.statusCode == nil {
+		return StatusOK
+	}
+	return *w.statusCode
+}
+
 func (w HttpResponseWriter) ToResponseByte() []byte {
 	separator := "\r\n"
+	if w.statusCode == nil {
+		w.SetStatus(StatusOK)
+	}
 	statusLine := []byte(fmt.Sprintf("HTTP/1.1 %d %s%s", *w.statusCode, w.statusMessage, separator))
 
 	headerLine := ""
 
-	for key, value := range w.headers {
-		headerLine += fmt.Sprintf("%s: %s%s", key, value, separator)
+	for _, field := range w.headerFields() {
+		headerLine += fmt.Sprintf("%s: %s%s", field.Key, field.Value, separator)
 	}
 	headerLine += separator
 	headerLineBytes := []byte(headerLine)
 
-	resp := make([]byte, 0)
-	resp = append(statusLine, headerLineBytes...)
+	resp := append(statusLine, headerLineBytes...)
 
 	resp = append(resp, w.Body...)
-	fmt.Printf("%q\n", resp)
 	return resp
 }
+
+type responseBodyWriter struct {
+	w *HttpResponseWriter
+}
+
+func (b responseBodyWriter) Write(p []byte) (int, error) {
+	if err := b.w.startStreaming(); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := b.w.out().WriteData(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// headerFields lists the header lines in the order they are sent: Date and
+// Server first, the others in the order they were first set.
+func (w HttpResponseWriter) headerFields() []HeaderField {
+	order := make([]string, 0, len(w.headerOrder))
+	for _, first := range []string{"Date", "Server"} {
+		if slices.Contains(w.headerOrder, first) {
+			order = append(order, first)
+		}
+	}
+	for _, key := range w.headerOrder {
+		if key != "Date" && key != "Server" {
+			order = append(order, key)
+		}
+	}
+	return orderedFields(w.headers, order)
+}
+
+// orderedFields lists the lines of headers in the order the names were
+// first set, a name with several lines gives one field per line.
+func orderedFields(headers HeaderMap, order []string) []HeaderField {
+	fields := make([]HeaderField, 0, len(order))
+	for _, key := range order {
+		for _, value := range headers.Values(key) {
+			fields = append(fields, HeaderField{Key: key, Value: value})
+		}
+	}
+	return fields
+}
+
+// bodyAllowed reports whether a response with status may carry a body
+// (RFC 9110 section 6.4.1).
+func bodyAllowed(status HttpStatus) bool {
+	return status >= 200 && status != StatusNoContent && status != StatusNotModified
+}
@@ -1,62 +1,264 @@
 package httpcore_test
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
 )
 
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestResponse compares serialized responses with testdata/*.golden, run
+// with -update to rewrite them after an intended change.
 func TestResponse(t *testing.T) {
 	testCases := []struct {
-		Name     string
-		Headers  map[string]string
-		Status   httpcore.HttpStatus
-		Body     []byte
-		Expected []byte
+		Name    string
+		Golden  string
+		Handler func(w *httpcore.HttpResponseWriter)
 	}{
 		{
-			Name:     "Empty 200 response",
-			Status:   httpcore.StatusAccepted,
-			Expected: []byte("HTTP/1.1 200 OK\r\n\r\n"),
+			Name:    "Empty 200 response",
+			Golden:  "empty",
+			Handler: func(w *httpcore.HttpResponseWriter) {},
+		},
+		{
+			Name:   "Response with Header and body",
+			Golden: "body",
+			Handler: func(w *httpcore.HttpResponseWriter) {
+				w.SetHeader("Content-Type", "text/plain")
+				w.Write([]byte("Hello world"))
+			},
+		},
+		{
+			Name:   "Date and Server go first",
+			Golden: "date-server-first",
+			Handler: func(w *httpcore.HttpResponseWriter) {
+				w.SetHeader("Content-Type", "text/plain")
+				w.SetHeader("X-Request-Id", "42")
+				w.SetHeader("Server", "Go-server")
+				w.SetHeader("Date", "Sun, 18 Oct 2026 10:00:00 GMT")
+				w.SetHeader("Cache-Control", "no-cache")
+			},
+		},
+		{
+			Name:   "Names are canonicalized",
+			Golden: "canonical",
+			Handler: func(w *httpcore.HttpResponseWriter) {
+				w.SetHeader("content-type", "text/plain")
+				w.SetHeader("x-FORWARDED-for", "10.0.0.1")
+				w.SetHeader("etag", `"v1"`)
+				w.SetHeader("www-authenticate", "Basic")
+				w.SetHeader("CONTENT-TYPE", "application/json")
+			},
 		},
 		{
-			Name: "Response with header",
-			Headers: map[string]string{
-				"Content-Type": "text/plain",
-				"Server":       "Go-server",
+			Name:   "Every Set-Cookie on its own line",
+			Golden: "set-cookie",
+			Handler: func(w *httpcore.HttpResponseWriter) {
+				w.AddHeader("Set-Cookie", "a=1; Path=/")
+				w.AddHeader("set-cookie", "b=2; HttpOnly")
+				w.SetStatus(httpcore.StatusNoContent)
 			},
-			Status:   httpcore.StatusAccepted,
-			Expected: []byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nServer: Go-server\r\n\r\n"),
 		},
 		{
-			Name: "Response with Header and body",
-			Headers: map[string]string{
-				"Server":       "Go-server",
-				"Content-Type": "text/plain",
+			Name:   "Injected lines are dropped",
+			Golden: "injection",
+			Handler: func(w *httpcore.HttpResponseWriter) {
+				w.SetHeader("Location", "/next\r\nSet-Cookie: session=stolen")
+				w.SetHeader("X-Bad\r\nSet-Cookie", "session=stolen")
+				w.SetHeader("Bad Name", "value")
+				w.AddHeader("X-Null", "a\x00b")
+				w.SetHeader("X-Tab", "a\tb")
+				w.SetStatus(httpcore.StatusFound)
+			},
+		},
+		{
+			Name:   "Deleted headers keep the order of the others",
+			Golden: "deleted",
+			Handler: func(w *httpcore.HttpResponseWriter) {
+				w.SetHeader("X-First", "1")
+				w.SetHeader("X-Second", "2")
+				w.SetHeader("X-Third", "3")
+				w.DeleteHeader("x-second")
+				w.SetHeader("X-Second", "again")
 			},
-			Body:     []byte("Hello world"),
-			Status:   httpcore.StatusAccepted,
-			Expected: []byte("HTTP/1.1 200 OK\r\nServer: Go-server\r\nContent-Type: text/plain\r\n\r\nHello world"),
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
-			tc := tc
 			writer := httpcore.NewHttpResponseWriter()
-			if tc.Headers != nil {
-				for key, value := range tc.Headers {
-					writer.SetHeader(key, value)
+			tc.Handler(&writer)
+			actual := writer.ToResponseByte()
+
+			golden := filepath.Join("testdata", tc.Golden+".golden")
+			if *update {
+				if err := os.WriteFile(golden, actual, 0o644); err != nil {
+					t.Fatalf("unexpected error %v", err)
 				}
 			}
-			if tc.Body != nil {
-				writer.Write(tc.Body)
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !bytes.Equal(expected, actual) {
+				t.Errorf("[ %s ]expected %q, got %q", tc.Name, expected, actual)
+			}
+		})
+	}
+}
+
+func TestInvalidFieldIsNotWritten(t *testing.T) {
+	var out bytes.Buffer
+	buffered := bufio.NewWriter(&out)
+	stream := httpcore.NewHttp1StreamWriter(buffered)
+	fields := []httpcore.HeaderField{{Key: "X-Ok", Value: "1"}, {Key: "X-Bad", Value: "a\nb"}}
+	if err := stream.WriteHead(httpcore.StatusOK, "OK", fields); !errors.Is(err, httpcore.ErrInvalidHeaderField) {
+		t.Errorf("expected ErrInvalidHeaderField, got %v", err)
+	}
+	buffered.Flush()
+	if strings.Contains(out.String(), "X-Bad") {
+		t.Errorf("invalid field was written %q", out.String())
+	}
+}
+
+func TestInvalidFieldIsRejected(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Set  func(w *httpcore.HttpResponseWriter) error
+	}{
+		{Name: "Location with CRLF", Set: func(w *httpcore.HttpResponseWriter) error {
+			return w.SetHeader("Location", "/next\r\nSet-Cookie: session=stolen")
+		}},
+		{Name: "Bad name", Set: func(w *httpcore.HttpResponseWriter) error { return w.SetHeader("X Bad", "1") }},
+		{Name: "Added line", Set: func(w *httpcore.HttpResponseWriter) error { return w.AddHeader("Set-Cookie", "a=1\x00") }},
+		{Name: "Trailer", Set: func(w *httpcore.HttpResponseWriter) error { return w.SetTrailer("Checksum", "a\nb") }},
+	}
+
+	for _, tc := range testCases {
+		writer := httpcore.NewHttpResponseWriter()
+		if err := tc.Set(&writer); !errors.Is(err, httpcore.ErrInvalidHeaderField) {
+			t.Errorf("[ %s ]expected ErrInvalidHeaderField, got %v", tc.Name, err)
+		}
+		writer.SetStatus(httpcore.StatusOK)
+		if got := string(writer.ToResponseByte()); got != "HTTP/1.1 200 OK\r\n\r\n" {
+			t.Errorf("[ %s ]expected nothing set, got %q", tc.Name, got)
+		}
+	}
+
+	writer := httpcore.NewHttpResponseWriter()
+	if err := writer.SetHeader("Location", "/next"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestCanonicalHeaderKey(t *testing.T) {
+	testCases := map[string]string{
+		"content-type":         "Content-Type",
+		"X-REQUEST-ID":         "X-Request-Id",
+		"sec-websocket-accept": "Sec-WebSocket-Accept",
+		"etag":                 "ETag",
+		"bad name":             "bad name",
+	}
+	for key, expected := range testCases {
+		if actual := httpcore.CanonicalHeaderKey(key); actual != expected {
+			t.Errorf("[ %s ]expected %q, got %q", key, expected, actual)
+		}
+	}
+}
+
+func TestStreamingResponse(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Handler  func(w *httpcore.HttpResponseWriter)
+		Expected []byte
+	}{
+		{
+			Name: "Buffered body gets a Content-Length",
+			Handler: func(w *httpcore.HttpResponseWriter) {
+				w.SetHeader("Content-Type", "text/plain")
+				w.Write([]byte("Hello world"))
+			},
+			Expected: []byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 11\r\n\r\nHello world"),
+		},
+		{
+			Name: "Empty buffered response",
+			Handler: func(w *httpcore.HttpResponseWriter) {
+				w.SetStatus(httpcore.StatusNotFound)
+			},
+			Expected: []byte("HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"),
+		},
+		{
+			Name: "Streamed body without length is chunked",
+			Handler: func(w *httpcore.HttpResponseWriter) {
+				w.SetHeader("Content-Type", "text/plain")
+				io.WriteString(w.BodyWriter(), "Hello")
+				w.Flush()
+				io.WriteString(w.BodyWriter(), " world")
+			},
+			Expected: []byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nHello\r\n6\r\n world\r\n0\r\n\r\n"),
+		},
+		{
+			Name: "Streamed body with length is sent as is",
+			Handler: func(w *httpcore.HttpResponseWriter) {
+				w.SetHeader("Content-Length", "11")
+				io.WriteString(w.BodyWriter(), "Hello")
+				io.WriteString(w.BodyWriter(), " world")
+			},
+			Expected: []byte("HTTP/1.1 200 OK\r\nContent-Length: 11\r\n\r\nHello world"),
+		},
+		{
+			Name: "Streamed body with trailers",
+			Handler: func(w *httpcore.HttpResponseWriter) {
+				w.SetTrailer("Checksum", "")
+				io.WriteString(w.BodyWriter(), "Hello")
+				w.SetTrailer("Checksum", "abc")
+			},
+			Expected: []byte("HTTP/1.1 200 OK\r\nTrailer: Checksum\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nHello\r\n0\r\nChecksum: abc\r\n\r\n"),
+		},
+		{
+			Name: "Repeated header lines",
+			Handler: func(w *httpcore.HttpResponseWriter) {
+				w.AddHeader("Set-Cookie", "a=1; Path=/")
+				w.SetHeader("Content-Type", "text/plain")
+				w.AddHeader("set-cookie", "b=2; HttpOnly")
+				w.AddHeader("Vary", "Accept")
+				w.SetHeader("Vary", "Accept-Encoding")
+			},
+			Expected: []byte("HTTP/1.1 200 OK\r\nSet-Cookie: a=1; Path=/\r\nSet-Cookie: b=2; HttpOnly\r\nContent-Type: text/plain\r\nVary: Accept-Encoding\r\nContent-Length: 0\r\n\r\n"),
+		},
+		{
+			Name: "Headers set after streaming started are ignored",
+			Handler: func(w *httpcore.HttpResponseWriter) {
+				w.SetStatus(httpcore.StatusCreated)
+				w.Flush()
+				w.SetStatus(httpcore.StatusOK)
+				w.SetHeader("Content-Type", "text/plain")
+			},
+			Expected: []byte("HTTP/1.1 201 Created\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var out bytes.Buffer
+			buffered := bufio.NewWriter(&out)
+			writer := httpcore.NewStreamingResponseWriter(httpcore.NewHttp1StreamWriter(buffered))
+			tc.Handler(&writer)
+			if err := writer.Finish(); err != nil {
+				t.Fatalf("unexpected error %v", err)
 			}
 
-			if !bytes.Equal(tc.Expected, writer.ToResponseByte()) {
-				t.Errorf("Actual: %q", writer.ToResponseByte())
-				t.Fail()
+			if !bytes.Equal(tc.Expected, out.Bytes()) {
+				t.Errorf("Actual: %q", out.Bytes())
 			}
 		})
 	}
@@ -0,0 +1,189 @@
+package httpcore
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClientGone   = errors.New("client closed the connection")
+	ErrStreamClosed = errors.New("event stream is closed")
+	ErrInvalidEvent = errors.New("event field contains a line break")
+)
+
+// Event is a single Server-Sent Event. Data may span several lines, empty
+// fields are left out.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// EventStream writes Server-Sent Events (text/event-stream), each event is
+// flushed to the client as soon as it is sent. It is safe to send from
+// several goroutines.
+type EventStream struct {
+	w           *HttpResponseWriter
+	lastEventID string
+
+	mu     sync.Mutex
+	err    error
+	done   chan struct{}
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// EventStream starts an event stream answering r, the request body is
+// discarded. Handlers should loop until Done is closed and Close the stream
+// before returning.
+func (w *HttpResponseWriter) EventStream(r Request) (*EventStream, error) {
+	if r.Body != nil {
+		r.Body.Close()
+	}
+
+	w.SetHeader("Content-Type", "text/event-stream")
+	w.SetHeader("Cache-Control", "no-cache")
+	gone := w.CloseNotify()
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	s := &EventStream{
+		w:           w,
+		lastEventID: r.Headers.Get("last-event-id"),
+		done:        make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+	if gone != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			select {
+			case <-gone:
+				s.stop(ErrClientGone)
+			case <-s.closed:
+			}
+		}()
+	}
+	return s, nil
+}
+
+// LastEventID is the id of the last event the client saw before it
+// reconnected, empty on the first connection.
+func (s *EventStream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed when the client disconnected, a write failed or the stream
+// was closed.
+func (s *EventStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err reports why Done was closed.
+func (s *EventStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *EventStream) Send(event Event) error {
+	if strings.ContainsAny(event.ID, "\r\n") || strings.ContainsAny(event.Event, "\r\n") {
+		return ErrInvalidEvent
+	}
+
+	var b strings.Builder
+	if event.ID != "" {
+		b.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Event != "" {
+		b.WriteString("event: " + event.Event + "\n")
+	}
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(strings.ReplaceAll(event.Data, "\r\n", "\n"), "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Comment sends a comment line, clients ignore it but it keeps proxies from
+// timing out an idle stream.
+func (s *EventStream) Comment(text string) error {
+	text = strings.ReplaceAll(text, "\n", " ")
+	return s.write(": " + strings.ReplaceAll(text, "\r", " ") + "\n\n")
+}
+
+// Heartbeat sends a comment every interval until the stream is done. A
+// failing heartbeat is how a silent disconnect gets noticed.
+func (s *EventStream) Heartbeat(interval time.Duration) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if s.Comment("heartbeat") != nil {
+					return
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the heartbeat and waits for it, the response is finished by
+// the server once the handler returns.
+func (s *EventStream) Close() {
+	s.stop(ErrStreamClosed)
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *EventStream) write(payload string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+
+	if _, err := s.w.BodyWriter().Write([]byte(payload)); err != nil {
+		s.stopLocked(err)
+		return err
+	}
+	if err := s.w.Flush(); err != nil {
+		s.stopLocked(err)
+		return err
+	}
+	return nil
+}
+
+func (s *EventStream) stop(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopLocked(err)
+}
+
+func (s *EventStream) stopLocked(err error) {
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	close(s.done)
+}
@@ -0,0 +1,160 @@
+package httpcore_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+)
+
+func TestEventStream(t *testing.T) {
+	var out bytes.Buffer
+	writer := bufio.NewWriter(&out)
+	response := httpcore.NewStreamingResponseWriter(httpcore.NewHttp1StreamWriter(writer))
+	request := httpcore.Request{Headers: httpcore.HeaderMap{"last-event-id": {"41"}}, Body: httpcore.NoBody}
+
+	stream, err := response.EventStream(request)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if stream.LastEventID() != "41" {
+		t.Errorf("expected Last-Event-ID 41, got %q", stream.LastEventID())
+	}
+
+	stream.Send(httpcore.Event{ID: "42", Event: "update", Data: "line one\nline two", Retry: 3 * time.Second})
+	stream.Send(httpcore.Event{Data: "plain"})
+	stream.Comment("heartbeat")
+	if err := stream.Send(httpcore.Event{Event: "bad\nname"}); !errors.Is(err, httpcore.ErrInvalidEvent) {
+		t.Errorf("expected ErrInvalidEvent, got %v", err)
+	}
+	stream.Close()
+	response.Finish()
+
+	expected := "HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nCache-Control: no-cache\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"40\r\nid: 42\nevent: update\nretry: 3000\ndata: line one\ndata: line two\n\n\r\n" +
+		"d\r\ndata: plain\n\n\r\n" +
+		"d\r\n: heartbeat\n\n\r\n" +
+		"0\r\n\r\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+	select {
+	case <-stream.Done():
+	default:
+		t.Errorf("expected Done to be closed after Close")
+	}
+}
+
+func TestEventStreamClientGone(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer server.Close()
+
+	client.Write([]byte("GET /events HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	reader := bufio.NewReader(server)
+	request, err := httpcore.ParseRequest(reader)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	stream := httpcore.NewHttp1StreamWriter(bufio.NewWriter(server)).WithConn(server, reader)
+	response := httpcore.NewStreamingResponseWriter(stream)
+
+	events, err := response.EventStream(*request)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer events.Close()
+	events.Heartbeat(10 * time.Millisecond)
+
+	head, _ := bufio.NewReader(client).ReadString('\n')
+	if !strings.HasPrefix(head, "HTTP/1.1 200") {
+		t.Errorf("unexpected status line %q", head)
+	}
+	client.Close()
+
+	select {
+	case <-events.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("disconnect was not noticed")
+	}
+	if events.Send(httpcore.Event{Data: "late"}) == nil {
+		t.Errorf("expected Send to fail after the client left")
+	}
+	if stream.Reusable() {
+		t.Errorf("expected the connection not to be reused")
+	}
+	if value, _ := response.GetHeader("Connection"); value != "close" {
+		t.Errorf("expected Connection: close, got %q", value)
+	}
+}
+
+func TestCloseNotifyWithBody(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Body     string
+		Watching bool
+	}{
+		{Name: "Unread body is discarded first", Body: "hello", Watching: true},
+		{Name: "Body too large to discard", Body: strings.Repeat("x", 1<<20)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+			go client.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: " + strconv.Itoa(len(tc.Body)) + "\r\n\r\n" + tc.Body))
+
+			reader := bufio.NewReader(server)
+			request, err := httpcore.ParseRequest(reader)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			stream := httpcore.NewHttp1StreamWriter(bufio.NewWriter(server)).WithConn(server, reader).WithBody(request.Body)
+			response := httpcore.NewStreamingResponseWriter(stream)
+
+			// the handler did not read the body
+			gone := response.CloseNotify()
+			if stream.Reusable() {
+				t.Errorf("expected the connection not to be reused")
+			}
+			if value, _ := response.GetHeader("Connection"); value != "close" {
+				t.Errorf("expected Connection: close, got %q", value)
+			}
+			if (gone != nil) != tc.Watching {
+				t.Fatalf("expected watching %v, got %v", tc.Watching, gone != nil)
+			}
+			if gone == nil {
+				return
+			}
+
+			select {
+			case <-gone:
+				t.Fatalf("body bytes taken for a disconnect")
+			case <-time.After(50 * time.Millisecond):
+			}
+			client.Close()
+			select {
+			case <-gone:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("disconnect was not noticed")
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package httpcore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+var ErrNotHijackable = errors.New("connection cannot be taken over")
+
+// StreamWriter puts a response on the wire. WriteHead is called exactly once
+// before any WriteData, End is called once after the last one.
+type StreamWriter interface {
+	WriteHead(status HttpStatus, message string, headers []HeaderField) error
+	WriteData(p []byte) error
+	Flush() error
+	End(trailers []HeaderField) error
+}
+
+// Hijacker is implemented by streams whose connection can be handed over to
+// a handler, for protocols like WebSocket that replace HTTP on it.
+type Hijacker interface {
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+// CloseNotifier is implemented by streams that can tell when the client went
+// away while the response is still being produced.
+type CloseNotifier interface {
+	CloseNotify() <-chan struct{}
+}
+
+// Http1StreamWriter frames a response for HTTP/1.1, switching to chunked
+// transfer coding when the handler did not set a Content-Length.
+type Http1StreamWriter struct {
+	writer  *bufio.Writer
+	chunked bool
+	noBody  bool
+	head    bool
+
+	conn   net.Conn
+	reader *bufio.Reader
+	body   io.Closer
+
+	watchOnce sync.Once
+	watched   bool
+	gone      chan struct{}
+}
+
+func NewHttp1StreamWriter(writer *bufio.Writer) *Http1StreamWriter {
+	return &Http1StreamWriter{writer: writer}
+}
+
+// WithConn allows handlers to hijack conn, reader has to be the reader the
+// request was parsed from so no buffered bytes get lost.
+func (s *Http1StreamWriter) WithConn(conn net.Conn, reader *bufio.Reader) *Http1StreamWriter {
+	s.conn = conn
+	s.reader = reader
+	return s
+}
+
+// WithBody sets the request body read from the reader of WithConn, which
+// CloseNotify discards before it watches the reader.
+func (s *Http1StreamWriter) WithBody(body io.Closer) *Http1StreamWriter {
+	s.body = body
+	return s
+}
+
+// WithoutBody marks the response to a HEAD request, the headers are sent as
+// they are for GET but the body is dropped.
+func (s *Http1StreamWriter) WithoutBody() *Http1StreamWriter {
+	s.head = true
+	return s
+}
+
+func (s *Http1StreamWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if s.conn == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	return s.conn, bufio.NewReadWriter(s.reader, s.writer), nil
+}
+
+// CloseNotify watches the connection for the client closing it. What is
+// left of the request body is discarded first, so the watcher is the only
+// reader left and does not take body bytes for a request. It returns nil
+// when the body is too large to discard. The connection is not reused for
+// further requests either way.
+func (s *Http1StreamWriter) CloseNotify() <-chan struct{} {
+	if s.conn == nil {
+		return nil
+	}
+	s.watchOnce.Do(func() {
+		s.watched = true
+		if s.body != nil && s.body.Close() != nil {
+			return
+		}
+		s.gone = make(chan struct{})
+		go func() {
+			// a pipelined request is not a disconnect, only an error is
+			if _, err := s.reader.Peek(1); err != nil {
+				close(s.gone)
+			}
+		}()
+	})
+	return s.gone
+}
+
+// Reusable reports whether another request can be read from the connection
+// after this response.
+func (s *Http1StreamWriter) Reusable() bool {
+	return !s.watched
+}
+
+func (s *Http1StreamWriter) WriteHead(status HttpStatus, message string, headers []HeaderField) error {
+	s.noBody = !bodyAllowed(status)
+	hasLength := false
+	for _, field := range headers {
+		if strings.EqualFold(field.Key, "Content-Length") {
+			hasLength = true
+		}
+		if strings.EqualFold(field.Key, "Transfer-Encoding") {
+			s.chunked = true
+		}
+	}
+	if !hasLength && !s.chunked && !s.noBody {
+		s.chunked = true
+		headers = append(headers, HeaderField{Key: "Transfer-Encoding", Value: "chunked"})
+	}
+
+	if _, err := fmt.Fprintf(s.writer, "HTTP/1.1 %d %s\r\n", status, message); err != nil {
+		return err
+	}
+	if err := writeFields(s.writer, headers); err != nil {
+		return err
+	}
+	_, err := s.writer.WriteString("\r\n")
+	return err
+}
+
+func (s *Http1StreamWriter) WriteData(p []byte) error {
+	if s.noBody || s.head || len(p) == 0 {
+		return nil
+	}
+	if !s.chunked {
+		_, err := s.writer.Write(p)
+		return err
+	}
+
+	if _, err := fmt.Fprintf(s.writer, "%x\r\n", len(p)); err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(p); err != nil {
+		return err
+	}
+	_, err := s.writer.WriteString("\r\n")
+	return err
+}
+
+func (s *Http1StreamWriter) Flush() error {
+	return s.writer.Flush()
+}
+
+func (s *Http1StreamWriter) End(trailers []HeaderField) error {
+	if s.chunked && !s.noBody && !s.head {
+		if _, err := s.writer.WriteString("0\r\n"); err != nil {
+			return err
+		}
+		if err := writeFields(s.writer, trailers); err != nil {
+			return err
+		}
+		if _, err := s.writer.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return s.writer.Flush()
+}
+
+func writeFields(writer *bufio.Writer, fields []HeaderField) error {
+	for _, field := range fields {
+		if err := ValidHeaderField(field.Key, field.Value); err != nil {
+			return err
+		}
+	}
+	for _, field := range fields {
+		if _, err := fmt.Fprintf(writer, "%s: %s\r\n", field.Key, field.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package httpcore
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/common"
+)
+
+var ErrInvalidTarget = errors.New("invalid request target")
+
+// requestTarget is the request target split into its parts (RFC 9112
+// section 3.2). authority is only set for the absolute and authority forms.
+type requestTarget struct {
+	path      string
+	rawPath   string
+	rawQuery  string
+	query     url.Values
+	authority string
+}
+
+// parseTarget reads the four forms of a request target: "/path?query",
+// "http://host/path?query", "host:port" for CONNECT and "*" for a server
+// wide OPTIONS.
+func parseTarget(method common.Method, target string) (requestTarget, error) {
+	if target == "" || strings.ContainsFunc(target, isInvalidTargetRune) {
+		return requestTarget{}, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+	}
+
+	switch {
+	case target == "*":
+		if method != common.OPTIONS {
+			return requestTarget{}, fmt.Errorf("%w: * is only allowed for OPTIONS", ErrInvalidTarget)
+		}
+		return requestTarget{path: "*", rawPath: "*"}, nil
+	case method == "CONNECT":
+		if strings.ContainsAny(target, "/?@") {
+			return requestTarget{}, fmt.Errorf("%w: %q is not an authority", ErrInvalidTarget, target)
+		}
+		return requestTarget{authority: target}, nil
+	}
+
+	var parsed requestTarget
+	if !strings.HasPrefix(target, "/") {
+		scheme, rest, found := strings.Cut(target, "://")
+		scheme = strings.ToLower(scheme)
+		if !found || scheme != "http" && scheme != "https" {
+			return requestTarget{}, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+		}
+		end := strings.IndexAny(rest, "/?")
+		if end < 0 {
+			end = len(rest)
+		}
+		parsed.authority, target = rest[:end], rest[end:]
+		if parsed.authority == "" || strings.Contains(parsed.authority, "@") {
+			return requestTarget{}, fmt.Errorf("%w: bad authority %q", ErrInvalidTarget, parsed.authority)
+		}
+		if !strings.HasPrefix(target, "/") {
+			target = "/" + target
+		}
+	}
+
+	rawPath, rawQuery, hasQuery := strings.Cut(target, "?")
+	rawPath, err := normalizePath(rawPath)
+	if err != nil {
+		return requestTarget{}, err
+	}
+	parsed.rawPath = rawPath
+	// cannot fail, normalizePath checked every escape
+	parsed.path, _ = url.PathUnescape(rawPath)
+
+	if hasQuery {
+		parsed.rawQuery = rawQuery
+		parsed.query = parseQuery(rawQuery)
+	}
+	return parsed, nil
+}
+
+// isInvalidTargetRune reports control characters and the fragment
+// delimiter, neither of which may appear in a request target.
+func isInvalidTargetRune(r rune) bool {
+	return r < 0x20 || r == 0x7f || r == '#'
+}
+
+// normalizePath puts an escaped path into the normal form of RFC 3986
+// section 6.2.2: escaped unreserved characters are decoded, the remaining
+// escapes are uppercased and dot segments are removed. Runs of slashes are
+// merged as well, so "/a//b" and "/a/b" name the same resource.
+func normalizePath(path string) (string, error) {
+	var normalized strings.Builder
+	for idx := 0; idx < len(path); idx++ {
+		if path[idx] != '%' {
+			normalized.WriteByte(path[idx])
+			continue
+		}
+		if idx+2 >= len(path) || !isHex(path[idx+1]) || !isHex(path[idx+2]) {
+			return "", fmt.Errorf("%w: bad escape in %q", ErrInvalidTarget, path)
+		}
+		decoded := unhex(path[idx+1])<<4 | unhex(path[idx+2])
+		if isUnreserved(decoded) {
+			normalized.WriteByte(decoded)
+		} else {
+			normalized.WriteString(strings.ToUpper(path[idx : idx+3]))
+		}
+		idx += 2
+	}
+	return removeDotSegments(normalized.String()), nil
+}
+
+// removeDotSegments resolves "." and ".." segments (RFC 3986 section
+// 5.2.4), ".." never climbs above the root. A trailing slash is kept.
+func removeDotSegments(path string) string {
+	segments := strings.Split(path, "/")
+	output := make([]string, 0, len(segments))
+	for _, segment := range segments[1:] {
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(output) > 0 {
+				output = output[:len(output)-1]
+			}
+		default:
+			output = append(output, segment)
+		}
+	}
+
+	result := "/" + strings.Join(output, "/")
+	last := segments[len(segments)-1]
+	if len(output) > 0 && (last == "" || last == "." || last == "..") {
+		result += "/"
+	}
+	return result
+}
+
+// parseQuery decodes a query string, "+" stands for a space and repeated
+// keys keep every value in order. A value with a bad escape is kept as it
+// was sent.
+func parseQuery(rawQuery string) url.Values {
+	query := make(url.Values)
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		query.Add(unescapeQuery(key), unescapeQuery(value))
+	}
+	return query
+}
+
+func unescapeQuery(value string) string {
+	if unescaped, err := url.QueryUnescape(value); err == nil {
+		return unescaped
+	}
+	return value
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
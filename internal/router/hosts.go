@@ -0,0 +1,100 @@
+package router
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/common"
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+)
+
+// Resolver finds the handler for a request, nil when nothing matches.
+type Resolver interface {
+	Resolve(host string, method common.Method, path string) (httpcore.HandlerFunc, map[string]string)
+}
+
+// Resolve ignores the host, a Router serves every host it is given.
+func (r Router) Resolve(host string, method common.Method, path string) (httpcore.HandlerFunc, map[string]string) {
+	return r.GetHandler(method, path)
+}
+
+// HostRouter dispatches on the Host header before the path. Each host has
+// its own router, requests for unknown hosts go to the fallback.
+type HostRouter struct {
+	mu        sync.RWMutex
+	exact     map[string]IRouter
+	wildcards map[string]IRouter
+	fallback  Resolver
+}
+
+// NewHostRouter returns a host router sending unknown hosts to fallback,
+// which may be nil to answer them with 404. Any router or another
+// HostRouter can be the fallback.
+func NewHostRouter(fallback Resolver) *HostRouter {
+	return &HostRouter{
+		exact:     make(map[string]IRouter),
+		wildcards: make(map[string]IRouter),
+		fallback:  fallback,
+	}
+}
+
+// Host returns the router of pattern, created on first use. A pattern is
+// an exact host name or "*.example.test" matching every subdomain of
+// example.test but not example.test itself.
+func (h *HostRouter) Host(pattern string) IRouter {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pattern = normalizeHost(pattern)
+	routers := h.exact
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		pattern, routers = suffix, h.wildcards
+	}
+
+	if existing, exists := routers[pattern]; exists {
+		return existing
+	}
+	router := NewRouter()
+	routers[pattern] = router
+	return router
+}
+
+func (h *HostRouter) Resolve(host string, method common.Method, path string) (httpcore.HandlerFunc, map[string]string) {
+	if router := h.route(host); router != nil {
+		return router.Resolve(host, method, path)
+	}
+	return nil, make(map[string]string)
+}
+
+// route picks the router for host: an exact match, then the wildcard with
+// the longest suffix, then the fallback.
+func (h *HostRouter) route(host string) Resolver {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	host = normalizeHost(host)
+	if router, exists := h.exact[host]; exists {
+		return router
+	}
+	for suffix := host; ; {
+		_, parent, found := strings.Cut(suffix, ".")
+		if !found {
+			break
+		}
+		if router, exists := h.wildcards[parent]; exists {
+			return router
+		}
+		suffix = parent
+	}
+	return h.fallback
+}
+
+// normalizeHost drops the port and a trailing dot and lowercases host.
+func normalizeHost(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	return strings.ToLower(host)
+}
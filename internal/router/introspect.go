@@ -0,0 +1,89 @@
+package router
+
+import (
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/common"
+)
+
+// RouteInfo describes a registered route. Middleware lists the global
+// middleware first, in the order the request passes through it.
+type RouteInfo struct {
+	Name       string        `json:"name,omitempty"`
+	Method     common.Method `json:"method"`
+	Pattern    string        `json:"pattern"`
+	Handlers   []string      `json:"handlers"`
+	Middleware []string      `json:"middleware"`
+}
+
+// Routes walks the trie and returns every route sorted by pattern and
+// method. A pattern with optional segments is listed once.
+func (r *Router) Routes() []RouteInfo {
+	r.tree.mu.RLock()
+	defer r.tree.mu.RUnlock()
+
+	global := funcNames(r.tree.middleware)
+	routes := make([]RouteInfo, 0)
+	seen := make(map[string]bool)
+	names := make(map[string]string, len(r.tree.names))
+	for name, named := range r.tree.names {
+		names[string(named.method)+" "+named.pattern] = name
+	}
+
+	for method, methodRoot := range r.tree.root.children {
+		methodRoot.walk(func(route *Route) {
+			key := method + " " + route.pattern
+			if seen[key] {
+				return
+			}
+			seen[key] = true
+			routes = append(routes, RouteInfo{
+				Name:       names[key],
+				Method:     common.Method(method),
+				Pattern:    route.pattern,
+				Handlers:   funcNames(route.handlers),
+				Middleware: append(slices.Clone(global), funcNames(route.middleware)...),
+			})
+		})
+	}
+
+	slices.SortFunc(routes, func(a, b RouteInfo) int {
+		if order := strings.Compare(a.Pattern, b.Pattern); order != 0 {
+			return order
+		}
+		return compareMethods(a.Method, b.Method)
+	})
+	return routes
+}
+
+func (route *Route) walk(visit func(route *Route)) {
+	if len(route.handlers) > 0 {
+		visit(route)
+	}
+	for _, child := range route.children {
+		child.walk(visit)
+	}
+	for _, child := range append(route.constrained, route.params...) {
+		child.walk(visit)
+	}
+	if route.wildcard != nil {
+		route.wildcard.walk(visit)
+	}
+}
+
+// funcNames returns the names of functions as the runtime knows them,
+// closures show up as "package.Outer.funcN".
+func funcNames[F any](funcs []F) []string {
+	names := make([]string, 0, len(funcs))
+	for _, fn := range funcs {
+		name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+		if idx := strings.LastIndex(name, "/"); idx >= 0 {
+			name = name[idx+1:]
+		}
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,218 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+)
+
+// named constraints usable as :name{int}
+var paramTypes = map[string]string{
+	"int":   `-?[0-9]+`,
+	"uint":  `[0-9]+`,
+	"alpha": `[A-Za-z]+`,
+	"alnum": `[A-Za-z0-9]+`,
+	"hex":   `[0-9A-Fa-f]+`,
+	"uuid":  `[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}`,
+}
+
+type segmentKind int
+
+const (
+	staticSegment segmentKind = iota
+	paramSegment
+	wildcardSegment
+)
+
+// Route is a node of the routing trie, one per path segment. Children are
+// tried static first, then constrained params, plain params and the
+// catch-all last.
+type Route struct {
+	children    map[string]*Route
+	constrained []*Route
+	params      []*Route
+	wildcard    *Route
+
+	// how the node matches its segment, key is the segment as registered
+	kind       segmentKind
+	key        string
+	name       string
+	constraint *regexp.Regexp
+
+	// pattern is the full path the route was registered with
+	pattern    string
+	handlers   []httpcore.HandlerFunc
+	middleware []httpcore.Middleware
+}
+
+func NewRoute() *Route {
+	return &Route{children: make(map[string]*Route)}
+}
+
+// parseSegment reads a registered segment: "name" is static, ":name" a
+// param, ":name{[0-9]+}" or ":name{int}" a constrained param and "*name" a
+// catch-all taking the rest of the path.
+func parseSegment(segment string) *Route {
+	node := NewRoute()
+	node.key = segment
+
+	switch {
+	case strings.HasPrefix(segment, "*"):
+		node.kind = wildcardSegment
+		node.name = segment[1:]
+	case strings.HasPrefix(segment, ":"):
+		node.kind = paramSegment
+		node.name = segment[1:]
+		if open := strings.IndexByte(segment, '{'); open > 0 {
+			if !strings.HasSuffix(segment, "}") {
+				panic(fmt.Sprintf("router: unterminated constraint in segment %q", segment))
+			}
+			node.name = segment[1:open]
+			pattern := segment[open+1 : len(segment)-1]
+			if named, ok := paramTypes[pattern]; ok {
+				pattern = named
+			}
+			node.constraint = regexp.MustCompile("^(?:" + pattern + ")$")
+		}
+	default:
+		node.kind = staticSegment
+	}
+	return node
+}
+
+// child returns the node for segment below route, creating it if needed.
+func (route *Route) child(segment string) *Route {
+	if existing := route.find(segment); existing != nil {
+		return existing
+	}
+
+	node := parseSegment(segment)
+	switch {
+	case node.kind == wildcardSegment:
+		// a second catch-all would take every path of the first one
+		if route.wildcard != nil {
+			panic(fmt.Sprintf("router: catch-all %q conflicts with %q", segment, route.wildcard.key))
+		}
+		route.wildcard = node
+	case node.constraint != nil:
+		route.constrained = append(route.constrained, node)
+	case node.kind == paramSegment:
+		route.params = append(route.params, node)
+	default:
+		route.children[segment] = node
+	}
+	return node
+}
+
+func (route *Route) find(segment string) *Route {
+	if child, exists := route.children[segment]; exists {
+		return child
+	}
+	if route.wildcard != nil && route.wildcard.key == segment {
+		return route.wildcard
+	}
+	for _, child := range append(route.constrained, route.params...) {
+		if child.key == segment {
+			return child
+		}
+	}
+	return nil
+}
+
+// match walks segments depth first, backtracking to the next candidate when
+// a more specific child leads nowhere. Params of the matched route are
+// added to params.
+func (route *Route) match(segments []string, params map[string]string) *Route {
+	if len(segments) == 0 {
+		if len(route.handlers) > 0 {
+			return route
+		}
+		return nil
+	}
+	segment, rest := segments[0], segments[1:]
+
+	if child, exists := route.children[segment]; exists {
+		if found := child.match(rest, params); found != nil {
+			return found
+		}
+	}
+	for _, candidates := range [][]*Route{route.constrained, route.params} {
+		for _, child := range candidates {
+			// a param is one segment, an encoded "/" would let a value
+			// such as "..%2Fname" step out of it
+			if segment == "" || strings.Contains(segment, "/") || child.constraint != nil && !child.constraint.MatchString(segment) {
+				continue
+			}
+			if found := child.match(rest, params); found != nil {
+				params[child.name] = segment
+				return found
+			}
+		}
+	}
+	if route.wildcard != nil && len(route.wildcard.handlers) > 0 && strings.Join(segments, "") != "" {
+		params[route.wildcard.name] = strings.Join(segments, "/")
+		return route.wildcard
+	}
+	return nil
+}
+
+// prune drops the children left without routes below them and reports
+// whether route itself is empty.
+func (route *Route) prune() bool {
+	for key, child := range route.children {
+		if child.prune() {
+			delete(route.children, key)
+		}
+	}
+	route.constrained = slices.DeleteFunc(route.constrained, (*Route).prune)
+	route.params = slices.DeleteFunc(route.params, (*Route).prune)
+	if route.wildcard != nil && route.wildcard.prune() {
+		route.wildcard = nil
+	}
+
+	return len(route.handlers) == 0 && len(route.children) == 0 && len(route.constrained) == 0 &&
+		len(route.params) == 0 && route.wildcard == nil
+}
+
+// expandOptional turns a pattern with optional segments, marked by a
+// trailing "?", into every pattern it stands for.
+func expandOptional(segments []string) [][]string {
+	patterns := [][]string{nil}
+	for _, segment := range segments {
+		optional := strings.HasSuffix(segment, "?")
+		segment = strings.TrimSuffix(segment, "?")
+
+		expanded := make([][]string, 0, len(patterns)*2)
+		for _, pattern := range patterns {
+			expanded = append(expanded, append(pattern[:len(pattern):len(pattern)], segment))
+			if optional {
+				expanded = append(expanded, pattern)
+			}
+		}
+		patterns = expanded
+	}
+	return patterns
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimRight(path, "/"), "/")
+}
+
+// pathSegments splits an escaped request path and decodes every segment on
+// its own, an encoded "/" stays part of its segment and keeps it from
+// matching a param. It fails on a bad escape.
+func pathSegments(path string) ([]string, bool) {
+	segments := splitPath(path)
+	for idx, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, false
+		}
+		segments[idx] = decoded
+	}
+	return segments, true
+}
@@ -1,41 +1,101 @@
 package router
 
 import (
+	"fmt"
+	"net/url"
+	"slices"
 	"strings"
-	"unicode"
+	"sync"
 
 	"github.com/codecrafters-io/http-server-starter-go/internal/common"
 	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+	"github.com/codecrafters-io/http-server-starter-go/internal/websocket"
 )
 
-type Route struct {
-	children   map[string]*Route
-	middleware []httpcore.HandlerFunc
-	hasParam   bool
-}
-
-func NewRoute() *Route {
-	return &Route{children: make(map[string]*Route), middleware: make([]httpcore.HandlerFunc, 0), hasParam: false}
-}
-
 type ReadOnlyRouter interface {
-	GetHandlers(method common.Method, path string) ([]httpcore.HandlerFunc, map[string]string)
+	// GetHandler returns the route handler wrapped in its middleware, nil
+	// when no route matches the path. A path registered for other methods
+	// gets the 405 or the automatic OPTIONS handler. path is escaped, as in
+	// Request.RawPath, params are decoded.
+	GetHandler(method common.Method, path string) (httpcore.HandlerFunc, map[string]string)
 	CopyPath(router IRouter)
 }
 
 type IRouter interface {
+	Resolver
+
 	Get(path string, handlers ...httpcore.HandlerFunc)
 	Post(path string, handlers ...httpcore.HandlerFunc)
 	Put(path string, handlers ...httpcore.HandlerFunc)
 	Patch(path string, handlers ...httpcore.HandlerFunc)
 	Head(path string, handlers ...httpcore.HandlerFunc)
 	Delete(path string, handlers ...httpcore.HandlerFunc)
+	Options(path string, handlers ...httpcore.HandlerFunc)
+	Handle(method common.Method, path string, handlers ...httpcore.HandlerFunc)
+	WebSocket(path string, upgrader websocket.Upgrader, handler websocket.Handler)
+
+	// Use adds middleware. On the router returned by NewRouter it applies to
+	// every route, on one returned by With to the routes registered after.
+	Use(middlewares ...httpcore.Middleware)
+	// After runs hooks once the route handler returned
+	After(hooks ...httpcore.HandlerFunc)
+	// With returns a router registering routes on the same tree with
+	// middlewares added to them
+	With(middlewares ...httpcore.Middleware) IRouter
+	// Group returns a router whose routes get prefix and middlewares, groups
+	// nest to any depth
+	Group(prefix string, middlewares ...httpcore.Middleware) IRouter
+
+	// MethodNotAllowed replaces the handler answering a known path requested
+	// with an unregistered method, the Allow header is set before it runs.
+	// nil restores the default 405.
+	MethodNotAllowed(handler httpcore.HandlerFunc)
+	// AutoOptions replaces the answer to OPTIONS on paths without an OPTIONS
+	// route, the Allow header is set before it runs. nil turns it off.
+	AutoOptions(handler httpcore.HandlerFunc)
+	// NotFound sets the handler for paths no route matches, by default the
+	// server answers 404
+	NotFound(handler httpcore.HandlerFunc)
+
+	// Routes lists the registered routes
+	Routes() []RouteInfo
+	// Remove unregisters the route of method and path, path is the pattern
+	// it was registered with. It reports whether the route existed.
+	Remove(method common.Method, path string) bool
+
+	// Named registers a route like Handle under name
+	Named(name string, method common.Method, path string, handlers ...httpcore.HandlerFunc)
+	// URL builds the path of a named route from its params and query
+	URL(name string, params map[string]string, query url.Values) (string, error)
+}
+
+// tree is shared by a router and every router derived from it with With
+// or Group, and by the server through CopyPath. mu lets routes change while
+// requests are served.
+type tree struct {
+	mu sync.RWMutex
+	// one trie per method
+	root             *Route
+	middleware       []httpcore.Middleware
+	methodNotAllowed httpcore.HandlerFunc
+	autoOptions      httpcore.HandlerFunc
+	notFound         httpcore.HandlerFunc
+	names            map[string]*namedRoute
 }
 
 type Router struct {
-	root *Route
+	tree *tree
+	// scoped is false for the router of NewRouter, its middleware applies to
+	// every route
+	scoped     bool
+	prefix     string
+	middleware []httpcore.Middleware
 }
 
+// methodOrder is the order methods are listed in the Allow header, others
+// follow alphabetically
+var methodOrder = []common.Method{common.GET, common.HEAD, common.POST, common.PUT, common.PATCH, common.DELETE, common.OPTIONS}
+
 func (r *Router) Get(path string, handlers ...httpcore.HandlerFunc) {
 	r.addRoute(common.GET, path, handlers...)
 }
@@ -60,65 +120,252 @@ func (r *Router) Delete(path string, handlers ...httpcore.HandlerFunc) {
 	r.addRoute(common.DELETE, path, handlers...)
 }
 
+func (r *Router) Options(path string, handlers ...httpcore.HandlerFunc) {
+	r.addRoute(common.OPTIONS, path, handlers...)
+}
+
+func (r *Router) Handle(method common.Method, path string, handlers ...httpcore.HandlerFunc) {
+	r.addRoute(method, path, handlers...)
+}
+
+// WebSocket registers a GET route that upgrades the connection and hands it
+// to handler.
+func (r *Router) WebSocket(path string, upgrader websocket.Upgrader, handler websocket.Handler) {
+	r.addRoute(common.GET, path, upgrader.HandlerFunc(handler))
+}
+
 func NewRouter() IRouter {
 	return &Router{
-		root: NewRoute(),
+		tree: &tree{
+			root:             NewRoute(),
+			methodNotAllowed: methodNotAllowed,
+			autoOptions:      autoOptions,
+			names:            make(map[string]*namedRoute),
+		},
 	}
 }
 
 func (r *Router) CopyPath(router IRouter) {
-	r.root = router.(*Router).root
-}
-
-func (r Router) GetHandlers(method common.Method, path string) ([]httpcore.HandlerFunc, map[string]string) {
-	routeSegments := strings.Split(strings.TrimRightFunc(strings.ReplaceAll(path, "/", " "), unicode.IsSpace), " ")
-	routeSegments = append([]string{string(method)}, routeSegments...)
-
-	handlers, pathParam := make([]httpcore.HandlerFunc, 0), make(map[string]string)
-	current := r.root
-	for _, segment := range routeSegments {
-		child, exists := current.children[segment]
-		if !exists {
-
-			for key, value := range current.children {
-				if value.hasParam {
-					child = value
-					pathParam[strings.ReplaceAll(key, ":", "")] = segment
-					exists = true
-					break
-				}
-			}
-			// in case no path param present
-			if !exists {
-				return nil, pathParam
-			}
+	r.tree = router.(*Router).tree
+}
+
+func (r *Router) Use(middlewares ...httpcore.Middleware) {
+	if r.scoped {
+		r.middleware = append(r.middleware, middlewares...)
+		return
+	}
+	r.tree.mu.Lock()
+	defer r.tree.mu.Unlock()
+	r.tree.middleware = append(r.tree.middleware, middlewares...)
+}
+
+func (r *Router) After(hooks ...httpcore.HandlerFunc) {
+	for _, hook := range hooks {
+		r.Use(httpcore.After(hook))
+	}
+}
+
+func (r *Router) With(middlewares ...httpcore.Middleware) IRouter {
+	return &Router{
+		tree:       r.tree,
+		scoped:     true,
+		prefix:     r.prefix,
+		middleware: append(slices.Clone(r.middleware), middlewares...),
+	}
+}
+
+func (r *Router) Group(prefix string, middlewares ...httpcore.Middleware) IRouter {
+	group := r.With(middlewares...).(*Router)
+	group.prefix = joinPath(r.prefix, "/"+strings.TrimLeft(prefix, "/"))
+	return group
+}
+
+func (r *Router) MethodNotAllowed(handler httpcore.HandlerFunc) {
+	if handler == nil {
+		handler = methodNotAllowed
+	}
+	r.tree.mu.Lock()
+	defer r.tree.mu.Unlock()
+	r.tree.methodNotAllowed = handler
+}
+
+func (r *Router) AutoOptions(handler httpcore.HandlerFunc) {
+	r.tree.mu.Lock()
+	defer r.tree.mu.Unlock()
+	r.tree.autoOptions = handler
+}
+
+func (r *Router) NotFound(handler httpcore.HandlerFunc) {
+	r.tree.mu.Lock()
+	defer r.tree.mu.Unlock()
+	r.tree.notFound = handler
+}
+
+func joinPath(prefix string, path string) string {
+	if prefix == "" {
+		return path
+	}
+	return strings.TrimRight(prefix, "/") + "/" + strings.TrimLeft(path, "/")
+}
 
+func (r Router) GetHandler(method common.Method, path string) (httpcore.HandlerFunc, map[string]string) {
+	r.tree.mu.RLock()
+	defer r.tree.mu.RUnlock()
+
+	pathParam := make(map[string]string)
+	current := r.tree.match(method, path, pathParam)
+	if current == nil && method == common.HEAD {
+		// HEAD runs the GET route, the server drops the body
+		current = r.tree.match(common.GET, path, pathParam)
+	}
+	if current != nil {
+		handler := httpcore.Wrap(httpcore.Chain(current.handlers...), current.middleware...)
+		return httpcore.Wrap(handler, r.tree.middleware...), pathParam
+	}
+
+	allowed := r.tree.allowedMethods(path)
+	if len(allowed) == 0 {
+		if r.tree.notFound != nil {
+			return httpcore.Wrap(r.tree.notFound, r.tree.middleware...), pathParam
+		}
+		return nil, pathParam
+	}
+
+	handler := r.tree.methodNotAllowed
+	if r.tree.autoOptions != nil {
+		allowed = append(allowed, common.OPTIONS)
+		if method == common.OPTIONS {
+			handler = r.tree.autoOptions
+		}
+	}
+	allow := joinMethods(allowed)
+
+	return httpcore.Wrap(func(req httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetHeader("Allow", allow)
+		handler(req, w)
+	}, r.tree.middleware...), pathParam
+}
+
+func (t *tree) match(method common.Method, path string, params map[string]string) *Route {
+	methodRoot, exists := t.root.children[string(method)]
+	if !exists {
+		return nil
+	}
+	segments, ok := pathSegments(path)
+	if !ok {
+		return nil
+	}
+	return methodRoot.match(segments, params)
+}
+
+// allowedMethods lists the methods with a route matching path, every
+// registered method for the "*" target of a server wide OPTIONS.
+func (t *tree) allowedMethods(path string) []common.Method {
+	allowed := make([]common.Method, 0)
+	for method := range t.root.children {
+		if path == "*" || t.match(common.Method(method), path, make(map[string]string)) != nil {
+			allowed = append(allowed, common.Method(method))
 		}
-		current = child
 	}
-	handlers = current.middleware
+	if slices.Contains(allowed, common.GET) {
+		allowed = append(allowed, common.HEAD)
+	}
+	return allowed
+}
 
-	return handlers, pathParam
+func compareMethods(a, b common.Method) int {
+	ia, ib := slices.Index(methodOrder, a), slices.Index(methodOrder, b)
+	switch {
+	case ia >= 0 && ib >= 0:
+		return ia - ib
+	case ia >= 0:
+		return -1
+	case ib >= 0:
+		return 1
+	}
+	return strings.Compare(string(a), string(b))
 }
 
+func joinMethods(methods []common.Method) string {
+	slices.SortFunc(methods, compareMethods)
+	methods = slices.Compact(methods)
+
+	names := make([]string, len(methods))
+	for idx, method := range methods {
+		names[idx] = string(method)
+	}
+	return strings.Join(names, ", ")
+}
+
+func methodNotAllowed(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+	w.SetStatus(httpcore.StatusMethodNotAllowed)
+}
+
+func autoOptions(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+	w.SetStatus(httpcore.StatusNoContent)
+}
+
+// addRoute registers handlers for path, replacing the route registered
+// with the same method and pattern. See parseSegment for the segment
+// syntax, optional segments end in "?" and register the route with and
+// without them.
 func (r *Router) addRoute(method common.Method, path string, handlers ...httpcore.HandlerFunc) {
-	routeSegments := strings.Split(strings.TrimRightFunc(strings.ReplaceAll(path, "/", " "), unicode.IsSpace), " ")
-	routeSegments = append([]string{string(method)}, routeSegments...)
-	current := r.root
-
-	for _, segment := range routeSegments {
-		// fmt.Println(segment)
-		child, exists := current.children[segment]
-		if !exists {
-			child = NewRoute()
-
-			if len(segment) > 0 && segment[0] == ':' {
-				child.hasParam = true
+	r.tree.mu.Lock()
+	defer r.tree.mu.Unlock()
+	r.addRouteLocked(method, path, handlers...)
+}
+
+func (r *Router) addRouteLocked(method common.Method, path string, handlers ...httpcore.HandlerFunc) {
+	path = joinPath(r.prefix, path)
+	for _, routeSegments := range expandOptional(splitPath(path)) {
+		current := r.tree.root.child(string(method))
+		for idx, segment := range routeSegments {
+			current = current.child(segment)
+			if current.kind == wildcardSegment && idx != len(routeSegments)-1 {
+				panic(fmt.Sprintf("router: catch-all %q has to be the last segment of %q", segment, path))
 			}
-			current.children[segment] = child
 		}
-		current = child
+
+		current.pattern = path
+		current.handlers = handlers
+		current.middleware = slices.Clone(r.middleware)
 	}
+}
 
-	current.middleware = handlers
+func (r *Router) Remove(method common.Method, path string) bool {
+	r.tree.mu.Lock()
+	defer r.tree.mu.Unlock()
+
+	path = joinPath(r.prefix, path)
+	methodRoot, exists := r.tree.root.children[string(method)]
+	if !exists {
+		return false
+	}
+
+	removed := false
+	for _, routeSegments := range expandOptional(splitPath(path)) {
+		current := methodRoot
+		for _, segment := range routeSegments {
+			if current = current.find(segment); current == nil {
+				break
+			}
+		}
+		if current != nil && len(current.handlers) > 0 {
+			current.handlers, current.middleware, current.pattern = nil, nil, ""
+			removed = true
+		}
+	}
+	if !removed {
+		return false
+	}
+
+	if methodRoot.prune() {
+		delete(r.tree.root.children, string(method))
+	}
+	for name, named := range r.tree.names {
+		if named.method == method && named.pattern == path {
+			delete(r.tree.names, name)
+		}
+	}
+	return true
 }
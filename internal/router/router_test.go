@@ -0,0 +1,577 @@
+package router_test
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/common"
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+	"github.com/codecrafters-io/http-server-starter-go/internal/router"
+)
+
+// trace records the order middleware and handlers ran in
+func trace(name string, calls *[]string) httpcore.Middleware {
+	return func(next httpcore.HandlerFunc) httpcore.HandlerFunc {
+		return func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+			*calls = append(*calls, name+">")
+			next(r, w)
+			*calls = append(*calls, "<"+name)
+		}
+	}
+}
+
+func serve(t *testing.T, appRouter router.IRouter, method common.Method, path string) httpcore.HttpResponseWriter {
+	t.Helper()
+	handler, params := appRouter.(router.ReadOnlyRouter).GetHandler(method, path)
+	response := httpcore.NewHttpResponseWriter()
+	if handler == nil {
+		response.SetStatus(httpcore.StatusNotFound)
+		return response
+	}
+	handler(httpcore.Request{Method: method, Path: path, PathParams: params}, &response)
+	return response
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	appRouter := router.NewRouter()
+	appRouter.Use(trace("global", &calls))
+
+	handler := func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		calls = append(calls, "handler")
+		w.Write([]byte("ok"))
+	}
+	appRouter.Get("/plain", handler)
+	appRouter.With(trace("route", &calls)).Get("/scoped", handler)
+	appRouter.With(func(next httpcore.HandlerFunc) httpcore.HandlerFunc {
+		return func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+			calls = append(calls, "deny")
+			w.SetStatus(httpcore.StatusUnauthorized)
+		}
+	}).Get("/private", handler)
+	// registered last, still applies to every route
+	appRouter.Use(trace("late", &calls))
+	appRouter.After(func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		calls = append(calls, "after")
+		w.SetHeader("X-After", "1")
+	})
+
+	testCases := []struct {
+		Name     string
+		Path     string
+		Status   httpcore.HttpStatus
+		Expected string
+	}{
+		{
+			Name:     "global middleware wraps the handler",
+			Path:     "/plain",
+			Expected: "global> late> handler after <late <global",
+		},
+		{
+			Name:     "route middleware runs inside the global one",
+			Path:     "/scoped",
+			Expected: "global> late> route> handler <route after <late <global",
+		},
+		{
+			Name:     "middleware short-circuits",
+			Path:     "/private",
+			Status:   httpcore.StatusUnauthorized,
+			Expected: "global> late> deny after <late <global",
+		},
+	}
+
+	for _, tc := range testCases {
+		calls = nil
+		response := serve(t, appRouter, common.GET, tc.Path)
+		if got := strings.Join(calls, " "); got != tc.Expected {
+			t.Errorf("[ %s ]expected %q, got %q", tc.Name, tc.Expected, got)
+		}
+		if value, _ := response.GetHeader("X-After"); value != "1" {
+			t.Errorf("[ %s ]expected the post hook to set X-After", tc.Name)
+		}
+		if tc.Status != 0 && !strings.Contains(string(response.ToResponseByte()), "401") {
+			t.Errorf("[ %s ]expected status %d", tc.Name, tc.Status)
+		}
+	}
+}
+
+func TestWithDoesNotLeak(t *testing.T) {
+	var calls []string
+	appRouter := router.NewRouter()
+	scoped := appRouter.With(trace("a", &calls))
+	scoped.Use(trace("b", &calls))
+	scoped.Get("/scoped", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {})
+	appRouter.Get("/plain", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {})
+
+	serve(t, appRouter, common.GET, "/scoped")
+	serve(t, appRouter, common.GET, "/plain")
+	if got := strings.Join(calls, " "); got != "a> b> <b <a" {
+		t.Errorf("expected scoped middleware on /scoped only, got %q", got)
+	}
+}
+
+func TestHandlerChain(t *testing.T) {
+	appRouter := router.NewRouter()
+	appRouter.Get("/chain",
+		func(r httpcore.Request, w *httpcore.HttpResponseWriter) { w.SetHeader("X-First", "1") },
+		func(r httpcore.Request, w *httpcore.HttpResponseWriter) { w.SetStatus(httpcore.StatusAccepted) },
+		func(r httpcore.Request, w *httpcore.HttpResponseWriter) { w.SetHeader("X-Third", "1") },
+	)
+
+	response := serve(t, appRouter, common.GET, "/chain")
+	if _, ok := response.GetHeader("X-First"); !ok {
+		t.Errorf("expected the first handler to run")
+	}
+	if _, ok := response.GetHeader("X-Third"); ok {
+		t.Errorf("expected the chain to stop once a status was set")
+	}
+
+	if handler, _ := appRouter.(router.ReadOnlyRouter).GetHandler(common.GET, "/missing"); handler != nil {
+		t.Errorf("expected no handler for an unknown path")
+	}
+}
+
+func TestGroup(t *testing.T) {
+	var calls []string
+	appRouter := router.NewRouter()
+	record := func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		calls = append(calls, "handler "+r.PathParams["id"])
+	}
+
+	api := appRouter.Group("/api/v1/", trace("api", &calls))
+	api.Get("/", record)
+	users := api.Group("users", trace("users", &calls))
+	users.Get("/:id", record)
+	users.With(trace("admin", &calls)).Delete("/:id", record)
+	api.Get("/health", record)
+
+	testCases := []struct {
+		Name     string
+		Method   common.Method
+		Path     string
+		Expected string
+	}{
+		{
+			Name:     "group root",
+			Method:   common.GET,
+			Path:     "/api/v1",
+			Expected: "api> handler  <api",
+		},
+		{
+			Name:     "nested group inherits prefix and middleware",
+			Method:   common.GET,
+			Path:     "/api/v1/users/7",
+			Expected: "api> users> handler 7 <users <api",
+		},
+		{
+			Name:     "route middleware inside a nested group",
+			Method:   common.DELETE,
+			Path:     "/api/v1/users/7",
+			Expected: "api> users> admin> handler 7 <admin <users <api",
+		},
+		{
+			Name:     "nested middleware does not leak to the parent",
+			Method:   common.GET,
+			Path:     "/api/v1/health",
+			Expected: "api> handler  <api",
+		},
+		{
+			Name:     "path outside the prefix",
+			Method:   common.GET,
+			Path:     "/users/7",
+			Expected: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		calls = nil
+		serve(t, appRouter, tc.Method, tc.Path)
+		if got := strings.Join(calls, " "); got != tc.Expected {
+			t.Errorf("[ %s ]expected %q, got %q", tc.Name, tc.Expected, got)
+		}
+	}
+}
+
+func TestRoutePatterns(t *testing.T) {
+	appRouter := router.NewRouter()
+	register := func(pattern string) {
+		appRouter.Get(pattern, func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+			w.SetHeader("X-Route", pattern)
+		})
+	}
+	register("/users/me")
+	register("/users/:id{int}")
+	register("/users/:name")
+	register("/users/*rest")
+	register("/users/:name/posts/:slug{[a-z-]+}")
+	register("/files/*path")
+	register("/archive/:year{uint}/:month{[0-9]{2}}?")
+	register("/orders/:id{uuid}")
+	register("/items/:id/edit?")
+	register("/uploads/:filename")
+
+	testCases := []struct {
+		Name     string
+		Path     string
+		Route    string
+		Expected map[string]string
+	}{
+		{Name: "static wins", Path: "/users/me", Route: "/users/me", Expected: map[string]string{}},
+		{Name: "constrained before plain", Path: "/users/42", Route: "/users/:id{int}", Expected: map[string]string{"id": "42"}},
+		{Name: "plain param", Path: "/users/bob", Route: "/users/:name", Expected: map[string]string{"name": "bob"}},
+		{Name: "backtrack to param", Path: "/users/me/posts/hello-world", Route: "/users/:name/posts/:slug{[a-z-]+}", Expected: map[string]string{"name": "me", "slug": "hello-world"}},
+		{Name: "wildcard after failed param", Path: "/users/bob/posts/Nope", Route: "/users/*rest", Expected: map[string]string{"rest": "bob/posts/Nope"}},
+		{Name: "nested catch-all", Path: "/files/a/b/c.txt", Route: "/files/*path", Expected: map[string]string{"path": "a/b/c.txt"}},
+		{Name: "catch-all needs a segment", Path: "/files", Route: ""},
+		{Name: "optional present", Path: "/archive/2024/05", Route: "/archive/:year{uint}/:month{[0-9]{2}}?", Expected: map[string]string{"year": "2024", "month": "05"}},
+		{Name: "optional absent", Path: "/archive/2024", Route: "/archive/:year{uint}/:month{[0-9]{2}}?", Expected: map[string]string{"year": "2024"}},
+		{Name: "constraint rejects", Path: "/archive/2024/5", Route: ""},
+		{Name: "typed uuid", Path: "/orders/123e4567-e89b-12d3-a456-426614174000", Route: "/orders/:id{uuid}", Expected: map[string]string{"id": "123e4567-e89b-12d3-a456-426614174000"}},
+		{Name: "typed uuid rejects", Path: "/orders/123", Route: ""},
+		{Name: "optional static segment", Path: "/items/9/edit", Route: "/items/:id/edit?", Expected: map[string]string{"id": "9"}},
+		{Name: "optional static segment absent", Path: "/items/9", Route: "/items/:id/edit?", Expected: map[string]string{"id": "9"}},
+		{Name: "decoded param", Path: "/users/J%C3%BCrgen%20M", Route: "/users/:name", Expected: map[string]string{"name": "Jürgen M"}},
+		{Name: "encoded slash is no param", Path: "/users/a%2Fb", Route: "/users/*rest", Expected: map[string]string{"rest": "a/b"}},
+		{Name: "encoded slash cannot climb up", Path: "/uploads/..%2Fescaped.txt", Route: ""},
+		{Name: "decoded static segment", Path: "/users/%6De", Route: "/users/me", Expected: map[string]string{}},
+		{Name: "bad escape", Path: "/users/%zz", Route: ""},
+	}
+
+	for _, tc := range testCases {
+		handler, params := appRouter.(router.ReadOnlyRouter).GetHandler(common.GET, tc.Path)
+		if tc.Route == "" {
+			if handler != nil {
+				t.Errorf("[ %s ]expected no match for %s", tc.Name, tc.Path)
+			}
+			continue
+		}
+		if handler == nil {
+			t.Errorf("[ %s ]expected %s to match", tc.Name, tc.Path)
+			continue
+		}
+		response := httpcore.NewHttpResponseWriter()
+		handler(httpcore.Request{}, &response)
+		if route, _ := response.GetHeader("X-Route"); route != tc.Route {
+			t.Errorf("[ %s ]expected route %s, got %s", tc.Name, tc.Route, route)
+		}
+		if len(params) != len(tc.Expected) {
+			t.Errorf("[ %s ]expected params %v, got %v", tc.Name, tc.Expected, params)
+		}
+		for key, value := range tc.Expected {
+			if params[key] != value {
+				t.Errorf("[ %s ]expected params %v, got %v", tc.Name, tc.Expected, params)
+			}
+		}
+	}
+}
+
+func TestRoutePatternErrors(t *testing.T) {
+	for _, pattern := range []string{"/files/*path/more", "/users/:id{[0-9", "/users/:id{(}"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("[ %s ]expected registration to panic", pattern)
+				}
+			}()
+			router.NewRouter().Get(pattern, func(r httpcore.Request, w *httpcore.HttpResponseWriter) {})
+		}()
+	}
+
+	appRouter := router.NewRouter()
+	appRouter.Get("/files/*path", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected a second catch-all name to panic")
+			}
+		}()
+		appRouter.Get("/files/*rest", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {})
+	}()
+	// the first catch-all is still there
+	if handler, params := appRouter.(router.ReadOnlyRouter).GetHandler(common.GET, "/files/a/b"); handler == nil || params["path"] != "a/b" {
+		t.Errorf("expected /files/*path to match, got params %v", params)
+	}
+	// the same name registers another method
+	appRouter.Post("/files/*path", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {})
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	appRouter := router.NewRouter()
+	ok := func(r httpcore.Request, w *httpcore.HttpResponseWriter) { w.SetStatus(httpcore.StatusOK) }
+	appRouter.Get("/users/:id", ok)
+	appRouter.Delete("/users/:id{int}", ok)
+	appRouter.Post("/users", ok)
+	appRouter.Options("/custom", ok)
+	appRouter.Put("/custom", ok)
+
+	testCases := []struct {
+		Name     string
+		Method   common.Method
+		Path     string
+		Expected string
+		Allow    string
+	}{
+		{Name: "registered", Method: common.GET, Path: "/users/7", Expected: "200"},
+		{Name: "405 lists matching methods", Method: common.PUT, Path: "/users/7", Expected: "405", Allow: "GET, HEAD, DELETE, OPTIONS"},
+		{Name: "constraint limits Allow", Method: common.PUT, Path: "/users/bob", Expected: "405", Allow: "GET, HEAD, OPTIONS"},
+		{Name: "automatic OPTIONS", Method: common.OPTIONS, Path: "/users", Expected: "204", Allow: "POST, OPTIONS"},
+		{Name: "OPTIONS route wins", Method: common.OPTIONS, Path: "/custom", Expected: "200"},
+		{Name: "server wide OPTIONS", Method: common.OPTIONS, Path: "*", Expected: "204", Allow: "GET, HEAD, POST, PUT, DELETE, OPTIONS"},
+		{Name: "unknown path", Method: common.PUT, Path: "/nothing", Expected: "404"},
+	}
+
+	for _, tc := range testCases {
+		response := serve(t, appRouter, tc.Method, tc.Path)
+		if got := string(response.ToResponseByte()); !strings.HasPrefix(got, "HTTP/1.1 "+tc.Expected) {
+			t.Errorf("[ %s ]expected status %s, got %q", tc.Name, tc.Expected, got)
+		}
+		if allow, _ := response.GetHeader("Allow"); allow != tc.Allow {
+			t.Errorf("[ %s ]expected Allow %q, got %q", tc.Name, tc.Allow, allow)
+		}
+	}
+
+	// both answers can be replaced or turned off
+	appRouter.MethodNotAllowed(func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetStatus(httpcore.StatusNotFound)
+	})
+	appRouter.AutoOptions(nil)
+	response := serve(t, appRouter, common.PUT, "/users/7")
+	if got := string(response.ToResponseByte()); !strings.HasPrefix(got, "HTTP/1.1 404") || !strings.Contains(got, "Allow: GET, HEAD, DELETE\r\n") {
+		t.Errorf("expected the custom handler with Allow set, got %q", got)
+	}
+	response = serve(t, appRouter, common.OPTIONS, "/users")
+	if got := string(response.ToResponseByte()); !strings.HasPrefix(got, "HTTP/1.1 404") {
+		t.Errorf("expected OPTIONS to be handled as not allowed, got %q", got)
+	}
+
+	// nil brings the default answer back
+	appRouter.MethodNotAllowed(nil)
+	response = serve(t, appRouter, common.PUT, "/users/7")
+	if got := string(response.ToResponseByte()); !strings.HasPrefix(got, "HTTP/1.1 405") || !strings.Contains(got, "Allow: GET, HEAD, DELETE\r\n") {
+		t.Errorf("expected the default 405, got %q", got)
+	}
+}
+
+func listUsers(r httpcore.Request, w *httpcore.HttpResponseWriter) {}
+
+func requireAuth(next httpcore.HandlerFunc) httpcore.HandlerFunc { return next }
+
+func TestRoutes(t *testing.T) {
+	var calls []string
+	appRouter := router.NewRouter()
+	appRouter.Use(trace("log", &calls))
+	api := appRouter.Group("/api", requireAuth)
+	api.Get("/users", listUsers)
+	api.Post("/users", listUsers)
+	api.Get("/users/:id{int}/:tab?", listUsers)
+	appRouter.Delete("/api/users", listUsers)
+
+	expected := []router.RouteInfo{
+		{Method: common.GET, Pattern: "/api/users", Handlers: []string{"router_test.listUsers"}, Middleware: []string{"router_test.trace.func1", "router_test.requireAuth"}},
+		{Method: common.POST, Pattern: "/api/users", Handlers: []string{"router_test.listUsers"}, Middleware: []string{"router_test.trace.func1", "router_test.requireAuth"}},
+		{Method: common.DELETE, Pattern: "/api/users", Handlers: []string{"router_test.listUsers"}, Middleware: []string{"router_test.trace.func1"}},
+		{Method: common.GET, Pattern: "/api/users/:id{int}/:tab?", Handlers: []string{"router_test.listUsers"}, Middleware: []string{"router_test.trace.func1", "router_test.requireAuth"}},
+	}
+
+	routes := appRouter.Routes()
+	if len(routes) != len(expected) {
+		t.Fatalf("expected %d routes, got %v", len(expected), routes)
+	}
+	for idx, route := range routes {
+		want := expected[idx]
+		if route.Method != want.Method || route.Pattern != want.Pattern ||
+			strings.Join(route.Handlers, ",") != strings.Join(want.Handlers, ",") ||
+			strings.Join(route.Middleware, ",") != strings.Join(want.Middleware, ",") {
+			t.Errorf("expected %v, got %v", want, route)
+		}
+	}
+}
+
+func TestURL(t *testing.T) {
+	appRouter := router.NewRouter()
+	noop := func(r httpcore.Request, w *httpcore.HttpResponseWriter) {}
+	api := appRouter.Group("/api")
+	api.Named("user", common.GET, "/users/:id{int}", noop)
+	api.Named("user-tab", common.GET, "/users/:id/:tab?", noop)
+	appRouter.Named("file", common.GET, "/files/*path", noop)
+	appRouter.Named("home", common.GET, "/", noop)
+
+	testCases := []struct {
+		Name     string
+		Route    string
+		Params   map[string]string
+		Query    url.Values
+		Expected string
+		Err      error
+	}{
+		{Name: "param", Route: "user", Params: map[string]string{"id": "42"}, Expected: "/api/users/42"},
+		{Name: "query", Route: "user", Params: map[string]string{"id": "42"}, Query: url.Values{"q": {"a b"}, "x": {"1", "2"}}, Expected: "/api/users/42?q=a+b&x=1&x=2"},
+		{Name: "percent-encoding", Route: "user-tab", Params: map[string]string{"id": "a/b c", "tab": "ü?"}, Expected: "/api/users/a%2Fb%20c/%C3%BC%3F"},
+		{Name: "optional left out", Route: "user-tab", Params: map[string]string{"id": "7"}, Expected: "/api/users/7"},
+		{Name: "catch-all keeps slashes", Route: "file", Params: map[string]string{"path": "a/b c/d.txt"}, Expected: "/files/a/b%20c/d.txt"},
+		{Name: "root", Route: "home", Expected: "/"},
+		{Name: "missing param", Route: "user", Err: router.ErrMissingParam},
+		{Name: "constraint", Route: "user", Params: map[string]string{"id": "bob"}, Err: router.ErrInvalidParam},
+		{Name: "unknown name", Route: "nope", Err: router.ErrUnknownRoute},
+	}
+
+	for _, tc := range testCases {
+		got, err := appRouter.URL(tc.Route, tc.Params, tc.Query)
+		if !errors.Is(err, tc.Err) {
+			t.Errorf("[ %s ]expected error %v, got %v", tc.Name, tc.Err, err)
+		}
+		if got != tc.Expected {
+			t.Errorf("[ %s ]expected %q, got %q", tc.Name, tc.Expected, got)
+		}
+	}
+
+	if handler, _ := appRouter.(router.ReadOnlyRouter).GetHandler(common.GET, "/api/users/42"); handler == nil {
+		t.Errorf("expected the named route to be served")
+	}
+	if routes := appRouter.Routes(); routes[0].Name != "home" {
+		t.Errorf("expected the route name in the listing, got %v", routes[0])
+	}
+}
+
+func TestHostRouter(t *testing.T) {
+	respond := func(body string) httpcore.HandlerFunc {
+		return func(r httpcore.Request, w *httpcore.HttpResponseWriter) { w.Write([]byte(body)) }
+	}
+
+	fallback := router.NewRouter()
+	fallback.Get("/", respond("fallback"))
+	hosts := router.NewHostRouter(fallback)
+	hosts.Host("api.example.test").Get("/", respond("api"))
+	hosts.Host("*.example.test").Get("/", respond("any subdomain"))
+	hosts.Host("*.eu.example.test").Get("/", respond("eu subdomain"))
+	hosts.Host("*.example.test").Get("/more", respond("same wildcard router"))
+	static := hosts.Host("Static.Example.Test")
+	static.Get("/", respond("static"))
+	static.NotFound(respond("static fallback"))
+
+	testCases := []struct {
+		Name     string
+		Host     string
+		Path     string
+		Expected string
+	}{
+		{Name: "exact", Host: "api.example.test", Path: "/", Expected: "api"},
+		{Name: "exact with port", Host: "api.example.test:4221", Path: "/", Expected: "api"},
+		{Name: "case and trailing dot", Host: "STATIC.example.test.", Path: "/", Expected: "static"},
+		{Name: "wildcard", Host: "www.example.test", Path: "/", Expected: "any subdomain"},
+		{Name: "wildcard any depth", Host: "a.b.example.test", Path: "/more", Expected: "same wildcard router"},
+		{Name: "longest wildcard", Host: "paris.eu.example.test", Path: "/", Expected: "eu subdomain"},
+		{Name: "apex is not a subdomain", Host: "example.test", Path: "/", Expected: "fallback"},
+		{Name: "unknown host", Host: "other.test", Path: "/", Expected: "fallback"},
+		{Name: "IPv6 literal", Host: "[::1]:4221", Path: "/", Expected: "fallback"},
+		{Name: "per host fallback", Host: "static.example.test", Path: "/missing", Expected: "static fallback"},
+		{Name: "trees are separate", Host: "api.example.test", Path: "/more", Expected: ""},
+	}
+
+	for _, tc := range testCases {
+		handler, _ := hosts.Resolve(tc.Host, common.GET, tc.Path)
+		got := ""
+		if handler != nil {
+			response := httpcore.NewHttpResponseWriter()
+			handler(httpcore.Request{}, &response)
+			got = string(response.Body)
+		}
+		if got != tc.Expected {
+			t.Errorf("[ %s ]expected %q, got %q", tc.Name, tc.Expected, got)
+		}
+	}
+
+	if handler, _ := router.NewHostRouter(nil).Resolve("any.test", common.GET, "/"); handler != nil {
+		t.Errorf("expected no handler without a fallback")
+	}
+
+	// a host router falls back to another one with the same host
+	outer := router.NewHostRouter(hosts)
+	outer.Host("other.test").Get("/", respond("outer"))
+	for host, expected := range map[string]string{"other.test": "outer", "api.example.test": "api", "unknown.test": "fallback"} {
+		handler, _ := outer.Resolve(host, common.GET, "/")
+		if handler == nil {
+			t.Errorf("[ %s ]expected %q, got no handler", host, expected)
+			continue
+		}
+		response := httpcore.NewHttpResponseWriter()
+		handler(httpcore.Request{}, &response)
+		if string(response.Body) != expected {
+			t.Errorf("[ %s ]expected %q, got %q", host, expected, response.Body)
+		}
+	}
+}
+
+func TestRuntimeChanges(t *testing.T) {
+	appRouter := router.NewRouter()
+	respond := func(body string) httpcore.HandlerFunc {
+		return func(r httpcore.Request, w *httpcore.HttpResponseWriter) { w.Write([]byte(body)) }
+	}
+	appRouter.Get("/stable", respond("stable"))
+	appRouter.Named("flag", common.GET, "/flags/:name?", respond("on"))
+
+	body := func(path string) string {
+		handler, _ := appRouter.(router.ReadOnlyRouter).GetHandler(common.GET, path)
+		if handler == nil {
+			return ""
+		}
+		response := httpcore.NewHttpResponseWriter()
+		handler(httpcore.Request{}, &response)
+		return string(response.Body)
+	}
+
+	appRouter.Get("/stable", respond("replaced"))
+	if got := body("/stable"); got != "replaced" {
+		t.Errorf("expected the route to be replaced, got %q", got)
+	}
+
+	if !appRouter.Remove(common.GET, "/flags/:name?") {
+		t.Errorf("expected the route to be removed")
+	}
+	if body("/flags") != "" || body("/flags/x") != "" {
+		t.Errorf("expected both forms of the optional route to be gone")
+	}
+	if _, err := appRouter.URL("flag", nil, nil); !errors.Is(err, router.ErrUnknownRoute) {
+		t.Errorf("expected the route name to be dropped, got %v", err)
+	}
+	if appRouter.Remove(common.GET, "/flags/:name?") || appRouter.Remove(common.DELETE, "/stable") {
+		t.Errorf("expected removing an unknown route to report false")
+	}
+	if len(appRouter.Routes()) != 1 {
+		t.Errorf("expected a single route left, got %v", appRouter.Routes())
+	}
+
+	// toggle a route while other goroutines serve requests, run with -race
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if body("/stable") != "replaced" {
+					t.Errorf("expected the stable route to keep working")
+					return
+				}
+				body("/toggle/1")
+			}
+		}()
+	}
+	for idx := range 200 {
+		if idx%2 == 0 {
+			appRouter.Get("/toggle/:id{int}", respond("on"))
+		} else {
+			appRouter.Remove(common.GET, "/toggle/:id{int}")
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,95 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/common"
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+)
+
+var (
+	ErrUnknownRoute = errors.New("no route with this name")
+	ErrMissingParam = errors.New("missing route parameter")
+	ErrInvalidParam = errors.New("parameter does not match its constraint")
+)
+
+// namedRoute keeps the parsed segments of a named pattern for URL.
+type namedRoute struct {
+	method   common.Method
+	pattern  string
+	segments []*Route
+	optional []bool
+}
+
+// Named registers a route like Handle and names it for URL.
+func (r *Router) Named(name string, method common.Method, path string, handlers ...httpcore.HandlerFunc) {
+	r.tree.mu.Lock()
+	defer r.tree.mu.Unlock()
+	if _, exists := r.tree.names[name]; exists {
+		panic(fmt.Sprintf("router: route name %q registered twice", name))
+	}
+	r.addRouteLocked(method, path, handlers...)
+
+	pattern := joinPath(r.prefix, path)
+	named := &namedRoute{method: method, pattern: pattern}
+	for _, segment := range splitPath(pattern) {
+		named.optional = append(named.optional, strings.HasSuffix(segment, "?"))
+		named.segments = append(named.segments, parseSegment(strings.TrimSuffix(segment, "?")))
+	}
+	r.tree.names[name] = named
+}
+
+// URL builds the path of the route called name. Parameter values are
+// percent-encoded, a catch-all value keeps its slashes. Optional segments
+// are left out unless a value is given for them, unused params are ignored.
+func (r *Router) URL(name string, params map[string]string, query url.Values) (string, error) {
+	r.tree.mu.RLock()
+	named, exists := r.tree.names[name]
+	r.tree.mu.RUnlock()
+	if !exists {
+		return "", fmt.Errorf("%w: %q", ErrUnknownRoute, name)
+	}
+
+	parts := make([]string, 0, len(named.segments))
+	for idx, segment := range named.segments {
+		if segment.kind == staticSegment {
+			if !named.optional[idx] {
+				parts = append(parts, segment.key)
+			}
+			continue
+		}
+
+		value, given := params[segment.name]
+		if !given || value == "" {
+			if named.optional[idx] {
+				continue
+			}
+			return "", fmt.Errorf("%w: %q of route %q", ErrMissingParam, segment.name, name)
+		}
+
+		if segment.kind == wildcardSegment {
+			pieces := strings.Split(value, "/")
+			for i, piece := range pieces {
+				pieces[i] = url.PathEscape(piece)
+			}
+			parts = append(parts, strings.Join(pieces, "/"))
+			continue
+		}
+		if segment.constraint != nil && !segment.constraint.MatchString(value) {
+			return "", fmt.Errorf("%w: %q=%q of route %q", ErrInvalidParam, segment.name, value, name)
+		}
+		parts = append(parts, url.PathEscape(value))
+	}
+
+	path := strings.Join(parts, "/")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path, nil
+}
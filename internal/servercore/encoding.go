@@ -0,0 +1,170 @@
+package servercore
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression"
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+)
+
+// encodingStream compresses a response body on its way to next. The coding
+// is negotiated once the head is written, from then on the encoder output
+// goes to the client as the handler writes.
+type encodingStream struct {
+	next     httpcore.StreamWriter
+	encoders *compression.Registry
+	policy   compression.Policy
+	request  httpcore.Request
+	response *httpcore.HttpResponseWriter
+
+	encoder io.WriteCloser
+	discard bool
+}
+
+func (h *HttpServer) newEncodingStream(next httpcore.StreamWriter, request httpcore.Request, response *httpcore.HttpResponseWriter) *encodingStream {
+	return &encodingStream{next: next, encoders: h.encoders, policy: h.policy, request: request, response: response}
+}
+
+// WriteHead picks the coding. An encoded body has no known length, the
+// Content-Length is dropped and HTTP/1.1 falls back to chunked framing. A
+// successful response the client accepts in no coding at all, not even
+// identity, is replaced with an empty 406 that keeps none of its fields.
+func (s *encodingStream) WriteHead(status httpcore.HttpStatus, message string, headers []httpcore.HeaderField) error {
+	bodyAllowed := status >= 200 && status != httpcore.StatusNoContent && status != httpcore.StatusNotModified
+	if !bodyAllowed || s.response.IsCompressionDisabled() || hasField(headers, "Content-Encoding") {
+		return s.next.WriteHead(status, message, headers)
+	}
+
+	size := int64(-1)
+	if value, ok := fieldValue(headers, "Content-Length"); ok {
+		if length, err := strconv.ParseInt(value, 10, 64); err == nil {
+			size = length
+		}
+	}
+	contentType, _ := fieldValue(headers, "Content-Type")
+	if !s.policy.Allows(contentType, size) {
+		return s.next.WriteHead(status, message, headers)
+	}
+	headers = withVary(headers, "Accept-Encoding")
+
+	accept, present := s.request.Headers.Lookup("accept-encoding")
+	coding, err := s.encoders.Negotiate(accept, present)
+	if err != nil {
+		if status < 200 || status >= 300 {
+			return s.next.WriteHead(status, message, headers)
+		}
+		// the fields of the handler describe a representation that is not
+		// sent, the 406 starts over with its own
+		s.discard = true
+		headers = []httpcore.HeaderField{{Key: "Vary", Value: "Accept-Encoding"}, {Key: "Content-Length", Value: "0"}}
+		return s.next.WriteHead(httpcore.StatusNotAcceptable, httpcore.StatusText(httpcore.StatusNotAcceptable), headers)
+	}
+	if coding.NewWriter == nil {
+		return s.next.WriteHead(status, message, headers)
+	}
+
+	encoder, err := coding.NewWriter(streamData{s.next})
+	if err != nil {
+		fmt.Printf("Error creating the %s encoder %v\n", coding.Name, err)
+		return s.next.WriteHead(status, message, headers)
+	}
+	s.encoder = encoder
+	headers = withoutFields(headers, "Content-Length")
+	headers = append(headers, httpcore.HeaderField{Key: "Content-Encoding", Value: coding.Name})
+	return s.next.WriteHead(status, message, headers)
+}
+
+func (s *encodingStream) WriteData(p []byte) error {
+	switch {
+	case s.discard:
+		return nil
+	case s.encoder != nil:
+		_, err := s.encoder.Write(p)
+		return err
+	}
+	return s.next.WriteData(p)
+}
+
+// Flush pushes out what the encoder holds back, so a flushed event
+// stream still reaches the client right away.
+func (s *encodingStream) Flush() error {
+	if flusher, ok := s.encoder.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+	return s.next.Flush()
+}
+
+func (s *encodingStream) End(trailers []httpcore.HeaderField) error {
+	if s.discard {
+		trailers = nil
+	}
+	if s.encoder != nil {
+		if err := s.encoder.Close(); err != nil {
+			return err
+		}
+	}
+	return s.next.End(trailers)
+}
+
+// streamData lets an encoder write its output to a stream.
+type streamData struct {
+	stream httpcore.StreamWriter
+}
+
+func (d streamData) Write(p []byte) (int, error) {
+	if err := d.stream.WriteData(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func fieldValue(fields []httpcore.HeaderField, key string) (string, bool) {
+	for _, field := range fields {
+		if strings.EqualFold(field.Key, key) {
+			return field.Value, true
+		}
+	}
+	return "", false
+}
+
+func hasField(fields []httpcore.HeaderField, key string) bool {
+	_, exists := fieldValue(fields, key)
+	return exists
+}
+
+func withoutFields(fields []httpcore.HeaderField, keys ...string) []httpcore.HeaderField {
+	kept := make([]httpcore.HeaderField, 0, len(fields))
+	for _, field := range fields {
+		drop := false
+		for _, key := range keys {
+			drop = drop || strings.EqualFold(field.Key, key)
+		}
+		if !drop {
+			kept = append(kept, field)
+		}
+	}
+	return kept
+}
+
+// withVary adds name to the Vary field unless it is listed already.
+func withVary(fields []httpcore.HeaderField, name string) []httpcore.HeaderField {
+	for idx, field := range fields {
+		if !strings.EqualFold(field.Key, "Vary") {
+			continue
+		}
+		for _, listed := range strings.Split(field.Value, ",") {
+			listed = strings.TrimSpace(listed)
+			if listed == "*" || strings.EqualFold(listed, name) {
+				return fields
+			}
+		}
+		fields[idx].Value += ", " + name
+		return fields
+	}
+	return append(fields, httpcore.HeaderField{Key: "Vary", Value: name})
+}
@@ -0,0 +1,1045 @@
+package servercore
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/common"
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+)
+
+const (
+	http2MaxConcurrentStreams = 100
+	http2MaxHeaderListSize    = 1 << 20
+	http2HeaderTableSize      = 4096
+	// http2DrainTimeout bounds how long the streams a GOAWAY promised to
+	// finish keep the connection open
+	http2DrainTimeout = 5 * time.Second
+)
+
+var errHttp2StreamDone = errors.New("http2 stream is closed")
+
+// http2Conn serves one HTTP/2 connection (RFC 9113). Frames are read by the
+// connection goroutine, every stream is handled on its own goroutine and
+// writes are serialized by writeMu. WINDOW_UPDATE frames are sent by a
+// single credit writer, see writeCredits.
+type http2Conn struct {
+	server   *HttpServer
+	conn     net.Conn
+	reader   *bufio.Reader
+	tlsState *tls.ConnectionState
+
+	writeMu sync.Mutex
+	writer  *bufio.Writer
+	encoder hpackEncoder
+	decoder *hpackDecoder
+
+	// mu guards everything below, cond is broadcast whenever a flow control
+	// window, a stream body or the connection state changes
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*http2Stream
+	lastStreamID      uint32
+	sendWindow        int64
+	recvWindow        int64
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	closed            bool
+	// goingAway is set once GOAWAY is sent, no stream is accepted after it
+	// and drained is closed when the accepted ones are done
+	goingAway bool
+	drained   chan struct{}
+	// window handed back but not announced yet, creditReady wakes the
+	// credit writer and is closed with the connection
+	connCredit    int64
+	creditStreams []*http2Stream
+	creditReady   chan struct{}
+
+	handlers sync.WaitGroup
+}
+
+type http2Stream struct {
+	id            uint32
+	sendWindow    int64
+	recvWindow    int64
+	remoteClosed  bool
+	reset         bool
+	contentLength int64
+	received      int64
+	// stream window handed back but not announced yet
+	credit  int64
+	request *httpcore.Request
+	// closed once the stream is gone, see http2ResponseStream.CloseNotify
+	done chan struct{}
+
+	// request body state
+	data       []byte
+	bodyClosed bool
+	bodyErr    error
+}
+
+// header block that is still waiting for CONTINUATION frames
+type http2PendingHeaders struct {
+	streamID  uint32
+	endStream bool
+	block     []byte
+}
+
+func newHttp2Conn(server *HttpServer, conn net.Conn, reader *bufio.Reader, writer *bufio.Writer, tlsState *tls.ConnectionState) *http2Conn {
+	c := &http2Conn{
+		server:            server,
+		conn:              conn,
+		reader:            reader,
+		tlsState:          tlsState,
+		writer:            writer,
+		decoder:           newHpackDecoder(http2HeaderTableSize, http2MaxHeaderListSize),
+		streams:           make(map[uint32]*http2Stream),
+		sendWindow:        http2DefaultWindowSize,
+		recvWindow:        http2DefaultWindowSize,
+		peerInitialWindow: http2DefaultWindowSize,
+		peerMaxFrameSize:  http2DefaultMaxFrameSize,
+		creditReady:       make(chan struct{}, 1),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// isHttp2Preface peeks at the start of a cleartext connection for the
+// HTTP/2 connection preface sent by clients with prior knowledge.
+func isHttp2Preface(reader *bufio.Reader) bool {
+	start, err := reader.Peek(4)
+	if err != nil || string(start) != http2Preface[:4] {
+		return false
+	}
+	preface, err := reader.Peek(len(http2Preface))
+	return err == nil && string(preface) == http2Preface
+}
+
+// isH2cUpgrade reports whether an HTTP/1.1 request asks to switch to
+// cleartext HTTP/2. Requests with a body are served over HTTP/1.1 instead.
+func isH2cUpgrade(request *httpcore.Request) bool {
+	if _, ok := request.Headers.Lookup("http2-settings"); !ok || request.ContentLength != 0 {
+		return false
+	}
+	for _, protocol := range strings.Split(request.Headers.Get("upgrade"), ",") {
+		if strings.EqualFold(strings.TrimSpace(protocol), "h2c") {
+			return true
+		}
+	}
+	return false
+}
+
+// serve runs the connection until the peer goes away. upgrade is the
+// request of an "Upgrade: h2c" exchange which becomes stream 1.
+func (c *http2Conn) serve(upgrade *httpcore.Request) {
+	defer c.shutdown()
+	go c.writeCredits()
+
+	if upgrade != nil {
+		settings, err := decodeHttp2SettingsHeader(upgrade.Headers.Get("http2-settings"))
+		if err != nil {
+			return
+		}
+		if err := c.applySettings(settings); err != nil {
+			return
+		}
+	}
+
+	if err := c.writeSettings(); err != nil {
+		return
+	}
+
+	preface := make([]byte, len(http2Preface))
+	if _, err := io.ReadFull(c.reader, preface); err != nil || string(preface) != http2Preface {
+		return
+	}
+
+	if upgrade != nil {
+		delete(upgrade.Headers, "upgrade")
+		delete(upgrade.Headers, "http2-settings")
+		delete(upgrade.Headers, "connection")
+		stream := c.newStream(1)
+		stream.remoteClosed = true
+		stream.request = upgrade
+		c.lastStreamID = 1
+		c.startHandler(stream)
+	}
+
+	var pending *http2PendingHeaders
+	for {
+		frame, err := readHttp2Frame(c.reader, http2DefaultMaxFrameSize)
+		// after an error reading a frame the framing is lost
+		framingLost := err != nil
+		if err == nil {
+			pending, err = c.handleFrame(frame, pending)
+		}
+		if err == nil {
+			continue
+		}
+
+		var streamErr http2StreamError
+		if errors.As(err, &streamErr) {
+			c.resetStream(streamErr.StreamID, streamErr.Code)
+			continue
+		}
+
+		var connErr http2ConnError
+		if !errors.As(err, &connErr) {
+			return
+		}
+		c.goAway(connErr)
+		// frames are still read for the accepted streams, unless the error
+		// left the framing or the header table out of step with the client
+		if framingLost || connErr.Code == http2CompressionError {
+			c.stopReading()
+			<-c.drained
+			return
+		}
+	}
+}
+
+// goAway sends GOAWAY for a connection error. The streams up to the last
+// one accepted still complete, the connection closes once they are done
+// or after http2DrainTimeout.
+func (c *http2Conn) goAway(connErr http2ConnError) {
+	c.mu.Lock()
+	if c.goingAway {
+		c.mu.Unlock()
+		return
+	}
+	c.goingAway = true
+	c.drained = make(chan struct{})
+	lastStreamID := c.lastStreamID
+	c.mu.Unlock()
+
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(connErr.Code))
+	payload = append(payload, connErr.Reason...)
+	c.writeFrame(http2FrameGoAway, 0, 0, payload)
+
+	// no handler starts after goingAway, the wait cannot miss one
+	done := make(chan struct{})
+	go func() {
+		c.handlers.Wait()
+		close(done)
+	}()
+	go func() {
+		timer := time.NewTimer(http2DrainTimeout)
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-timer.C:
+		}
+		c.conn.Close()
+		close(c.drained)
+	}()
+}
+
+// stopReading ends the request bodies still expecting data once no more
+// frames are read, what arrived already can still be read.
+func (c *http2Conn) stopReading() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, stream := range c.streams {
+		if !stream.remoteClosed && stream.bodyErr == nil {
+			stream.bodyErr = io.ErrUnexpectedEOF
+		}
+	}
+	c.cond.Broadcast()
+}
+
+func (c *http2Conn) handleFrame(frame http2Frame, pending *http2PendingHeaders) (*http2PendingHeaders, error) {
+	if pending != nil {
+		if frame.Type != http2FrameContinuation || frame.StreamID != pending.streamID {
+			return nil, http2ConnError{http2ProtocolError, "expected CONTINUATION"}
+		}
+		pending.block = append(pending.block, frame.Payload...)
+		if len(pending.block) > http2MaxHeaderListSize {
+			return nil, http2ConnError{http2EnhanceYourCalm, "header block too large"}
+		}
+		if !frame.Has(http2FlagEndHeaders) {
+			return pending, nil
+		}
+		return nil, c.handleHeaderBlock(pending.streamID, pending.block, pending.endStream)
+	}
+
+	switch frame.Type {
+	case http2FrameData:
+		return nil, c.handleData(frame)
+	case http2FrameHeaders:
+		return c.handleHeaders(frame)
+	case http2FramePriority:
+		if frame.StreamID == 0 {
+			return nil, http2ConnError{http2ProtocolError, "PRIORITY on stream 0"}
+		}
+		if len(frame.Payload) != 5 {
+			return nil, http2StreamError{frame.StreamID, http2FrameSizeError}
+		}
+		return nil, nil
+	case http2FrameRSTStream:
+		return nil, c.handleRSTStream(frame)
+	case http2FrameSettings:
+		return nil, c.handleSettings(frame)
+	case http2FramePushPromise:
+		return nil, http2ConnError{http2ProtocolError, "clients cannot push"}
+	case http2FramePing:
+		return nil, c.handlePing(frame)
+	case http2FrameGoAway:
+		// the client will not open new streams, the ones in flight still
+		// complete before it closes the connection
+		return nil, nil
+	case http2FrameWindowUpdate:
+		return nil, c.handleWindowUpdate(frame)
+	case http2FrameContinuation:
+		return nil, http2ConnError{http2ProtocolError, "unexpected CONTINUATION"}
+	}
+	// unknown frame types are ignored (RFC 9113 section 4.1)
+	return nil, nil
+}
+
+func (c *http2Conn) handleHeaders(frame http2Frame) (*http2PendingHeaders, error) {
+	if frame.StreamID == 0 {
+		return nil, http2ConnError{http2ProtocolError, "HEADERS on stream 0"}
+	}
+	block, err := frame.stripPadding()
+	if err != nil {
+		return nil, err
+	}
+	if frame.Has(http2FlagPriority) {
+		if len(block) < 5 {
+			return nil, http2ConnError{http2FrameSizeError, "short HEADERS priority"}
+		}
+		block = block[5:]
+	}
+
+	endStream := frame.Has(http2FlagEndStream)
+	if !frame.Has(http2FlagEndHeaders) {
+		return &http2PendingHeaders{streamID: frame.StreamID, endStream: endStream, block: append([]byte(nil), block...)}, nil
+	}
+	return nil, c.handleHeaderBlock(frame.StreamID, block, endStream)
+}
+
+func (c *http2Conn) handleHeaderBlock(streamID uint32, block []byte, endStream bool) error {
+	// the block has to be decoded even when the stream gets refused to keep
+	// the dynamic table in sync with the client
+	fields, err := c.decoder.Decode(block)
+	if err != nil {
+		return http2ConnError{http2CompressionError, err.Error()}
+	}
+
+	c.mu.Lock()
+	stream, exists := c.streams[streamID]
+	if exists {
+		// trailers
+		if stream.remoteClosed {
+			c.mu.Unlock()
+			return http2ConnError{http2StreamClosed, "HEADERS after END_STREAM"}
+		}
+		if !endStream {
+			c.mu.Unlock()
+			return http2StreamError{streamID, http2ProtocolError}
+		}
+		for _, field := range fields {
+			if strings.HasPrefix(field.Name, ":") {
+				c.mu.Unlock()
+				return http2StreamError{streamID, http2ProtocolError}
+			}
+			stream.request.Trailers.Add(field.Name, field.Value)
+		}
+		err := c.closeRemote(stream)
+		c.mu.Unlock()
+		return err
+	}
+
+	if streamID%2 == 0 {
+		c.mu.Unlock()
+		return http2ConnError{http2ProtocolError, "invalid stream identifier"}
+	}
+	if streamID <= c.lastStreamID {
+		// trailers of a stream that was reset in the meantime
+		c.mu.Unlock()
+		return nil
+	}
+	if c.goingAway {
+		// streams after the last one GOAWAY named are ignored
+		c.mu.Unlock()
+		return nil
+	}
+	c.lastStreamID = streamID
+	if len(c.streams) >= http2MaxConcurrentStreams {
+		c.mu.Unlock()
+		return http2StreamError{streamID, http2RefusedStream}
+	}
+	c.mu.Unlock()
+
+	method, target, headers, err := http2RequestHeaders(fields)
+	if err != nil {
+		return http2StreamError{streamID, http2ProtocolError}
+	}
+
+	contentLength := int64(-1)
+	if value, ok := headers.Lookup("content-length"); ok {
+		contentLength, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || contentLength < 0 {
+			return http2StreamError{streamID, http2ProtocolError}
+		}
+	}
+	request, err := httpcore.NewRequest(method, target, headers, nil, contentLength)
+	if err != nil {
+		return http2StreamError{streamID, http2ProtocolError}
+	}
+
+	c.mu.Lock()
+	stream = c.newStream(streamID)
+	stream.contentLength = contentLength
+	if endStream {
+		stream.remoteClosed = true
+		request.ContentLength = 0
+	} else {
+		request.Body = &http2Body{conn: c, stream: stream}
+	}
+	stream.request = request
+	c.mu.Unlock()
+
+	c.startHandler(stream)
+	return nil
+}
+
+// http2RequestHeaders validates a request header list and splits off the
+// pseudo-header fields (RFC 9113 section 8.3.1).
+func http2RequestHeaders(fields []hpackField) (common.Method, string, httpcore.HeaderMap, error) {
+	malformed := errors.New("malformed request headers")
+	pseudo := make(map[string]string)
+	headers := make(httpcore.HeaderMap)
+	regularSeen := false
+
+	for _, field := range fields {
+		if strings.HasPrefix(field.Name, ":") {
+			if regularSeen {
+				return "", "", nil, malformed
+			}
+			switch field.Name {
+			case ":method", ":path", ":scheme", ":authority":
+			default:
+				return "", "", nil, malformed
+			}
+			if _, duplicate := pseudo[field.Name]; duplicate {
+				return "", "", nil, malformed
+			}
+			pseudo[field.Name] = field.Value
+			continue
+		}
+
+		regularSeen = true
+		if field.Name != strings.ToLower(field.Name) {
+			return "", "", nil, malformed
+		}
+		switch field.Name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			return "", "", nil, malformed
+		case "te":
+			if field.Value != "trailers" {
+				return "", "", nil, malformed
+			}
+		}
+
+		// Get folds the lines again, the crumbs of a split cookie included
+		headers.Add(field.Name, field.Value)
+	}
+
+	if pseudo[":method"] == "" || pseudo[":path"] == "" || pseudo[":scheme"] == "" {
+		return "", "", nil, malformed
+	}
+	if authority, ok := pseudo[":authority"]; ok {
+		if !headers.Has("host") {
+			headers.Set("host", authority)
+		}
+	}
+	return common.Method(pseudo[":method"]), pseudo[":path"], headers, nil
+}
+
+func (c *http2Conn) handleData(frame http2Frame) error {
+	if frame.StreamID == 0 {
+		return http2ConnError{http2ProtocolError, "DATA on stream 0"}
+	}
+	data, err := frame.stripPadding()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	length := int64(len(frame.Payload))
+	if length > c.recvWindow {
+		return http2ConnError{http2FlowControlError, "connection window exceeded"}
+	}
+	c.recvWindow -= length
+
+	stream, exists := c.streams[frame.StreamID]
+	if !exists {
+		// nobody will read this, give the connection window back
+		c.creditConnection(length)
+		if frame.StreamID > c.lastStreamID {
+			return http2ConnError{http2ProtocolError, "DATA on idle stream"}
+		}
+		// frames still in flight after the stream was reset are ignored
+		return nil
+	}
+	if stream.remoteClosed {
+		c.creditConnection(length)
+		return http2StreamError{frame.StreamID, http2StreamClosed}
+	}
+	if length > stream.recvWindow {
+		c.creditConnection(length)
+		return http2StreamError{frame.StreamID, http2FlowControlError}
+	}
+	stream.recvWindow -= length
+
+	stream.received += int64(len(data))
+	if stream.contentLength >= 0 && stream.received > stream.contentLength {
+		c.creditConnection(length)
+		return http2StreamError{frame.StreamID, http2ProtocolError}
+	}
+
+	// padding is never read by the handler
+	if padding := length - int64(len(data)); padding > 0 {
+		c.creditConnection(padding)
+		c.creditStream(stream, padding)
+	}
+	if stream.bodyClosed {
+		c.creditConnection(int64(len(data)))
+	} else {
+		stream.data = append(stream.data, data...)
+	}
+
+	if frame.Has(http2FlagEndStream) {
+		return c.closeRemote(stream)
+	}
+	c.cond.Broadcast()
+	return nil
+}
+
+// closeRemote marks that the client finished sending on stream, called
+// with mu held.
+func (c *http2Conn) closeRemote(stream *http2Stream) error {
+	stream.remoteClosed = true
+	if stream.contentLength >= 0 && stream.received != stream.contentLength {
+		stream.bodyErr = io.ErrUnexpectedEOF
+		c.cond.Broadcast()
+		return http2StreamError{stream.id, http2ProtocolError}
+	}
+	stream.bodyErr = io.EOF
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *http2Conn) handleRSTStream(frame http2Frame) error {
+	if frame.StreamID == 0 {
+		return http2ConnError{http2ProtocolError, "RST_STREAM on stream 0"}
+	}
+	if len(frame.Payload) != 4 {
+		return http2ConnError{http2FrameSizeError, "RST_STREAM length"}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if frame.StreamID > c.lastStreamID {
+		return http2ConnError{http2ProtocolError, "RST_STREAM on idle stream"}
+	}
+	if stream, exists := c.streams[frame.StreamID]; exists {
+		c.removeStream(stream)
+	}
+	return nil
+}
+
+func (c *http2Conn) handleSettings(frame http2Frame) error {
+	if frame.StreamID != 0 {
+		return http2ConnError{http2ProtocolError, "SETTINGS on a stream"}
+	}
+	if frame.Has(http2FlagAck) {
+		if len(frame.Payload) != 0 {
+			return http2ConnError{http2FrameSizeError, "SETTINGS ack with payload"}
+		}
+		return nil
+	}
+	if len(frame.Payload)%6 != 0 {
+		return http2ConnError{http2FrameSizeError, "SETTINGS length"}
+	}
+
+	if err := c.applySettings(frame.Payload); err != nil {
+		return err
+	}
+	return c.writeFrame(http2FrameSettings, http2FlagAck, 0, nil)
+}
+
+func (c *http2Conn) applySettings(payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for ; len(payload) >= 6; payload = payload[6:] {
+		id := http2SettingID(binary.BigEndian.Uint16(payload))
+		value := binary.BigEndian.Uint32(payload[2:])
+
+		switch id {
+		case http2SettingEnablePush:
+			if value > 1 {
+				return http2ConnError{http2ProtocolError, "invalid SETTINGS_ENABLE_PUSH"}
+			}
+		case http2SettingInitialWindowSize:
+			if value > http2MaxWindowSize {
+				return http2ConnError{http2FlowControlError, "invalid SETTINGS_INITIAL_WINDOW_SIZE"}
+			}
+			delta := int64(value) - c.peerInitialWindow
+			c.peerInitialWindow = int64(value)
+			for _, stream := range c.streams {
+				stream.sendWindow += delta
+				if stream.sendWindow > http2MaxWindowSize {
+					return http2ConnError{http2FlowControlError, "stream window overflow"}
+				}
+			}
+			c.cond.Broadcast()
+		case http2SettingMaxFrameSize:
+			if value < http2DefaultMaxFrameSize || value > http2MaxAllowedFrameSize {
+				return http2ConnError{http2ProtocolError, "invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+			c.peerMaxFrameSize = value
+		}
+		// the header table size does not matter as the encoder never
+		// indexes, the other settings are advisory
+	}
+	return nil
+}
+
+func (c *http2Conn) handlePing(frame http2Frame) error {
+	if frame.StreamID != 0 {
+		return http2ConnError{http2ProtocolError, "PING on a stream"}
+	}
+	if len(frame.Payload) != 8 {
+		return http2ConnError{http2FrameSizeError, "PING length"}
+	}
+	if frame.Has(http2FlagAck) {
+		return nil
+	}
+	return c.writeFrame(http2FramePing, http2FlagAck, 0, frame.Payload)
+}
+
+func (c *http2Conn) handleWindowUpdate(frame http2Frame) error {
+	if len(frame.Payload) != 4 {
+		return http2ConnError{http2FrameSizeError, "WINDOW_UPDATE length"}
+	}
+	increment := int64(binary.BigEndian.Uint32(frame.Payload) & 0x7fffffff)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.cond.Broadcast()
+
+	if frame.StreamID == 0 {
+		if increment == 0 {
+			return http2ConnError{http2ProtocolError, "zero WINDOW_UPDATE"}
+		}
+		c.sendWindow += increment
+		if c.sendWindow > http2MaxWindowSize {
+			return http2ConnError{http2FlowControlError, "connection window overflow"}
+		}
+		return nil
+	}
+
+	stream, exists := c.streams[frame.StreamID]
+	if !exists {
+		return nil
+	}
+	if increment == 0 {
+		return http2StreamError{frame.StreamID, http2ProtocolError}
+	}
+	stream.sendWindow += increment
+	if stream.sendWindow > http2MaxWindowSize {
+		return http2StreamError{frame.StreamID, http2FlowControlError}
+	}
+	return nil
+}
+
+// newStream registers a stream, called with mu held.
+func (c *http2Conn) newStream(id uint32) *http2Stream {
+	stream := &http2Stream{
+		id:            id,
+		sendWindow:    c.peerInitialWindow,
+		recvWindow:    http2DefaultWindowSize,
+		contentLength: -1,
+		done:          make(chan struct{}),
+	}
+	c.streams[id] = stream
+	return stream
+}
+
+// removeStream forgets a stream and wakes up its handler, called with mu
+// held.
+func (c *http2Conn) removeStream(stream *http2Stream) {
+	stream.reset = true
+	if stream.bodyErr == nil {
+		stream.bodyErr = errHttp2StreamDone
+	}
+	c.creditConnection(int64(len(stream.data)))
+	stream.data = nil
+	delete(c.streams, stream.id)
+	close(stream.done)
+	c.cond.Broadcast()
+}
+
+func (c *http2Conn) resetStream(streamID uint32, code http2ErrorCode) {
+	c.mu.Lock()
+	if stream, exists := c.streams[streamID]; exists {
+		c.removeStream(stream)
+	}
+	c.mu.Unlock()
+
+	payload := binary.BigEndian.AppendUint32(nil, uint32(code))
+	c.writeFrame(http2FrameRSTStream, 0, streamID, payload)
+}
+
+// creditConnection and creditStream hand flow control window back to the
+// client once data was consumed, called with mu held. The credit writer
+// announces it.
+func (c *http2Conn) creditConnection(n int64) {
+	if n <= 0 {
+		return
+	}
+	c.recvWindow += n
+	c.connCredit += n
+	c.wakeCreditWriter()
+}
+
+func (c *http2Conn) creditStream(stream *http2Stream, n int64) {
+	if n <= 0 || stream.remoteClosed || stream.reset {
+		return
+	}
+	stream.recvWindow += n
+	if stream.credit == 0 {
+		c.creditStreams = append(c.creditStreams, stream)
+	}
+	stream.credit += n
+	c.wakeCreditWriter()
+}
+
+// wakeCreditWriter is called with mu held.
+func (c *http2Conn) wakeCreditWriter() {
+	if c.closed {
+		return
+	}
+	select {
+	case c.creditReady <- struct{}{}:
+	default:
+		// already woken, it picks this credit up too
+	}
+}
+
+// writeCredits sends WINDOW_UPDATE frames for the window handed back by
+// creditConnection and creditStream until the connection closes. Credits
+// add up while a write is under way, so they reach the client in the order
+// they were given and a fast uploader costs one frame per stream per
+// round rather than one per DATA frame read.
+func (c *http2Conn) writeCredits() {
+	for range c.creditReady {
+		c.writeMu.Lock()
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			c.writeMu.Unlock()
+			return
+		}
+		var frames []byte
+		if c.connCredit > 0 {
+			frames = appendHttp2Frame(frames, http2FrameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, uint32(c.connCredit)))
+			c.connCredit = 0
+		}
+		for _, stream := range c.creditStreams {
+			if !stream.remoteClosed && !stream.reset {
+				frames = appendHttp2Frame(frames, http2FrameWindowUpdate, 0, stream.id, binary.BigEndian.AppendUint32(nil, uint32(stream.credit)))
+			}
+			stream.credit = 0
+		}
+		c.creditStreams = nil
+		c.mu.Unlock()
+
+		err := c.writeFramesLocked(frames)
+		c.writeMu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *http2Conn) startHandler(stream *http2Stream) {
+	stream.request.TLS = c.tlsState
+	c.handlers.Add(1)
+	go func() {
+		defer c.handlers.Done()
+
+		response := httpcore.NewStreamingResponseWriter(&http2ResponseStream{conn: c, stream: stream, head: stream.request.Method == common.HEAD})
+		c.server.dispatch(stream.request, &response)
+		stream.request.Body.Close()
+		if err := response.Finish(); err != nil && !errors.Is(err, errHttp2StreamDone) {
+			fmt.Printf("Error writing the response %v", err)
+		}
+	}()
+}
+
+func (c *http2Conn) writeSettings() error {
+	payload := make([]byte, 0, 12)
+	payload = binary.BigEndian.AppendUint16(payload, uint16(http2SettingMaxConcurrentStreams))
+	payload = binary.BigEndian.AppendUint32(payload, http2MaxConcurrentStreams)
+	payload = binary.BigEndian.AppendUint16(payload, uint16(http2SettingMaxHeaderListSize))
+	payload = binary.BigEndian.AppendUint32(payload, http2MaxHeaderListSize)
+	return c.writeFrame(http2FrameSettings, 0, 0, payload)
+}
+
+func (c *http2Conn) writeFrame(frameType http2FrameType, flags uint8, streamID uint32, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrameLocked(frameType, flags, streamID, payload)
+}
+
+func (c *http2Conn) writeFrameLocked(frameType http2FrameType, flags uint8, streamID uint32, payload []byte) error {
+	return c.writeFramesLocked(appendHttp2Frame(nil, frameType, flags, streamID, payload))
+}
+
+// writeFramesLocked sends encoded frames, called with writeMu held.
+func (c *http2Conn) writeFramesLocked(frames []byte) error {
+	if len(frames) == 0 {
+		return nil
+	}
+	if _, err := c.writer.Write(frames); err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
+
+// writeHeaders sends a header block, split into CONTINUATION frames when it
+// does not fit a single frame.
+func (c *http2Conn) writeHeaders(streamID uint32, fields []hpackField, endStream bool) error {
+	block := c.encoder.Encode(nil, fields)
+
+	c.mu.Lock()
+	maxFrameSize := int(c.peerMaxFrameSize)
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	frameType := http2FrameHeaders
+	var flags uint8
+	if endStream {
+		flags = http2FlagEndStream
+	}
+	for {
+		fragment := block
+		if len(fragment) > maxFrameSize {
+			fragment = fragment[:maxFrameSize]
+		}
+		block = block[len(fragment):]
+		if len(block) == 0 {
+			flags |= http2FlagEndHeaders
+		}
+		if err := c.writeFrameLocked(frameType, flags, streamID, fragment); err != nil {
+			return err
+		}
+		if len(block) == 0 {
+			return nil
+		}
+		frameType, flags = http2FrameContinuation, 0
+	}
+}
+
+func (c *http2Conn) shutdown() {
+	c.mu.Lock()
+	c.closed = true
+	for _, stream := range c.streams {
+		c.removeStream(stream)
+	}
+	close(c.creditReady)
+	c.mu.Unlock()
+
+	c.conn.Close()
+	c.handlers.Wait()
+}
+
+func decodeHttp2SettingsHeader(value string) ([]byte, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(value), "="))
+	if err != nil || len(payload)%6 != 0 {
+		return nil, http2ConnError{http2ProtocolError, "invalid HTTP2-Settings"}
+	}
+	return payload, nil
+}
+
+// http2Body is the request body of a stream, filled by the connection
+// goroutine as DATA frames arrive.
+type http2Body struct {
+	conn   *http2Conn
+	stream *http2Stream
+}
+
+func (b *http2Body) Read(p []byte) (int, error) {
+	c, stream := b.conn, b.stream
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(stream.data) == 0 && stream.bodyErr == nil && !stream.bodyClosed && !c.closed {
+		c.cond.Wait()
+	}
+	if stream.bodyClosed {
+		return 0, httpcore.ErrBodyClosed
+	}
+	if len(stream.data) == 0 {
+		if stream.bodyErr != nil {
+			return 0, stream.bodyErr
+		}
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	n := copy(p, stream.data)
+	stream.data = stream.data[n:]
+	c.creditConnection(int64(n))
+	c.creditStream(stream, int64(n))
+	return n, nil
+}
+
+func (b *http2Body) Close() error {
+	c, stream := b.conn, b.stream
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stream.bodyClosed {
+		return nil
+	}
+	stream.bodyClosed = true
+	c.creditConnection(int64(len(stream.data)))
+	stream.data = nil
+	c.cond.Broadcast()
+	return nil
+}
+
+// http2ResponseStream implements httpcore.StreamWriter on top of a stream.
+type http2ResponseStream struct {
+	conn   *http2Conn
+	stream *http2Stream
+	noBody bool
+	// head drops the body of a response to HEAD
+	head bool
+}
+
+func (s *http2ResponseStream) WriteHead(status httpcore.HttpStatus, message string, headers []httpcore.HeaderField) error {
+	s.noBody = status < 200 || status == httpcore.StatusNoContent || status == httpcore.StatusNotModified
+	fields := make([]hpackField, 0, len(headers)+1)
+	fields = append(fields, hpackField{Name: ":status", Value: strconv.Itoa(int(status))})
+	fields = append(fields, http2HeaderFields(headers)...)
+
+	if err := s.alive(); err != nil {
+		return err
+	}
+	return s.conn.writeHeaders(s.stream.id, fields, false)
+}
+
+func (s *http2ResponseStream) WriteData(p []byte) error {
+	if s.noBody || s.head {
+		return nil
+	}
+	c, stream := s.conn, s.stream
+	for len(p) > 0 {
+		c.mu.Lock()
+		for !c.closed && !stream.reset && (c.sendWindow <= 0 || stream.sendWindow <= 0) {
+			c.cond.Wait()
+		}
+		if c.closed || stream.reset {
+			c.mu.Unlock()
+			return errHttp2StreamDone
+		}
+		n := int64(len(p))
+		n = min(n, c.sendWindow, stream.sendWindow, int64(c.peerMaxFrameSize))
+		c.sendWindow -= n
+		stream.sendWindow -= n
+		c.mu.Unlock()
+
+		if err := c.writeFrame(http2FrameData, 0, stream.id, p[:n]); err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+func (s *http2ResponseStream) Flush() error {
+	// frames are flushed as they are written
+	return s.alive()
+}
+
+func (s *http2ResponseStream) End(trailers []httpcore.HeaderField) error {
+	c, stream := s.conn, s.stream
+	if err := s.alive(); err != nil {
+		return err
+	}
+
+	var err error
+	if len(trailers) > 0 {
+		err = c.writeHeaders(stream.id, http2HeaderFields(trailers), true)
+	} else {
+		err = c.writeFrame(http2FrameData, http2FlagEndStream, stream.id, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	remoteClosed := stream.remoteClosed
+	if _, exists := c.streams[stream.id]; exists {
+		c.removeStream(stream)
+	}
+	c.mu.Unlock()
+
+	if !remoteClosed {
+		// the response is complete, the rest of the request is not needed
+		// (RFC 9113 section 8.1)
+		payload := binary.BigEndian.AppendUint32(nil, uint32(http2NoError))
+		return c.writeFrame(http2FrameRSTStream, 0, stream.id, payload)
+	}
+	return nil
+}
+
+// CloseNotify is closed when the stream is reset by the client, the
+// connection goes away or the response completed.
+func (s *http2ResponseStream) CloseNotify() <-chan struct{} {
+	return s.stream.done
+}
+
+func (s *http2ResponseStream) alive() error {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+	if s.conn.closed || s.stream.reset {
+		return errHttp2StreamDone
+	}
+	return nil
+}
+
+// http2HeaderFields lowercases header names and drops the connection
+// specific ones HTTP/2 does not allow.
+func http2HeaderFields(headers []httpcore.HeaderField) []hpackField {
+	fields := make([]hpackField, 0, len(headers))
+	for _, header := range headers {
+		name := strings.ToLower(header.Key)
+		switch name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			continue
+		}
+		fields = append(fields, hpackField{Name: name, Value: header.Value})
+	}
+	return fields
+}
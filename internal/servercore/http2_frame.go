@@ -0,0 +1,145 @@
+package servercore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+type http2FrameType uint8
+
+const (
+	http2FrameData         http2FrameType = 0x0
+	http2FrameHeaders      http2FrameType = 0x1
+	http2FramePriority     http2FrameType = 0x2
+	http2FrameRSTStream    http2FrameType = 0x3
+	http2FrameSettings     http2FrameType = 0x4
+	http2FramePushPromise  http2FrameType = 0x5
+	http2FramePing         http2FrameType = 0x6
+	http2FrameGoAway       http2FrameType = 0x7
+	http2FrameWindowUpdate http2FrameType = 0x8
+	http2FrameContinuation http2FrameType = 0x9
+)
+
+const (
+	http2FlagEndStream  uint8 = 0x1
+	http2FlagAck        uint8 = 0x1
+	http2FlagEndHeaders uint8 = 0x4
+	http2FlagPadded     uint8 = 0x8
+	http2FlagPriority   uint8 = 0x20
+)
+
+type http2SettingID uint16
+
+const (
+	http2SettingHeaderTableSize      http2SettingID = 0x1
+	http2SettingEnablePush           http2SettingID = 0x2
+	http2SettingMaxConcurrentStreams http2SettingID = 0x3
+	http2SettingInitialWindowSize    http2SettingID = 0x4
+	http2SettingMaxFrameSize         http2SettingID = 0x5
+	http2SettingMaxHeaderListSize    http2SettingID = 0x6
+)
+
+type http2ErrorCode uint32
+
+const (
+	http2NoError            http2ErrorCode = 0x0
+	http2ProtocolError      http2ErrorCode = 0x1
+	http2InternalError      http2ErrorCode = 0x2
+	http2FlowControlError   http2ErrorCode = 0x3
+	http2StreamClosed       http2ErrorCode = 0x5
+	http2FrameSizeError     http2ErrorCode = 0x6
+	http2RefusedStream      http2ErrorCode = 0x7
+	http2Cancel             http2ErrorCode = 0x8
+	http2CompressionError   http2ErrorCode = 0x9
+	http2EnhanceYourCalm    http2ErrorCode = 0xb
+	http2InadequateSecurity http2ErrorCode = 0xc
+)
+
+const (
+	http2DefaultWindowSize   = 65535
+	http2DefaultMaxFrameSize = 16384
+	http2MaxAllowedFrameSize = 1<<24 - 1
+	http2MaxWindowSize       = 1<<31 - 1
+)
+
+// http2ConnError ends the whole connection with a GOAWAY frame.
+type http2ConnError struct {
+	Code   http2ErrorCode
+	Reason string
+}
+
+func (e http2ConnError) Error() string {
+	return fmt.Sprintf("http2 connection error %d: %s", e.Code, e.Reason)
+}
+
+// http2StreamError only resets the stream it happened on.
+type http2StreamError struct {
+	StreamID uint32
+	Code     http2ErrorCode
+}
+
+func (e http2StreamError) Error() string {
+	return fmt.Sprintf("http2 stream %d error %d", e.StreamID, e.Code)
+}
+
+type http2Frame struct {
+	Type     http2FrameType
+	Flags    uint8
+	StreamID uint32
+	Payload  []byte
+}
+
+func (f http2Frame) Has(flag uint8) bool {
+	return f.Flags&flag != 0
+}
+
+func readHttp2Frame(reader io.Reader, maxFrameSize uint32) (http2Frame, error) {
+	var header [9]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return http2Frame{}, err
+	}
+
+	length := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+	frame := http2Frame{
+		Type:     http2FrameType(header[3]),
+		Flags:    header[4],
+		StreamID: binary.BigEndian.Uint32(header[5:]) & 0x7fffffff,
+	}
+	if length > maxFrameSize {
+		return frame, http2ConnError{http2FrameSizeError, "frame larger than SETTINGS_MAX_FRAME_SIZE"}
+	}
+
+	frame.Payload = make([]byte, length)
+	if _, err := io.ReadFull(reader, frame.Payload); err != nil {
+		return frame, err
+	}
+	return frame, nil
+}
+
+func appendHttp2Frame(dst []byte, frameType http2FrameType, flags uint8, streamID uint32, payload []byte) []byte {
+	length := len(payload)
+	dst = append(dst, byte(length>>16), byte(length>>8), byte(length), byte(frameType), flags)
+	dst = binary.BigEndian.AppendUint32(dst, streamID&0x7fffffff)
+	return append(dst, payload...)
+}
+
+// stripPadding removes the pad length field and the padding of DATA and
+// HEADERS frames.
+func (f http2Frame) stripPadding() ([]byte, error) {
+	payload := f.Payload
+	if !f.Has(http2FlagPadded) {
+		return payload, nil
+	}
+	if len(payload) == 0 {
+		return nil, http2ConnError{http2ProtocolError, "missing pad length"}
+	}
+	padLength := int(payload[0])
+	payload = payload[1:]
+	if padLength > len(payload) {
+		return nil, http2ConnError{http2ProtocolError, "padding longer than payload"}
+	}
+	return payload[:len(payload)-padLength], nil
+}
@@ -0,0 +1,317 @@
+package servercore
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	errHpackIndex      = errors.New("hpack: invalid table index")
+	errHpackInteger    = errors.New("hpack: integer overflow")
+	errHpackTruncated  = errors.New("hpack: truncated header block")
+	errHpackHuffman    = errors.New("hpack: invalid huffman code")
+	errHpackTableSize  = errors.New("hpack: dynamic table size update too large")
+	errHpackListLength = errors.New("hpack: header list too large")
+)
+
+type hpackField struct {
+	Name  string
+	Value string
+}
+
+func (f hpackField) size() int {
+	// RFC 7541 section 4.1
+	return len(f.Name) + len(f.Value) + 32
+}
+
+// hpackDecoder keeps the dynamic table of one direction of a connection,
+// header blocks have to be decoded in the order they were received.
+type hpackDecoder struct {
+	dynamic      []hpackField // newest first
+	size         int
+	maxSize      int
+	allowedSize  int
+	maxListBytes int
+}
+
+func newHpackDecoder(maxTableSize int, maxListBytes int) *hpackDecoder {
+	return &hpackDecoder{maxSize: maxTableSize, allowedSize: maxTableSize, maxListBytes: maxListBytes}
+}
+
+func (d *hpackDecoder) Decode(block []byte) ([]hpackField, error) {
+	fields := make([]hpackField, 0)
+	listBytes := 0
+
+	for len(block) > 0 {
+		var field hpackField
+		var err error
+		b := block[0]
+
+		switch {
+		case b&0x80 != 0:
+			// indexed header field
+			var index uint64
+			index, block, err = decodeHpackInteger(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			field, err = d.at(index)
+			if err != nil {
+				return nil, err
+			}
+		case b&0xc0 == 0x40:
+			// literal with incremental indexing
+			field, block, err = d.decodeLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.add(field)
+		case b&0xe0 == 0x20:
+			// dynamic table size update
+			var size uint64
+			size, block, err = decodeHpackInteger(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.allowedSize) {
+				return nil, errHpackTableSize
+			}
+			d.maxSize = int(size)
+			d.evict()
+			continue
+		default:
+			// literal without indexing or never indexed
+			field, block, err = d.decodeLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		listBytes += field.size()
+		if listBytes > d.maxListBytes {
+			return nil, errHpackListLength
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+func (d *hpackDecoder) decodeLiteral(block []byte, prefix uint8) (hpackField, []byte, error) {
+	var field hpackField
+	index, block, err := decodeHpackInteger(block, prefix)
+	if err != nil {
+		return field, nil, err
+	}
+
+	if index == 0 {
+		field.Name, block, err = decodeHpackString(block)
+		if err != nil {
+			return field, nil, err
+		}
+	} else {
+		named, err := d.at(index)
+		if err != nil {
+			return field, nil, err
+		}
+		field.Name = named.Name
+	}
+
+	field.Value, block, err = decodeHpackString(block)
+	return field, block, err
+}
+
+func (d *hpackDecoder) at(index uint64) (hpackField, error) {
+	if index == 0 {
+		return hpackField{}, errHpackIndex
+	}
+	if index <= uint64(len(hpackStaticTable)) {
+		return hpackStaticTable[index-1], nil
+	}
+	index -= uint64(len(hpackStaticTable)) + 1
+	if index >= uint64(len(d.dynamic)) {
+		return hpackField{}, errHpackIndex
+	}
+	return d.dynamic[index], nil
+}
+
+func (d *hpackDecoder) add(field hpackField) {
+	if field.size() > d.maxSize {
+		// an entry larger than the table empties it (RFC 7541 section 4.4)
+		d.dynamic = d.dynamic[:0]
+		d.size = 0
+		return
+	}
+	d.dynamic = append([]hpackField{field}, d.dynamic...)
+	d.size += field.size()
+	d.evict()
+}
+
+func (d *hpackDecoder) evict() {
+	for d.size > d.maxSize && len(d.dynamic) > 0 {
+		last := d.dynamic[len(d.dynamic)-1]
+		d.dynamic = d.dynamic[:len(d.dynamic)-1]
+		d.size -= last.size()
+	}
+}
+
+func decodeHpackInteger(block []byte, prefix uint8) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, errHpackTruncated
+	}
+	mask := uint64(1)<<prefix - 1
+	value := uint64(block[0]) & mask
+	block = block[1:]
+	if value < mask {
+		return value, block, nil
+	}
+
+	var shift uint
+	for {
+		if len(block) == 0 {
+			return 0, nil, errHpackTruncated
+		}
+		b := block[0]
+		block = block[1:]
+		value += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value, block, nil
+		}
+		shift += 7
+		if shift > 56 {
+			return 0, nil, errHpackInteger
+		}
+	}
+}
+
+func decodeHpackString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, errHpackTruncated
+	}
+	huffman := block[0]&0x80 != 0
+	length, block, err := decodeHpackInteger(block, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(block)) < length {
+		return "", nil, errHpackTruncated
+	}
+
+	raw, rest := block[:length], block[length:]
+	if !huffman {
+		return string(raw), rest, nil
+	}
+	value, err := decodeHuffman(raw)
+	return value, rest, err
+}
+
+type huffmanNode struct {
+	children [2]*huffmanNode
+	symbol   int
+}
+
+var (
+	huffmanTreeOnce sync.Once
+	huffmanTree     *huffmanNode
+)
+
+func buildHuffmanTree() {
+	huffmanTree = &huffmanNode{symbol: -1}
+	for symbol, code := range hpackHuffmanCodes {
+		node := huffmanTree
+		length := hpackHuffmanCodeLengths[symbol]
+		for bit := int(length) - 1; bit >= 0; bit-- {
+			b := (code >> uint(bit)) & 1
+			if node.children[b] == nil {
+				node.children[b] = &huffmanNode{symbol: -1}
+			}
+			node = node.children[b]
+		}
+		node.symbol = symbol
+	}
+}
+
+func decodeHuffman(raw []byte) (string, error) {
+	huffmanTreeOnce.Do(buildHuffmanTree)
+
+	var out strings.Builder
+	node := huffmanTree
+	depth, allOnes := 0, true
+	for _, b := range raw {
+		for bit := 7; bit >= 0; bit-- {
+			v := (b >> uint(bit)) & 1
+			node = node.children[v]
+			if node == nil {
+				// only the end of string symbol is missing from the tree
+				return "", errHpackHuffman
+			}
+			depth++
+			allOnes = allOnes && v == 1
+			if node.symbol >= 0 {
+				out.WriteByte(byte(node.symbol))
+				node, depth, allOnes = huffmanTree, 0, true
+			}
+		}
+	}
+
+	// padding has to be a prefix of the end of string symbol, at most 7 bits
+	if depth > 7 || !allOnes {
+		return "", errHpackHuffman
+	}
+	return out.String(), nil
+}
+
+// hpackEncoder never adds to the dynamic table, so it needs no state shared
+// with the peer and the table size the peer allows does not matter.
+type hpackEncoder struct{}
+
+func (hpackEncoder) Encode(dst []byte, fields []hpackField) []byte {
+	for _, field := range fields {
+		nameIndex := 0
+		for idx, entry := range hpackStaticTable {
+			if entry.Name != field.Name {
+				continue
+			}
+			if entry.Value == field.Value {
+				nameIndex = -(idx + 1)
+				break
+			}
+			if nameIndex == 0 {
+				nameIndex = idx + 1
+			}
+		}
+
+		if nameIndex < 0 {
+			dst = appendHpackInteger(dst, 0x80, 7, uint64(-nameIndex))
+			continue
+		}
+
+		// literal header field without indexing
+		dst = appendHpackInteger(dst, 0x00, 4, uint64(nameIndex))
+		if nameIndex == 0 {
+			dst = appendHpackString(dst, field.Name)
+		}
+		dst = appendHpackString(dst, field.Value)
+	}
+	return dst
+}
+
+func appendHpackInteger(dst []byte, flags byte, prefix uint8, value uint64) []byte {
+	mask := uint64(1)<<prefix - 1
+	if value < mask {
+		return append(dst, flags|byte(value))
+	}
+	dst = append(dst, flags|byte(mask))
+	value -= mask
+	for value >= 0x80 {
+		dst = append(dst, byte(value&0x7f)|0x80)
+		value >>= 7
+	}
+	return append(dst, byte(value))
+}
+
+func appendHpackString(dst []byte, value string) []byte {
+	dst = appendHpackInteger(dst, 0x00, 7, uint64(len(value)))
+	return append(dst, value...)
+}
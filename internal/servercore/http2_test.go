@@ -0,0 +1,510 @@
+package servercore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+	"github.com/codecrafters-io/http-server-starter-go/internal/router"
+)
+
+func TestHpackDecoder(t *testing.T) {
+	// RFC 7541 Appendix C.4, requests with Huffman coding sharing a table
+	blocks := []struct {
+		Hex    string
+		Fields []hpackField
+	}{
+		{
+			Hex: "828684418cf1e3c2e5f23a6ba0ab90f4ff",
+			Fields: []hpackField{
+				{Name: ":method", Value: "GET"},
+				{Name: ":scheme", Value: "http"},
+				{Name: ":path", Value: "/"},
+				{Name: ":authority", Value: "www.example.com"},
+			},
+		},
+		{
+			Hex: "828684be5886a8eb10649cbf",
+			Fields: []hpackField{
+				{Name: ":method", Value: "GET"},
+				{Name: ":scheme", Value: "http"},
+				{Name: ":path", Value: "/"},
+				{Name: ":authority", Value: "www.example.com"},
+				{Name: "cache-control", Value: "no-cache"},
+			},
+		},
+		{
+			Hex: "828785bf408825a849e95ba97d7f8925a849e95bb8e8b4bf",
+			Fields: []hpackField{
+				{Name: ":method", Value: "GET"},
+				{Name: ":scheme", Value: "https"},
+				{Name: ":path", Value: "/index.html"},
+				{Name: ":authority", Value: "www.example.com"},
+				{Name: "custom-key", Value: "custom-value"},
+			},
+		},
+	}
+
+	decoder := newHpackDecoder(4096, 1<<20)
+	for idx, block := range blocks {
+		raw, _ := hex.DecodeString(block.Hex)
+		fields, err := decoder.Decode(raw)
+		if err != nil {
+			t.Fatalf("[ block %d ]unexpected error %v", idx, err)
+		}
+		if len(fields) != len(block.Fields) {
+			t.Fatalf("[ block %d ]expected %v, got %v", idx, block.Fields, fields)
+		}
+		for i := range fields {
+			if fields[i] != block.Fields[i] {
+				t.Errorf("[ block %d ]expected %v, got %v", idx, block.Fields[i], fields[i])
+			}
+		}
+	}
+	if decoder.size != 164 {
+		t.Errorf("expected dynamic table size 164, got %d", decoder.size)
+	}
+}
+
+func TestHpackRoundTrip(t *testing.T) {
+	fields := []hpackField{
+		{Name: ":status", Value: "200"},
+		{Name: ":status", Value: "201"},
+		{Name: "content-type", Value: "text/plain"},
+		{Name: "x-custom", Value: strings.Repeat("v", 300)},
+	}
+	block := hpackEncoder{}.Encode(nil, fields)
+
+	decoded, err := newHpackDecoder(4096, 1<<20).Decode(block)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for i := range fields {
+		if decoded[i] != fields[i] {
+			t.Errorf("expected %v, got %v", fields[i], decoded[i])
+		}
+	}
+
+	if _, err := newHpackDecoder(4096, 1<<20).Decode([]byte{0xff, 0xff}); err == nil {
+		t.Errorf("expected error for truncated integer")
+	}
+	if _, err := newHpackDecoder(4096, 1<<20).Decode([]byte{0xbe}); err == nil {
+		t.Errorf("expected error for index outside the tables")
+	}
+}
+
+func TestHttp2PriorKnowledge(t *testing.T) {
+	appRouter := router.NewRouter()
+	appRouter.Get("/echo/:str", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetHeader("Content-Type", "text/plain")
+		w.Write([]byte(r.PathParams["str"]))
+	})
+	appRouter.Post("/upload", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.SetStatus(httpcore.StatusBadRequest)
+			return
+		}
+		w.SetStatus(httpcore.StatusCreated)
+		w.Write(body)
+	})
+	appRouter.Get("/stream", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetTrailer("Checksum", "")
+		for range 100 {
+			w.BodyWriter().Write(bytes.Repeat([]byte("x"), 4096))
+		}
+		w.SetTrailer("Checksum", "done")
+	})
+	server := NewHttpServer(appRouter)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handleRequests(conn)
+		}
+	}()
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	defer client.CloseIdleConnections()
+	base := "http://" + listener.Addr().String()
+
+	var wg sync.WaitGroup
+	for _, word := range []string{"one", "two", "three", "four"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := client.Get(base + "/echo/" + word)
+			if err != nil {
+				t.Errorf("unexpected error %v", err)
+				return
+			}
+			defer response.Body.Close()
+			body, _ := io.ReadAll(response.Body)
+			if response.ProtoMajor != 2 || string(body) != word {
+				t.Errorf("expected %s over HTTP/2, got %q over %s", word, body, response.Proto)
+			}
+		}()
+	}
+	wg.Wait()
+
+	upload := bytes.Repeat([]byte("0123456789"), 20000)
+	response, err := client.Post(base+"/upload", "application/octet-stream", bytes.NewReader(upload))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != 201 || !bytes.Equal(body, upload) {
+		t.Errorf("upload was not echoed back, status %d and %d bytes", response.StatusCode, len(body))
+	}
+
+	response, err = client.Get(base + "/stream")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	body, _ = io.ReadAll(response.Body)
+	response.Body.Close()
+	if len(body) != 100*4096 || response.Trailer.Get("Checksum") != "done" {
+		t.Errorf("unexpected streamed response of %d bytes with trailers %v", len(body), response.Trailer)
+	}
+
+	response, err = client.Get(base + "/missing")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != 404 {
+		t.Errorf("expected 404, got %d", response.StatusCode)
+	}
+}
+
+func TestHttp2WindowUpdates(t *testing.T) {
+	const frames, frameSize = 8, 16384
+	read, release := make(chan struct{}), make(chan struct{})
+	var releaseOnce sync.Once
+	defer releaseOnce.Do(func() { close(release) })
+	appRouter := router.NewRouter()
+	appRouter.Post("/upload", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		io.ReadFull(r.Body, make([]byte, frames*frameSize))
+		close(read)
+		<-release
+		w.SetStatus(httpcore.StatusNoContent)
+	})
+	server := NewHttpServer(appRouter)
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go server.handleRequests(conn)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	received := make(chan http2Frame)
+	go func() {
+		defer close(received)
+		for {
+			frame, err := readHttp2Frame(client, http2DefaultMaxFrameSize)
+			if err != nil {
+				return
+			}
+			received <- frame
+		}
+	}()
+
+	request := []byte(http2Preface)
+	request = appendHttp2Frame(request, http2FrameSettings, 0, 0, nil)
+	block := hpackEncoder{}.Encode(nil, []hpackField{
+		{Name: ":method", Value: "POST"}, {Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/upload"}, {Name: ":authority", Value: "localhost"},
+	})
+	request = appendHttp2Frame(request, http2FrameHeaders, http2FlagEndHeaders, 1, block)
+	// the whole default window of 64KiB minus one byte, the rest waits for
+	// credit
+	for range 3 {
+		request = appendHttp2Frame(request, http2FrameData, 0, 1, make([]byte, frameSize))
+	}
+	request = appendHttp2Frame(request, http2FrameData, 0, 1, make([]byte, frameSize-1))
+	go client.Write(request)
+
+	credits := map[uint32]int64{}
+	sent := int64(4*frameSize - 1)
+	for sent < frames*frameSize {
+		frame, ok := <-received
+		if !ok {
+			t.Fatalf("connection closed after %d bytes", sent)
+		}
+		if frame.Type != http2FrameWindowUpdate {
+			continue
+		}
+		credits[frame.StreamID] += int64(binary.BigEndian.Uint32(frame.Payload))
+		// a credit on both windows lets the next bytes through
+		for n := min(credits[0], credits[1], frames*frameSize-sent, frameSize); n > 0; n = min(credits[0], credits[1], frames*frameSize-sent, frameSize) {
+			credits[0] -= n
+			credits[1] -= n
+			sent += n
+			go client.Write(appendHttp2Frame(nil, http2FrameData, 0, 1, make([]byte, n)))
+		}
+	}
+	<-read
+
+	// every byte read by the handler is credited back on both windows
+	total := map[uint32]int64{0: credits[0], 1: credits[1]}
+	for total[0] < frameSize || total[1] < frameSize {
+		frame, ok := <-received
+		if !ok {
+			t.Fatalf("connection closed with credits %v", total)
+		}
+		if frame.Type == http2FrameWindowUpdate {
+			total[frame.StreamID] += int64(binary.BigEndian.Uint32(frame.Payload))
+		}
+	}
+
+	// a connection error ends with GOAWAY, the stream it names as the last
+	// one is still answered before the connection closes
+	go client.Write(appendHttp2Frame(nil, http2FrameData, 0, 0, []byte("x")))
+	for frame := range received {
+		if frame.Type != http2FrameGoAway {
+			continue
+		}
+		if lastStreamID := binary.BigEndian.Uint32(frame.Payload); lastStreamID != 1 {
+			t.Errorf("expected GOAWAY for streams up to 1, got %d", lastStreamID)
+		}
+		break
+	}
+	releaseOnce.Do(func() { close(release) })
+	answered := false
+	for frame := range received {
+		answered = answered || frame.Type == http2FrameHeaders && frame.StreamID == 1
+	}
+	if !answered {
+		t.Errorf("expected the response on stream 1 after GOAWAY")
+	}
+}
+
+func TestHttp2GoAwayDrains(t *testing.T) {
+	appRouter := router.NewRouter()
+	appRouter.Post("/upload", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.SetStatus(httpcore.StatusBadRequest)
+			return
+		}
+		w.Write(body)
+	})
+	server := NewHttpServer(appRouter)
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go server.handleRequests(conn)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	encoder := hpackEncoder{}
+	upload := func(streamID uint32) []byte {
+		return appendHttp2Frame(nil, http2FrameHeaders, http2FlagEndHeaders, streamID, encoder.Encode(nil, []hpackField{
+			{Name: ":method", Value: "POST"}, {Name: ":scheme", Value: "http"},
+			{Name: ":path", Value: "/upload"}, {Name: ":authority", Value: "localhost"},
+		}))
+	}
+	request := []byte(http2Preface)
+	request = appendHttp2Frame(request, http2FrameSettings, 0, 0, nil)
+	request = append(request, upload(1)...)
+	request = appendHttp2Frame(request, http2FrameData, 0, 1, []byte("hello "))
+	// PING on a stream is a connection error, the body of stream 1 is not
+	// complete yet
+	request = appendHttp2Frame(request, http2FramePing, 0, 1, make([]byte, 8))
+	request = appendHttp2Frame(request, http2FrameData, http2FlagEndStream, 1, []byte("world"))
+	// a stream opened after GOAWAY is ignored
+	request = append(request, upload(3)...)
+	request = appendHttp2Frame(request, http2FrameData, http2FlagEndStream, 3, []byte("late"))
+	go client.Write(request)
+
+	var goAway, status, body string
+	decoder := newHpackDecoder(http2HeaderTableSize, http2MaxHeaderListSize)
+	for {
+		frame, err := readHttp2Frame(client, http2DefaultMaxFrameSize)
+		if err != nil {
+			break
+		}
+		if frame.StreamID == 3 {
+			t.Errorf("unexpected frame %d on a stream opened after GOAWAY", frame.Type)
+		}
+		switch frame.Type {
+		case http2FrameGoAway:
+			goAway = fmt.Sprintf("%d %d", binary.BigEndian.Uint32(frame.Payload), binary.BigEndian.Uint32(frame.Payload[4:]))
+		case http2FrameHeaders:
+			fields, err := decoder.Decode(frame.Payload)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if goAway == "" {
+				t.Errorf("expected the response after GOAWAY")
+			}
+			status = fields[0].Value
+		case http2FrameData:
+			body += string(frame.Payload)
+		}
+	}
+
+	if goAway != fmt.Sprintf("1 %d", http2ProtocolError) {
+		t.Errorf("expected GOAWAY for streams up to 1 with PROTOCOL_ERROR, got %q", goAway)
+	}
+	if status != "200" || body != "hello world" {
+		t.Errorf("expected the whole upload echoed back, got %s %q", status, body)
+	}
+}
+
+func TestHttp2Upgrade(t *testing.T) {
+	appRouter := router.NewRouter()
+	appRouter.Get("/echo/:str", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetHeader("Content-Type", "text/plain")
+		w.Write([]byte(r.PathParams["str"]))
+	})
+	server := NewHttpServer(appRouter)
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go server.handleRequests(conn)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// the upgraded request becomes stream 1, its settings apply right away
+	settings := binary.BigEndian.AppendUint16(nil, uint16(http2SettingMaxFrameSize))
+	settings = binary.BigEndian.AppendUint32(settings, 1<<15)
+	go client.Write([]byte("GET /echo/upgraded HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\nHTTP2-Settings: " + base64.RawURLEncoding.EncodeToString(settings) + "\r\n\r\n"))
+
+	reader := bufio.NewReader(client)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if response.StatusCode != 101 || response.Header.Get("Upgrade") != "h2c" {
+		t.Fatalf("expected 101 switching to h2c, got %d %q", response.StatusCode, response.Header.Get("Upgrade"))
+	}
+
+	request := []byte(http2Preface)
+	request = appendHttp2Frame(request, http2FrameSettings, 0, 0, nil)
+	request = appendHttp2Frame(request, http2FrameHeaders, http2FlagEndHeaders|http2FlagEndStream, 3, hpackEncoder{}.Encode(nil, []hpackField{
+		{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/echo/next"}, {Name: ":authority", Value: "localhost"},
+	}))
+	go client.Write(request)
+
+	bodies := map[uint32]string{}
+	ended := 0
+	decoder := newHpackDecoder(http2HeaderTableSize, http2MaxHeaderListSize)
+	for ended < 2 {
+		frame, err := readHttp2Frame(reader, http2DefaultMaxFrameSize)
+		if err != nil {
+			t.Fatalf("unexpected error %v with responses %v", err, bodies)
+		}
+		switch frame.Type {
+		case http2FrameHeaders:
+			fields, err := decoder.Decode(frame.Payload)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if fields[0].Value != "200" {
+				t.Errorf("[ stream %d ]expected status 200, got %s", frame.StreamID, fields[0].Value)
+			}
+		case http2FrameData:
+			bodies[frame.StreamID] += string(frame.Payload)
+		}
+		if frame.StreamID != 0 && frame.Has(http2FlagEndStream) {
+			ended++
+		}
+	}
+
+	if bodies[1] != "upgraded" || bodies[3] != "next" {
+		t.Errorf("expected the upgraded request on stream 1 and the next on stream 3, got %v", bodies)
+	}
+}
+
+func TestHttp2OverTLS(t *testing.T) {
+	appRouter := router.NewRouter()
+	appRouter.Get("/protocol", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		if r.TLS == nil {
+			w.SetStatus(httpcore.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(r.TLS.NegotiatedProtocol))
+	})
+
+	certificate, err := GenerateSelfSignedCertificate("localhost", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	store, _ := NewCertificateStore()
+	store.Add(certificate)
+	config, err := TLSOptions{Certificates: store}.config()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer listener.Close()
+	tlsListener := tls.NewListener(listener, config)
+	server := NewHttpServer(appRouter)
+	go func() {
+		for {
+			conn, err := tlsListener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handleRequests(conn)
+		}
+	}()
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	for _, tc := range []struct {
+		Name     string
+		HTTP2    bool
+		Expected string
+	}{
+		{Name: "h2 by ALPN", HTTP2: true, Expected: "h2"},
+		{Name: "http/1.1 by ALPN", Expected: "http/1.1"},
+	} {
+		transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}, ForceAttemptHTTP2: tc.HTTP2}
+		if !tc.HTTP2 {
+			transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		}
+		client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+		response, err := client.Get("https://" + listener.Addr().String() + "/protocol")
+		if err != nil {
+			t.Fatalf("[ %s ]unexpected error %v", tc.Name, err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		client.CloseIdleConnections()
+		if response.ProtoMajor == 2 != tc.HTTP2 || string(body) != tc.Expected {
+			t.Errorf("[ %s ]expected %q, got %q over %s", tc.Name, tc.Expected, body, response.Proto)
+		}
+	}
+}
@@ -2,40 +2,110 @@ package servercore
 
 import (
 	"bufio"
-	"bytes"
-	"compress/gzip"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/signal"
-	"strings"
 	"sync"
 	"syscall"
-	"unicode"
+	"time"
 
+	"github.com/codecrafters-io/http-server-starter-go/internal/common"
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression"
 	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
 	"github.com/codecrafters-io/http-server-starter-go/internal/router"
 )
 
 type HttpServer struct {
-	router router.ReadOnlyRouter
+	router   router.Resolver
+	encoders *compression.Registry
+	policy   compression.Policy
 }
 
 func NewHttpServer(appRouter router.IRouter) HttpServer {
 	router := router.Router{}
 	router.CopyPath(appRouter)
 	return HttpServer{
-		router: &router,
+		router:   &router,
+		encoders: compression.NewRegistry(),
+		policy:   compression.DefaultPolicy(),
 	}
 }
 
+// NewVirtualHostServer returns a server picking the router by the Host
+// header of each request.
+func NewVirtualHostServer(hosts *router.HostRouter) HttpServer {
+	return HttpServer{
+		router:   hosts,
+		encoders: compression.NewRegistry(),
+		policy:   compression.DefaultPolicy(),
+	}
+}
+
+// Encoders returns the codings responses are compressed with, br, zstd,
+// gzip and deflate out of the box. The application registers others on it.
+func (h HttpServer) Encoders() *compression.Registry {
+	return h.encoders
+}
+
+// SetCompressionPolicy replaces compression.DefaultPolicy, which decides
+// the responses worth compressing.
+func (h *HttpServer) SetCompressionPolicy(policy compression.Policy) {
+	h.policy = policy
+}
+
 func (h *HttpServer) Listen(port uint) error {
 	l, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
 	if err != nil {
 		return err
 	}
 
+	return h.serve(l)
+}
+
+// ListenTLS serves HTTPS on port. The certificates are reloaded from disk
+// when the process receives SIGHUP.
+func (h *HttpServer) ListenTLS(port uint, options TLSOptions) error {
+	config, err := options.config()
+	if err != nil {
+		return err
+	}
+
+	l, err := tls.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port), config)
+	if err != nil {
+		return err
+	}
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	// signal.Stop does not close reload, done ends the reloader with serve
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			select {
+			case <-reload:
+			case <-done:
+				return
+			}
+			if err := options.Certificates.Reload(); err != nil {
+				fmt.Printf("[ERROR] certificate reload failed, keeping the current ones: %v\n", err)
+				continue
+			}
+			fmt.Println("Certificates reloaded")
+		}
+	}()
+
+	return h.serve(l)
+}
+
+func (h *HttpServer) serve(l net.Listener) error {
 	connChan := make(chan net.Conn, 3) // async data passing
 	stop := make(chan os.Signal, 1)
 
@@ -80,15 +150,40 @@ func (h *HttpServer) listenOverLoop(ln net.Listener, connChan chan<- net.Conn) {
 
 func (h *HttpServer) handleRequests(conn net.Conn) {
 	defer conn.Close()
+
+	var tlsState *tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// handshake up front so the peer certificates are known before the
+		// first request is handled
+		if err := tlsConn.Handshake(); err != nil {
+			fmt.Printf("TLS handshake failed %v\n", err)
+			return
+		}
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+	}
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	if tlsState != nil && tlsState.NegotiatedProtocol == "h2" || tlsState == nil && isHttp2Preface(reader) {
+		newHttp2Conn(h, conn, reader, writer, tlsState).serve(nil)
+		return
+	}
+
 	closeConnection := false
 	for {
-		request, err := httpcore.ParseRequest(bufio.NewReader(conn))
+		request, err := httpcore.ParseRequest(reader)
 		if err != nil {
-			if err == io.EOF {
+			if errors.Is(err, io.EOF) {
 				break
 			}
 			errorResult := httpcore.NewHttpResponseWriter()
-			errorResult.SetStatus(httpcore.StatusBadRequest)
+			if errors.Is(err, httpcore.ErrUnsupportedTransferEncoding) {
+				errorResult.SetStatus(httpcore.StatusNotImplemented)
+			} else {
+				errorResult.SetStatus(httpcore.StatusBadRequest)
+			}
 			if _, err := conn.Write(errorResult.ToResponseByte()); err != nil {
 				fmt.Printf("Error writing to the connection %v", err)
 			}
@@ -96,89 +191,81 @@ func (h *HttpServer) handleRequests(conn net.Conn) {
 
 		}
 
-		acceptedEncoding, existsAcceptedEncoding := request.Headers["accept-encoding"]
-
-		handlers, pathParams := h.router.GetHandlers(request.Method, request.Path)
-		// fmt.Println(handlers == nil, pathParams)
-
-		if handlers == nil {
-			errorResult := httpcore.NewHttpResponseWriter()
-			errorResult.SetStatus(httpcore.StatusNotFound)
-			if existsAcceptedEncoding && acceptedEncoding == "gzip" {
-				errorResult.SetHeader("Content-Encoding", acceptedEncoding)
-			}
-			if _, err := conn.Write(errorResult.ToResponseByte()); err != nil {
-				fmt.Printf("Error writing to the connection %v", err)
-				break
+		if tlsState == nil && isH2cUpgrade(request) {
+			writer.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+			if err := writer.Flush(); err != nil {
+				return
 			}
+			newHttp2Conn(h, conn, reader, writer, nil).serve(request)
 			return
 		}
 
-		request.PathParams = pathParams
-		response := httpcore.NewHttpResponseWriter()
+		request.TLS = tlsState
+		stream := httpcore.NewHttp1StreamWriter(writer).WithConn(conn, reader).WithBody(request.Body)
+		if request.Method == common.HEAD {
+			stream.WithoutBody()
+		}
+		response := httpcore.NewStreamingResponseWriter(stream)
+
+		// set before running the handlers, a streaming handler sends the
+		// headers as soon as it starts writing the body
+		_, connectionHeader := request.Headers.Lookup("connection")
+		if connectionHeader {
+			response.SetHeader("Connection", "close")
+			closeConnection = true
 
-		for _, handler := range handlers {
-			handler(*request, &response)
-			if response.IsReadyForResponse() {
-				break
-			}
 		}
 
-		if !response.IsReadyForResponse() || !response.IsStatusSet() {
-			response.SetStatus(httpcore.StatusOK)
+		found := h.dispatch(request, &response)
+		if response.IsHijacked() {
+			// the handler was done with the connection when it returned
+			return
 		}
 
-		handleEncoding(*request, &response)
-		_, connectionHeader := request.Headers["connection"]
-		if connectionHeader {
+		// whatever the handlers left unread has to go before the next
+		// request can be parsed from the same reader
+		if err := request.Body.Close(); err != nil {
 			response.SetHeader("Connection", "close")
 			closeConnection = true
-
 		}
 
-		if _, err := conn.Write(response.ToResponseByte()); err != nil {
+		if err := response.Finish(); err != nil {
 			fmt.Printf("Error writing the response %v", err)
 			break
 		}
 
-		if closeConnection {
+		// a handler watching for disconnects still reads from the connection
+		if closeConnection || !found || !stream.Reusable() {
 			break
 		}
 	}
 }
 
-func handleEncoding(r httpcore.Request, w *httpcore.HttpResponseWriter) {
-	fmt.Println("Called in handlers")
-	accepted, exists := r.Headers["accept-encoding"]
-	if !exists {
-		return
+// dispatch runs the handler registered for request. Buffered responses
+// get their default status, the body is encoded on its way out. Sending
+// the response is left to the caller. It reports whether a route matched.
+func (h *HttpServer) dispatch(request *httpcore.Request, response *httpcore.HttpResponseWriter) bool {
+	response.SetHeader("Date", time.Now().UTC().Format(httpcore.TimeFormat))
+	if h.encoders != nil {
+		response.WrapStream(func(next httpcore.StreamWriter) httpcore.StreamWriter {
+			return h.newEncodingStream(next, *request, response)
+		})
 	}
+	handler, pathParams := h.router.Resolve(request.Headers.Get("host"), request.Method, request.RawPath)
 
-	encodings := strings.Split(accepted, ", ")
-	canCompress := false
-	for _, encoding := range encodings {
-		if strings.TrimFunc(encoding, unicode.IsSpace) == "gzip" {
-			canCompress = true
-			break
-		}
+	if handler == nil {
+		response.SetStatus(httpcore.StatusNotFound)
+		return false
 	}
 
-	if canCompress {
-		var buf bytes.Buffer
-		zw := gzip.NewWriter(&buf)
+	request.PathParams = pathParams
 
-		if _, err := zw.Write(w.Body); err != nil {
-			return
-		}
+	handler(*request, response)
 
-		if err := zw.Close(); err != nil {
-			fmt.Println("Error >>>", err)
-			return
+	if !response.IsStreaming() && !response.IsHijacked() {
+		if !response.IsReadyForResponse() || !response.IsStatusSet() {
+			response.SetStatus(httpcore.StatusOK)
 		}
-
-		compressedBody := buf.Bytes()
-		w.SetHeader("Content-Encoding", "gzip")
-		w.Write(compressedBody)
-
 	}
+	return true
 }
@@ -0,0 +1,340 @@
+package servercore
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression"
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression/brotli"
+	"github.com/codecrafters-io/http-server-starter-go/internal/compression/zstd"
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+	"github.com/codecrafters-io/http-server-starter-go/internal/router"
+)
+
+// startServer runs server on a local port until the test ends.
+func startServer(t *testing.T, server HttpServer) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handleRequests(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestHeadFromGet(t *testing.T) {
+	appRouter := router.NewRouter()
+	appRouter.Get("/buffered", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetHeader("Content-Type", "text/plain")
+		w.Write([]byte("hello world"))
+	})
+	appRouter.Get("/large", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetHeader("Content-Type", "text/plain")
+		w.Write(bytes.Repeat([]byte("hello world "), 40))
+	})
+	appRouter.Get("/sized", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetHeader("Content-Length", "8192")
+		w.BodyWriter().Write(bytes.Repeat([]byte("s"), 8192))
+	})
+	appRouter.Get("/chunked", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.BodyWriter().Write([]byte("part one"))
+		w.Flush()
+		w.BodyWriter().Write([]byte("part two"))
+	})
+	appRouter.Head("/explicit", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetHeader("X-Explicit", "yes")
+	})
+	appRouter.Get("/explicit", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.Write([]byte("get"))
+	})
+	addr := startServer(t, NewHttpServer(appRouter))
+
+	testCases := []struct {
+		Name           string
+		Path           string
+		AcceptEncoding string
+		Headers        []string
+	}{
+		{Name: "buffered", Path: "/buffered", Headers: []string{"Content-Length: 11", "Content-Type: text/plain"}},
+		{Name: "compressed", Path: "/large", AcceptEncoding: "gzip", Headers: []string{"Content-Encoding: gzip"}},
+		{Name: "streamed with length", Path: "/sized", Headers: []string{"Content-Length: 8192"}},
+		{Name: "streamed chunked", Path: "/chunked", Headers: []string{"Transfer-Encoding: chunked"}},
+		{Name: "explicit HEAD route", Path: "/explicit", Headers: []string{"X-Explicit: yes"}},
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// every response is read off one keep-alive connection, a body sent by
+	// mistake would show up as garbage in front of the next status line
+	for _, tc := range testCases {
+		request := "HEAD " + tc.Path + " HTTP/1.1\r\nHost: localhost\r\n"
+		if tc.AcceptEncoding != "" {
+			request += "Accept-Encoding: " + tc.AcceptEncoding + "\r\n"
+		}
+		conn.Write([]byte(request + "\r\n"))
+
+		response, err := http.ReadResponse(reader, &http.Request{Method: "HEAD"})
+		if err != nil {
+			t.Fatalf("[ %s ]unexpected error %v", tc.Name, err)
+		}
+		response.Body.Close()
+		if response.StatusCode != 200 {
+			t.Errorf("[ %s ]expected 200, got %d", tc.Name, response.StatusCode)
+		}
+
+		var head strings.Builder
+		response.Header.Write(&head)
+		if len(response.TransferEncoding) > 0 {
+			head.WriteString("Transfer-Encoding: " + strings.Join(response.TransferEncoding, ", ") + "\r\n")
+		}
+		for _, expected := range tc.Headers {
+			if !strings.Contains(head.String(), expected+"\r\n") {
+				t.Errorf("[ %s ]expected %q in %q", tc.Name, expected, head.String())
+			}
+		}
+	}
+
+	conn.Write([]byte("HEAD /large HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n"))
+	head, err := http.ReadResponse(reader, &http.Request{Method: "HEAD"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	conn.Write([]byte("GET /large HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n"))
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	// compressed on the fly the length is not known up front, HEAD has to
+	// announce the same framing GET uses
+	if len(body) == 0 || head.Header.Get("Content-Encoding") != "gzip" || head.ContentLength != response.ContentLength ||
+		strings.Join(head.TransferEncoding, ",") != strings.Join(response.TransferEncoding, ",") {
+		t.Errorf("expected HEAD to announce the framing of GET %d %v, got %d %v",
+			response.ContentLength, response.TransferEncoding, head.ContentLength, head.TransferEncoding)
+	}
+}
+
+func TestVirtualHosts(t *testing.T) {
+	hosts := router.NewHostRouter(nil)
+	hosts.Host("api.example.test").Get("/", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.Write([]byte("api"))
+	})
+	hosts.Host("*.example.test").Get("/", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.Write([]byte("subdomain " + r.Headers.Get("host")))
+	})
+	addr := startServer(t, NewVirtualHostServer(hosts))
+
+	testCases := []struct {
+		Name     string
+		Host     string
+		Status   int
+		Expected string
+	}{
+		{Name: "exact", Host: "api.example.test", Status: 200, Expected: "api"},
+		{Name: "wildcard", Host: "www.example.test:4221", Status: 200, Expected: "subdomain www.example.test:4221"},
+		{Name: "unknown", Host: "other.test", Status: 404},
+	}
+
+	for _, tc := range testCases {
+		request, _ := http.NewRequest("GET", "http://"+addr+"/", nil)
+		request.Host = tc.Host
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("[ %s ]unexpected error %v", tc.Name, err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != tc.Status || string(body) != tc.Expected {
+			t.Errorf("[ %s ]expected %d %q, got %d %q", tc.Name, tc.Status, tc.Expected, response.StatusCode, body)
+		}
+	}
+}
+
+type upperCoding struct{ io.Writer }
+
+func (u upperCoding) Write(p []byte) (int, error) { return u.Writer.Write(bytes.ToUpper(p)) }
+
+func (upperCoding) Close() error { return nil }
+
+func TestCompressionNegotiation(t *testing.T) {
+	text := strings.Repeat("hello world ", 30)
+	appRouter := router.NewRouter()
+	appRouter.Get("/text", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetHeader("Content-Type", "text/plain; charset=utf-8")
+		w.SetHeader("Vary", "Origin")
+		w.SetHeader("Last-Modified", "Sat, 09 Mar 2024 16:30:15 GMT")
+		w.SetHeader("ETag", `"text"`)
+		w.Write([]byte(text))
+	})
+	appRouter.Get("/small", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetHeader("Content-Type", "text/plain")
+		w.Write([]byte("hello world"))
+	})
+	appRouter.Get("/binary", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetHeader("Content-Type", "application/octet-stream")
+		w.Write([]byte(text))
+	})
+	appRouter.With(compression.Disable).Get("/raw", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetHeader("Content-Type", "text/plain")
+		w.Write([]byte(text))
+	})
+	server := NewHttpServer(appRouter)
+	// the application brings its own codings
+	server.Encoders().Register(compression.Coding{Name: "upper", NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return upperCoding{w}, nil
+	}})
+	addr := startServer(t, server)
+
+	testCases := []struct {
+		Name     string
+		Path     string
+		Accept   string
+		Status   int
+		Encoding string
+		Body     string
+		Vary     string
+	}{
+		{Name: "identity", Path: "/text", Status: 200, Body: text, Vary: "Origin, Accept-Encoding"},
+		{Name: "gzip", Path: "/text", Accept: "gzip", Status: 200, Encoding: "gzip", Body: text, Vary: "Origin, Accept-Encoding"},
+		{Name: "deflate by q-value", Path: "/text", Accept: "gzip;q=0.4, deflate;q=0.9", Status: 200, Encoding: "deflate", Body: text, Vary: "Origin, Accept-Encoding"},
+		{Name: "br", Path: "/text", Accept: "gzip, br", Status: 200, Encoding: "br", Body: text, Vary: "Origin, Accept-Encoding"},
+		{Name: "zstd", Path: "/text", Accept: "gzip, zstd", Status: 200, Encoding: "zstd", Body: text, Vary: "Origin, Accept-Encoding"},
+		{Name: "registered coding", Path: "/text", Accept: "gzip, upper", Status: 200, Encoding: "upper", Body: strings.ToUpper(text), Vary: "Origin, Accept-Encoding"},
+		{Name: "nothing acceptable", Path: "/text", Accept: "compress, identity;q=0", Status: 406, Vary: "Accept-Encoding"},
+		{Name: "below the minimum size", Path: "/small", Accept: "gzip", Status: 200, Body: "hello world"},
+		{Name: "type not compressed", Path: "/binary", Accept: "gzip", Status: 200, Body: text},
+		{Name: "route opted out", Path: "/raw", Accept: "gzip", Status: 200, Body: text},
+		{Name: "not found is not encoded", Path: "/missing", Accept: "gzip", Status: 404},
+	}
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	for _, tc := range testCases {
+		request, _ := http.NewRequest("GET", "http://"+addr+tc.Path, nil)
+		if tc.Accept != "" {
+			request.Header.Set("Accept-Encoding", tc.Accept)
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			t.Fatalf("[ %s ]unexpected error %v", tc.Name, err)
+		}
+		var body io.Reader = response.Body
+		switch tc.Encoding {
+		case "gzip":
+			body, err = gzip.NewReader(body)
+		case "deflate":
+			body, err = zlib.NewReader(body)
+		case "br":
+			body = brotli.NewReader(body)
+		case "zstd":
+			body = zstd.NewReader(body)
+		}
+		if err != nil {
+			t.Fatalf("[ %s ]unexpected error %v", tc.Name, err)
+		}
+		decoded, _ := io.ReadAll(body)
+		response.Body.Close()
+
+		if response.StatusCode != tc.Status {
+			t.Errorf("[ %s ]expected status %d, got %d", tc.Name, tc.Status, response.StatusCode)
+		}
+		if encoding := response.Header.Get("Content-Encoding"); encoding != tc.Encoding {
+			t.Errorf("[ %s ]expected coding %q, got %q", tc.Name, tc.Encoding, encoding)
+		}
+		if tc.Status == 200 && string(decoded) != tc.Body {
+			t.Errorf("[ %s ]expected body %q, got %q", tc.Name, tc.Body, decoded)
+		}
+		if vary := response.Header.Get("Vary"); vary != tc.Vary {
+			t.Errorf("[ %s ]expected Vary %q, got %q", tc.Name, tc.Vary, vary)
+		}
+		if tc.Status != 406 {
+			continue
+		}
+		// none of the fields of the representation that was not sent
+		for _, key := range []string{"Content-Type", "Last-Modified", "ETag"} {
+			if value := response.Header.Get(key); value != "" {
+				t.Errorf("[ %s ]expected no %s, got %q", tc.Name, key, value)
+			}
+		}
+	}
+}
+
+func TestStreamingCompression(t *testing.T) {
+	proceed := make(chan struct{})
+	appRouter := router.NewRouter()
+	appRouter.Get("/stream", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetHeader("Content-Type", "text/plain")
+		io.WriteString(w.BodyWriter(), "part one")
+		w.Flush()
+		// the client has to see the first part before the handler goes on
+		<-proceed
+		io.WriteString(w.BodyWriter(), ", part two")
+	})
+	appRouter.Get("/sized", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		w.SetHeader("Content-Type", "text/plain")
+		w.SetHeader("Content-Length", "8192")
+		w.BodyWriter().Write(bytes.Repeat([]byte("s"), 8192))
+	})
+	addr := startServer(t, NewHttpServer(appRouter))
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}, Timeout: 5 * time.Second}
+
+	request, _ := http.NewRequest("GET", "http://"+addr+"/stream", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer response.Body.Close()
+	reader, err := gzip.NewReader(response.Body)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	first := make([]byte, len("part one"))
+	if _, err := io.ReadFull(reader, first); err != nil || string(first) != "part one" {
+		t.Fatalf("expected the flushed part, got %q %v", first, err)
+	}
+	close(proceed)
+	if rest, _ := io.ReadAll(reader); string(rest) != ", part two" {
+		t.Errorf("unexpected rest %q", rest)
+	}
+
+	request, _ = http.NewRequest("GET", "http://"+addr+"/sized", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	response, err = client.Do(request)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer response.Body.Close()
+	if response.ContentLength != -1 || len(response.TransferEncoding) == 0 || response.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected a chunked gzip body, got length %d %v", response.ContentLength, response.Header)
+	}
+	reader, err = gzip.NewReader(response.Body)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if body, _ := io.ReadAll(reader); len(body) != 8192 {
+		t.Errorf("expected 8192 bytes, got %d", len(body))
+	}
+}
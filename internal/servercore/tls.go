@@ -0,0 +1,248 @@
+package servercore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoCertificate = errors.New("no certificate configured")
+
+type CertificatePair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// ClientAuth selects whether client certificates are asked for and
+// verified against TLSOptions.ClientCAFile.
+type ClientAuth int
+
+const (
+	ClientAuthNone ClientAuth = iota
+	ClientAuthRequest
+	ClientAuthRequire
+)
+
+func ParseClientAuth(value string) (ClientAuth, error) {
+	switch strings.ToLower(value) {
+	case "", "none":
+		return ClientAuthNone, nil
+	case "request":
+		return ClientAuthRequest, nil
+	case "require":
+		return ClientAuthRequire, nil
+	}
+	return ClientAuthNone, fmt.Errorf("unknown client auth mode %q", value)
+}
+
+type TLSOptions struct {
+	Certificates *CertificateStore
+	// ClientCAFile is a PEM bundle of the authorities client certificates
+	// are verified against
+	ClientCAFile string
+	ClientAuth   ClientAuth
+}
+
+// CertificateStore holds the certificates served by the TLS listener and
+// picks one per connection from the SNI server name. Certificates loaded from
+// files can be reloaded while the server is running, connections that have
+// already completed their handshake are not affected.
+type CertificateStore struct {
+	mu       sync.RWMutex
+	pairs    []CertificatePair
+	loaded   []*tls.Certificate
+	static   []*tls.Certificate
+	byName   map[string]*tls.Certificate
+	fallback *tls.Certificate
+}
+
+func NewCertificateStore(pairs ...CertificatePair) (*CertificateStore, error) {
+	store := &CertificateStore{pairs: pairs}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Add registers a certificate that does not come from disk, like a
+// generated self-signed one. It survives reloads.
+func (c *CertificateStore) Add(certificate tls.Certificate) error {
+	if err := parseLeaf(&certificate); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.static = append(c.static, &certificate)
+	c.index()
+	return nil
+}
+
+// Reload reads every certificate pair from disk again. When one of them
+// fails to load the certificates currently served are kept.
+func (c *CertificateStore) Reload() error {
+	loaded := make([]*tls.Certificate, 0, len(c.pairs))
+	for _, pair := range c.pairs {
+		certificate, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate %s: %w", pair.CertFile, err)
+		}
+		if err := parseLeaf(&certificate); err != nil {
+			return fmt.Errorf("failed to parse certificate %s: %w", pair.CertFile, err)
+		}
+		loaded = append(loaded, &certificate)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loaded = loaded
+	c.index()
+	return nil
+}
+
+func (c *CertificateStore) index() {
+	c.byName = make(map[string]*tls.Certificate)
+	c.fallback = nil
+	for _, certificate := range append(append([]*tls.Certificate{}, c.loaded...), c.static...) {
+		if c.fallback == nil {
+			c.fallback = certificate
+		}
+		names := certificate.Leaf.DNSNames
+		if len(names) == 0 && certificate.Leaf.Subject.CommonName != "" {
+			names = []string{certificate.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			// the first certificate listing a name wins
+			if _, exists := c.byName[name]; !exists {
+				c.byName[name] = certificate
+			}
+		}
+	}
+}
+
+// GetCertificate is used as tls.Config.GetCertificate.
+func (c *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if certificate, exists := c.byName[name]; exists {
+		return certificate, nil
+	}
+	if _, parent, found := strings.Cut(name, "."); found {
+		if certificate, exists := c.byName["*."+parent]; exists {
+			return certificate, nil
+		}
+	}
+
+	if c.fallback == nil {
+		return nil, ErrNoCertificate
+	}
+	return c.fallback, nil
+}
+
+func (o TLSOptions) config() (*tls.Config, error) {
+	if o.Certificates == nil {
+		return nil, ErrNoCertificate
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: o.Certificates.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	if o.ClientAuth == ClientAuthNone {
+		return config, nil
+	}
+	if o.ClientCAFile == "" {
+		return nil, errors.New("client certificate verification needs a CA bundle")
+	}
+
+	bundle, err := os.ReadFile(o.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no certificates found in %s", o.ClientCAFile)
+	}
+
+	config.ClientCAs = pool
+	if o.ClientAuth == ClientAuthRequire {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// GenerateSelfSignedCertificate creates a throwaway certificate for local
+// development, valid for the given host names and IP addresses. It is a
+// plain leaf that cannot sign other certificates.
+func GenerateSelfSignedCertificate(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"go-http-server development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if len(hosts) > 0 {
+		template.Subject.CommonName = hosts[0]
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+func parseLeaf(certificate *tls.Certificate) error {
+	if certificate.Leaf != nil {
+		return nil
+	}
+	if len(certificate.Certificate) == 0 {
+		return ErrNoCertificate
+	}
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return err
+	}
+	certificate.Leaf = leaf
+	return nil
+}
@@ -0,0 +1,293 @@
+package servercore
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+	"github.com/codecrafters-io/http-server-starter-go/internal/router"
+)
+
+func TestCertificateStore(t *testing.T) {
+	store, err := NewCertificateStore()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for _, hosts := range [][]string{{"a.test"}, {"*.b.test"}, {"localhost", "127.0.0.1"}} {
+		certificate, err := GenerateSelfSignedCertificate(hosts...)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := store.Add(certificate); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	testCases := []struct {
+		Name       string
+		ServerName string
+		CommonName string
+	}{
+		{Name: "Exact match", ServerName: "a.test", CommonName: "a.test"},
+		{Name: "Match is case insensitive", ServerName: "A.Test", CommonName: "a.test"},
+		{Name: "Wildcard match", ServerName: "api.b.test", CommonName: "*.b.test"},
+		{Name: "Wildcard only covers one label", ServerName: "x.api.b.test", CommonName: "a.test"},
+		{Name: "Unknown name gets the first certificate", ServerName: "unknown.test", CommonName: "a.test"},
+		{Name: "No SNI gets the first certificate", ServerName: "", CommonName: "a.test"},
+		{Name: "Second name of a certificate", ServerName: "localhost", CommonName: "localhost"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			certificate, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: tc.ServerName})
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if certificate.Leaf.Subject.CommonName != tc.CommonName {
+				t.Errorf("expected %s, got %s", tc.CommonName, certificate.Leaf.Subject.CommonName)
+			}
+		})
+	}
+}
+
+func TestCertificateStoreErrors(t *testing.T) {
+	if _, err := NewCertificateStore(CertificatePair{CertFile: "missing.crt", KeyFile: "missing.key"}); err == nil {
+		t.Errorf("expected error for missing certificate files")
+	}
+
+	store, _ := NewCertificateStore()
+	if _, err := store.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Errorf("expected error for empty store")
+	}
+}
+
+// writePair stores a generated certificate for host as PEM files.
+func writePair(t *testing.T, pair CertificatePair, host string) []byte {
+	t.Helper()
+	certificate, err := GenerateSelfSignedCertificate(host)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	if err := os.WriteFile(pair.CertFile, certPEM, 0o600); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := os.WriteFile(pair.KeyFile, keyPEM, 0o600); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return certificate.Certificate[0]
+}
+
+func TestCertificateStoreReload(t *testing.T) {
+	directory := t.TempDir()
+	pair := CertificatePair{CertFile: filepath.Join(directory, "server.crt"), KeyFile: filepath.Join(directory, "server.key")}
+	first := writePair(t, pair, "first.test")
+	store, err := NewCertificateStore(pair)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: store.GetCertificate})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	// served reports the certificate a new handshake gets
+	served := func() *x509.Certificate {
+		t.Helper()
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0]
+	}
+
+	leaf := served()
+	if !bytes.Equal(leaf.Raw, first) {
+		t.Fatalf("expected the first certificate, got %s", leaf.Subject.CommonName)
+	}
+	// the generated certificate cannot sign others
+	if leaf.IsCA || leaf.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Errorf("expected a leaf for signatures only, got CA %v and key usage %d", leaf.IsCA, leaf.KeyUsage)
+	}
+
+	second := writePair(t, pair, "second.test")
+	if err := store.Reload(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if leaf := served(); !bytes.Equal(leaf.Raw, second) {
+		t.Errorf("expected the reloaded certificate, got %s", leaf.Subject.CommonName)
+	}
+
+	// a broken pair on disk keeps the certificate served so far
+	if err := os.WriteFile(pair.CertFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := store.Reload(); err == nil {
+		t.Errorf("expected error for a broken certificate file")
+	}
+	if leaf := served(); !bytes.Equal(leaf.Raw, second) {
+		t.Errorf("expected the certificate from before the failed reload, got %s", leaf.Subject.CommonName)
+	}
+}
+
+// issue creates a certificate for name signed by parent, a self-signed
+// authority when parent is nil.
+func issue(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	issuer, signer := template, any(key)
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientCertificates(t *testing.T) {
+	authority := issue(t, "test-ca", nil)
+	client := issue(t, "svc-a", &authority)
+	otherAuthority := issue(t, "other-ca", nil)
+	stranger := issue(t, "svc-b", &otherAuthority)
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: authority.Certificate[0]}), 0o600); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	serverCertificate, err := GenerateSelfSignedCertificate("127.0.0.1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	store, _ := NewCertificateStore()
+	store.Add(serverCertificate)
+	roots := x509.NewCertPool()
+	leaf, _ := x509.ParseCertificate(serverCertificate.Certificate[0])
+	roots.AddCert(leaf)
+
+	appRouter := router.NewRouter()
+	appRouter.Get("/whoami", func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		subject, ok := r.ClientSubject()
+		if !ok {
+			subject.CommonName = "anonymous"
+		}
+		w.Write(fmt.Appendf(nil, "%s %d", subject.CommonName, len(r.TLS.PeerCertificates)))
+	})
+	server := NewHttpServer(appRouter)
+
+	testCases := []struct {
+		Name        string
+		Mode        ClientAuth
+		Certificate *tls.Certificate
+		Expected    string
+	}{
+		{Name: "Required and missing", Mode: ClientAuthRequire},
+		{Name: "Required and valid", Mode: ClientAuthRequire, Certificate: &client, Expected: "svc-a 1"},
+		{Name: "Required from another authority", Mode: ClientAuthRequire, Certificate: &stranger},
+		{Name: "Requested and missing", Mode: ClientAuthRequest, Expected: "anonymous 0"},
+		{Name: "Requested and valid", Mode: ClientAuthRequest, Certificate: &client, Expected: "svc-a 1"},
+		{Name: "Requested from another authority", Mode: ClientAuthRequest, Certificate: &stranger},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			config, err := TLSOptions{Certificates: store, ClientCAFile: bundle, ClientAuth: tc.Mode}.config()
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			defer listener.Close()
+			go func() {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					go server.handleRequests(tls.Server(conn, config))
+				}
+			}()
+
+			// sent even when the server names other authorities
+			clientConfig := &tls.Config{RootCAs: roots, GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				if tc.Certificate == nil {
+					return &tls.Certificate{}, nil
+				}
+				return tc.Certificate, nil
+			}}
+			httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}, Timeout: 5 * time.Second}
+			defer httpClient.CloseIdleConnections()
+
+			response, err := httpClient.Get("https://" + listener.Addr().String() + "/whoami")
+			if tc.Expected == "" {
+				if err == nil {
+					response.Body.Close()
+					t.Fatalf("expected the handshake to be rejected, got status %d", response.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			body, _ := io.ReadAll(response.Body)
+			response.Body.Close()
+			if string(body) != tc.Expected {
+				t.Errorf("expected %q, got %q", tc.Expected, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,513 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes of RFC 6455 section 7.4.1
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+)
+
+const maxControlPayload = 125
+
+var (
+	ErrCloseSent      = errors.New("websocket: close frame already sent")
+	ErrWriterInFlight = errors.New("websocket: previous message writer is not closed")
+)
+
+// CloseError is returned by ReadMessage once the peer closed the connection,
+// or when the connection was failed because of a protocol violation.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// Conn is a WebSocket connection. One goroutine may read while another
+// writes, control frames can be sent from any goroutine.
+type Conn struct {
+	netConn     net.Conn
+	reader      *bufio.Reader
+	writer      *bufio.Writer
+	subprotocol string
+	compress    bool
+	readLimit   int64
+
+	writeMu          sync.Mutex
+	writeCompression bool
+	writerOpen       bool
+	closeSent        bool
+
+	pongHandler func(data []byte)
+}
+
+func newConn(netConn net.Conn, readWriter *bufio.ReadWriter, subprotocol string, compress bool, readLimit int64) *Conn {
+	if readLimit <= 0 {
+		readLimit = DefaultReadLimit
+	}
+	return &Conn{
+		netConn:          netConn,
+		reader:           readWriter.Reader,
+		writer:           readWriter.Writer,
+		subprotocol:      subprotocol,
+		compress:         compress,
+		writeCompression: compress,
+		readLimit:        readLimit,
+	}
+}
+
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.netConn.RemoteAddr()
+}
+
+// EnableWriteCompression turns compression of outgoing messages on or off,
+// it only has an effect when permessage-deflate was negotiated.
+func (c *Conn) EnableWriteCompression(enable bool) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.writeCompression = enable && c.compress
+}
+
+// SetPongHandler is called with the payload of every pong received.
+func (c *Conn) SetPongHandler(handler func(data []byte)) {
+	c.pongHandler = handler
+}
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  byte
+	payload []byte
+}
+
+// ReadMessage returns the next data message, answering pings and handling
+// fragmentation on the way. A *CloseError is returned once the connection
+// was closed.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var message []byte
+	var messageType MessageType
+	compressed := false
+	fragmented := false
+
+	for {
+		f, err := c.readFrame(int64(len(message)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch f.opcode {
+		case opPing:
+			if err := c.writeFrame(true, false, opPong, f.payload); err != nil && !errors.Is(err, ErrCloseSent) {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			if c.pongHandler != nil {
+				c.pongHandler(f.payload)
+			}
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if fragmented {
+				return 0, nil, c.fail(&CloseError{CloseProtocolError, "new message inside a fragmented one"})
+			}
+			fragmented = true
+			messageType = MessageType(f.opcode)
+			compressed = f.rsv1
+		case opContinuation:
+			if !fragmented {
+				return 0, nil, c.fail(&CloseError{CloseProtocolError, "continuation without a message"})
+			}
+			if f.rsv1 {
+				return 0, nil, c.fail(&CloseError{CloseProtocolError, "RSV1 set on a continuation frame"})
+			}
+		}
+
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+
+		if compressed {
+			message, err = c.inflate(message)
+			if err != nil {
+				return 0, nil, c.fail(err)
+			}
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(&CloseError{CloseInvalidFramePayloadData, "invalid UTF-8 in text message"})
+		}
+		return messageType, message, nil
+	}
+}
+
+func (c *Conn) readFrame(messageLength int64) (frame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		fin:    header[0]&0x80 != 0,
+		rsv1:   header[0]&0x40 != 0,
+		opcode: header[0] & 0x0f,
+	}
+	if header[0]&0x30 != 0 {
+		return f, &CloseError{CloseProtocolError, "reserved bits set"}
+	}
+	switch f.opcode {
+	case opContinuation, opText, opBinary:
+		if f.rsv1 && !c.compress {
+			return f, &CloseError{CloseProtocolError, "RSV1 set without compression"}
+		}
+	case opClose, opPing, opPong:
+		if f.rsv1 || !f.fin {
+			return f, &CloseError{CloseProtocolError, "invalid control frame"}
+		}
+	default:
+		return f, &CloseError{CloseProtocolError, "unknown opcode"}
+	}
+
+	// clients have to mask every frame (RFC 6455 section 5.1)
+	if header[1]&0x80 == 0 {
+		return f, &CloseError{CloseProtocolError, "unmasked client frame"}
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return f, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return f, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+		if length>>63 != 0 {
+			return f, &CloseError{CloseProtocolError, "invalid payload length"}
+		}
+	}
+
+	if f.opcode >= opClose && length > maxControlPayload {
+		return f, &CloseError{CloseProtocolError, "control frame too large"}
+	}
+	// checked before the payload is allocated, the length comes from the
+	// client
+	if f.opcode < opClose && length > uint64(c.readLimit-messageLength) {
+		return f, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return f, err
+	}
+
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, f.payload); err != nil {
+		return f, err
+	}
+	for idx := range f.payload {
+		f.payload[idx] ^= mask[idx%4]
+	}
+	return f, nil
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(&CloseError{CloseProtocolError, "invalid close payload"})
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(&CloseError{CloseProtocolError, "invalid close code"})
+		}
+		if !utf8.ValidString(closeErr.Text) {
+			return c.fail(&CloseError{CloseInvalidFramePayloadData, "invalid UTF-8 in close reason"})
+		}
+	}
+
+	// echo the close frame, the handshake is then complete
+	code := closeErr.Code
+	if code == CloseNoStatusReceived {
+		code = CloseNormalClosure
+	}
+	c.Close(code, "")
+	return closeErr
+}
+
+// fail closes the connection after a protocol violation. Read errors of the
+// underlying connection are reported as an abnormal closure.
+func (c *Conn) fail(err error) error {
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		return &CloseError{CloseAbnormalClosure, err.Error()}
+	}
+	c.Close(closeErr.Code, closeErr.Text)
+	c.netConn.Close()
+	return closeErr
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// WriteMessage sends data as a single frame.
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.writerOpen {
+		return ErrWriterInFlight
+	}
+	if !c.writeCompression {
+		return c.writeFrameLocked(true, false, byte(messageType), data)
+	}
+
+	var compressed bytes.Buffer
+	deflate, _ := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if _, err := deflate.Write(data); err != nil {
+		return err
+	}
+	if err := deflate.Flush(); err != nil {
+		return err
+	}
+	payload := bytes.TrimSuffix(compressed.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+	return c.writeFrameLocked(true, true, byte(messageType), payload)
+}
+
+// NextWriter returns a writer for a message that is sent as a sequence of
+// fragments, one per Write. Close sends the final fragment. Only one message
+// writer may be open at a time.
+func (c *Conn) NextWriter(messageType MessageType) (io.WriteCloser, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return nil, ErrCloseSent
+	}
+	if c.writerOpen {
+		return nil, ErrWriterInFlight
+	}
+	c.writerOpen = true
+
+	writer := &messageWriter{conn: c, opcode: byte(messageType)}
+	if c.writeCompression {
+		writer.compressed = true
+		writer.deflate, _ = flate.NewWriter(&writer.pending, flate.DefaultCompression)
+	}
+	return writer, nil
+}
+
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: ping payload too large")
+	}
+	return c.writeFrame(true, false, opPing, data)
+}
+
+// Close sends a close frame with code and reason, it does nothing once a
+// close frame was sent. The underlying connection is left open to receive
+// the peer's close frame.
+func (c *Conn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+
+	err := c.writeFrame(true, false, opClose, payload)
+	if errors.Is(err, ErrCloseSent) {
+		return nil
+	}
+	return err
+}
+
+func (c *Conn) writeFrame(fin bool, rsv1 bool, opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrameLocked(fin, rsv1, opcode, payload)
+}
+
+func (c *Conn) writeFrameLocked(fin bool, rsv1 bool, opcode byte, payload []byte) error {
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	header := []byte{b0}
+
+	// server frames are never masked
+	length := len(payload)
+	switch {
+	case length <= 125:
+		header = append(header, byte(length))
+	case length <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	if _, err := c.writer.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.writer.Write(payload); err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
+
+// messageWriter sends a data message as fragments. With compression the
+// deflate output is buffered so the trailing empty block can be stripped
+// (RFC 7692 section 7.2.1).
+type messageWriter struct {
+	conn       *Conn
+	opcode     byte
+	started    bool
+	closed     bool
+	compressed bool
+	deflate    *flate.Writer
+	pending    bytes.Buffer
+}
+
+const compressedFragmentSize = 16 << 10
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrWriterInFlight
+	}
+	if !w.compressed {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		if err := w.send(false, p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if _, err := w.deflate.Write(p); err != nil {
+		return 0, err
+	}
+	// keep the last 4 bytes back, they may be the block to strip
+	if w.pending.Len() > compressedFragmentSize+4 {
+		fragment := w.pending.Next(w.pending.Len() - 4)
+		if err := w.send(false, fragment); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer func() {
+		w.conn.writeMu.Lock()
+		w.conn.writerOpen = false
+		w.conn.writeMu.Unlock()
+	}()
+
+	var last []byte
+	if w.compressed {
+		if err := w.deflate.Flush(); err != nil {
+			return err
+		}
+		last = bytes.TrimSuffix(w.pending.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+	}
+	return w.send(true, last)
+}
+
+func (w *messageWriter) send(fin bool, payload []byte) error {
+	opcode := byte(opContinuation)
+	rsv1 := false
+	if !w.started {
+		opcode = w.opcode
+		rsv1 = w.compressed
+		w.started = true
+	}
+	return w.conn.writeFrame(fin, rsv1, opcode, payload)
+}
+
+func (c *Conn) inflate(compressed []byte) ([]byte, error) {
+	// restore the stripped empty block and add a final one so the reader
+	// sees a complete stream
+	tail := []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+	reader := flate.NewReader(io.MultiReader(bytes.NewReader(compressed), bytes.NewReader(tail)))
+	defer reader.Close()
+
+	message, err := io.ReadAll(io.LimitReader(reader, c.readLimit+1))
+	if err != nil {
+		return nil, &CloseError{CloseInvalidFramePayloadData, "invalid compressed message"}
+	}
+	if int64(len(message)) > c.readLimit {
+		return nil, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+	return message, nil
+}
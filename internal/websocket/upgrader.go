@@ -0,0 +1,159 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/common"
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+)
+
+// RFC 6455 section 1.3
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+type Handler func(conn *Conn, r httpcore.Request)
+
+// Upgrader validates the opening handshake and takes the connection over.
+type Upgrader struct {
+	// Subprotocols supported by the server in order of preference
+	Subprotocols []string
+	// CheckOrigin rejects cross origin requests when it returns false, by
+	// default every origin is accepted
+	CheckOrigin func(r httpcore.Request) bool
+	// EnableCompression negotiates permessage-deflate when the client offers it
+	EnableCompression bool
+	// ReadLimit is the largest message accepted in bytes, 0 means
+	// DefaultReadLimit. A larger message closes the connection with 1009.
+	ReadLimit int64
+}
+
+// DefaultReadLimit bounds messages when Upgrader.ReadLimit is not set, the
+// payload length of a frame is announced by the client.
+const DefaultReadLimit = 32 << 20
+
+// Upgrade answers the handshake with 101 Switching Protocols and returns the
+// WebSocket connection. On failure an error status is set on w.
+func (u Upgrader) Upgrade(r httpcore.Request, w *httpcore.HttpResponseWriter) (*Conn, error) {
+	if r.Method != common.GET {
+		w.SetStatus(httpcore.StatusMethodNotAllowed)
+		return nil, fmt.Errorf("%w: method is not GET", ErrBadHandshake)
+	}
+	if !headerHasToken(r.Headers.Get("connection"), "upgrade") || !headerHasToken(r.Headers.Get("upgrade"), "websocket") {
+		w.SetStatus(httpcore.StatusBadRequest)
+		return nil, fmt.Errorf("%w: not a websocket upgrade", ErrBadHandshake)
+	}
+	if r.Headers.Get("sec-websocket-version") != "13" {
+		w.SetHeader("Sec-WebSocket-Version", "13")
+		w.SetStatus(httpcore.StatusUpgradeRequired)
+		return nil, fmt.Errorf("%w: unsupported version", ErrBadHandshake)
+	}
+
+	key := strings.TrimSpace(r.Headers.Get("sec-websocket-key"))
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		w.SetStatus(httpcore.StatusBadRequest)
+		return nil, fmt.Errorf("%w: invalid Sec-WebSocket-Key", ErrBadHandshake)
+	}
+	if u.CheckOrigin != nil && !u.CheckOrigin(r) {
+		w.SetStatus(httpcore.StatusForbidden)
+		return nil, fmt.Errorf("%w: origin not allowed", ErrBadHandshake)
+	}
+
+	netConn, readWriter, err := w.Hijack()
+	if err != nil {
+		w.SetStatus(httpcore.StatusInternalServerError)
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"
+	response += "Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+
+	subprotocol := u.selectSubprotocol(r.Headers.Get("sec-websocket-protocol"))
+	if subprotocol != "" {
+		response += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	compress := u.EnableCompression && offersDeflate(r.Headers.Get("sec-websocket-extensions"))
+	if compress {
+		response += "Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n"
+	}
+	response += "\r\n"
+
+	if _, err := readWriter.WriteString(response); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if err := readWriter.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return newConn(netConn, readWriter, subprotocol, compress, u.ReadLimit), nil
+}
+
+// HandlerFunc adapts handler to a route handler that upgrades the request
+// first. The connection is closed when handler returns.
+func (u Upgrader) HandlerFunc(handler Handler) httpcore.HandlerFunc {
+	return func(r httpcore.Request, w *httpcore.HttpResponseWriter) {
+		conn, err := u.Upgrade(r, w)
+		if err != nil {
+			return
+		}
+		defer conn.netConn.Close()
+
+		handler(conn, r)
+		conn.Close(CloseNormalClosure, "")
+	}
+}
+
+func (u Upgrader) selectSubprotocol(offered string) string {
+	if offered == "" {
+		return ""
+	}
+	for _, supported := range u.Subprotocols {
+		if headerHasToken(offered, supported) {
+			return supported
+		}
+	}
+	return ""
+}
+
+func acceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// offersDeflate looks for a permessage-deflate offer we can accept. Offers
+// limiting our window with server_max_window_bits are declined since the
+// compressor always uses the full window.
+func offersDeflate(extensions string) bool {
+	for _, offer := range strings.Split(extensions, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		acceptable := true
+		for _, param := range params[1:] {
+			name, _, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if name == "server_max_window_bits" {
+				acceptable = false
+			}
+		}
+		if acceptable {
+			return true
+		}
+	}
+	return false
+}
+
+func headerHasToken(value string, token string) bool {
+	for _, item := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), token) {
+			return true
+		}
+	}
+	return false
+}
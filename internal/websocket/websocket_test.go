@@ -0,0 +1,358 @@
+package websocket_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/http-server-starter-go/internal/httpcore"
+	"github.com/codecrafters-io/http-server-starter-go/internal/websocket"
+)
+
+const handshake = "GET /ws HTTP/1.1\r\nHost: localhost\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n" +
+	"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+
+// serve runs handler behind upgrader for a single connection and returns the
+// client side, the request is parsed the way the server does it.
+func serve(t *testing.T, upgrader websocket.Upgrader, handler websocket.Handler) net.Conn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	go func() {
+		defer server.Close()
+		reader := bufio.NewReader(server)
+		writer := bufio.NewWriter(server)
+		request, err := httpcore.ParseRequest(reader)
+		if err != nil {
+			return
+		}
+		response := httpcore.NewStreamingResponseWriter(httpcore.NewHttp1StreamWriter(writer).WithConn(server, reader))
+		upgrader.HandlerFunc(handler)(*request, &response)
+		if !response.IsHijacked() {
+			response.Finish()
+		}
+	}()
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func readHandshake(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	var head strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		head.WriteString(line)
+		if line == "\r\n" {
+			return head.String()
+		}
+	}
+}
+
+func writeFrame(conn net.Conn, b0 byte, payload []byte) {
+	frame := []byte{b0}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, 0x80|byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for idx, b := range payload {
+		frame = append(frame, b^mask[idx%4])
+	}
+	conn.Write(frame)
+}
+
+// writeFrameHeader announces a masked frame of length bytes without sending
+// the payload.
+func writeFrameHeader(conn net.Conn, b0 byte, length uint64) {
+	frame := []byte{b0, 0x80 | 127}
+	frame = binary.BigEndian.AppendUint64(frame, length)
+	conn.Write(append(frame, 0x12, 0x34, 0x56, 0x78))
+}
+
+type frame struct {
+	b0      byte
+	payload []byte
+}
+
+func readFrame(t *testing.T, reader *bufio.Reader) frame {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatalf("server frames must not be masked")
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var extended [2]byte
+		io.ReadFull(reader, extended[:])
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return frame{b0: header[0], payload: payload}
+}
+
+func echo(conn *websocket.Conn, r httpcore.Request) {
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(messageType, message)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Request  string
+		Expected []string
+	}{
+		{
+			Name:    "accept and subprotocol",
+			Request: handshake + "Sec-WebSocket-Protocol: mqtt, chat\r\n\r\n",
+			Expected: []string{
+				"HTTP/1.1 101 Switching Protocols\r\n",
+				"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n",
+				"Sec-WebSocket-Protocol: chat\r\n",
+			},
+		},
+		{
+			Name:     "compression",
+			Request:  handshake + "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n\r\n",
+			Expected: []string{"HTTP/1.1 101 Switching Protocols\r\n", "Sec-WebSocket-Extensions: permessage-deflate"},
+		},
+		{
+			Name:     "wrong version",
+			Request:  strings.Replace(handshake, "Version: 13", "Version: 8", 1) + "\r\n",
+			Expected: []string{"HTTP/1.1 426 ", "Sec-WebSocket-Version: 13\r\n"},
+		},
+		{
+			Name:     "missing key",
+			Request:  strings.Replace(handshake, "dGhlIHNhbXBsZSBub25jZQ==", "short", 1) + "\r\n",
+			Expected: []string{"HTTP/1.1 400 "},
+		},
+		{
+			Name:     "not an upgrade",
+			Request:  strings.Replace(handshake, "Upgrade: websocket", "Upgrade: h2c", 1) + "\r\n",
+			Expected: []string{"HTTP/1.1 400 "},
+		},
+		{
+			Name:     "origin rejected",
+			Request:  handshake + "Origin: http://evil.test\r\n\r\n",
+			Expected: []string{"HTTP/1.1 403 "},
+		},
+	}
+
+	upgrader := websocket.Upgrader{
+		Subprotocols:      []string{"chat"},
+		EnableCompression: true,
+		CheckOrigin: func(r httpcore.Request) bool {
+			origin, ok := r.Headers.Lookup("origin")
+			return !ok || origin == "http://localhost"
+		},
+	}
+	for _, tc := range testCases {
+		client := serve(t, upgrader, echo)
+		client.Write([]byte(tc.Request))
+		head := readHandshake(t, bufio.NewReader(client))
+		for _, expected := range tc.Expected {
+			if !strings.Contains(head, expected) {
+				t.Errorf("[ %s ]expected %q in %q", tc.Name, expected, head)
+			}
+		}
+	}
+}
+
+func TestMessages(t *testing.T) {
+	client := serve(t, websocket.Upgrader{}, echo)
+	client.Write([]byte(handshake + "\r\n"))
+	reader := bufio.NewReader(client)
+	readHandshake(t, reader)
+
+	writeFrame(client, 0x81, []byte("hello"))
+	if got := readFrame(t, reader); got.b0 != 0x81 || string(got.payload) != "hello" {
+		t.Errorf("expected text echo, got %x %q", got.b0, got.payload)
+	}
+
+	// fragmented binary message with a ping in between
+	writeFrame(client, 0x02, []byte("frag"))
+	writeFrame(client, 0x89, []byte("are you there"))
+	writeFrame(client, 0x80, bytes.Repeat([]byte("m"), 300))
+	if got := readFrame(t, reader); got.b0 != 0x8a || string(got.payload) != "are you there" {
+		t.Errorf("expected pong, got %x %q", got.b0, got.payload)
+	}
+	if got := readFrame(t, reader); got.b0 != 0x82 || string(got.payload) != "frag"+strings.Repeat("m", 300) {
+		t.Errorf("expected reassembled binary echo, got %x with %d bytes", got.b0, len(got.payload))
+	}
+
+	writeFrame(client, 0x88, []byte{0x03, 0xe8, 'b', 'y', 'e'})
+	got := readFrame(t, reader)
+	if got.b0 != 0x88 || binary.BigEndian.Uint16(got.payload) != websocket.CloseNormalClosure {
+		t.Errorf("expected close 1000, got %x %v", got.b0, got.payload)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Send  func(conn net.Conn)
+		Code  uint16
+		Limit int64
+	}{
+		{
+			Name: "unmasked frame",
+			Send: func(conn net.Conn) { conn.Write([]byte{0x81, 0x02, 'h', 'i'}) },
+			Code: websocket.CloseProtocolError,
+		},
+		{
+			Name: "invalid utf-8",
+			Send: func(conn net.Conn) { writeFrame(conn, 0x81, []byte{0xff, 0xfe}) },
+			Code: websocket.CloseInvalidFramePayloadData,
+		},
+		{
+			Name: "continuation without message",
+			Send: func(conn net.Conn) { writeFrame(conn, 0x80, []byte("x")) },
+			Code: websocket.CloseProtocolError,
+		},
+		{
+			Name: "fragmented ping",
+			Send: func(conn net.Conn) { writeFrame(conn, 0x09, []byte("x")) },
+			Code: websocket.CloseProtocolError,
+		},
+		{
+			Name: "compressed without extension",
+			Send: func(conn net.Conn) { writeFrame(conn, 0xc1, []byte("x")) },
+			Code: websocket.CloseProtocolError,
+		},
+		{
+			Name: "invalid close code",
+			Send: func(conn net.Conn) { writeFrame(conn, 0x88, []byte{0x03, 0xed}) },
+			Code: websocket.CloseProtocolError,
+		},
+		{
+			Name:  "message too big",
+			Send:  func(conn net.Conn) { writeFrame(conn, 0x82, bytes.Repeat([]byte("x"), 64)) },
+			Code:  websocket.CloseMessageTooBig,
+			Limit: 32,
+		},
+		{
+			// only the header is sent, nothing is allocated for the payload
+			Name: "length over the default limit",
+			Send: func(conn net.Conn) { writeFrameHeader(conn, 0x82, websocket.DefaultReadLimit+1) },
+			Code: websocket.CloseMessageTooBig,
+		},
+		{
+			Name: "largest length",
+			Send: func(conn net.Conn) { writeFrameHeader(conn, 0x82, 1<<63-1) },
+			Code: websocket.CloseMessageTooBig,
+		},
+		{
+			Name: "fragments over the limit",
+			Send: func(conn net.Conn) {
+				writeFrame(conn, 0x02, bytes.Repeat([]byte("x"), 20))
+				writeFrameHeader(conn, 0x80, 1<<63-10)
+			},
+			Code:  websocket.CloseMessageTooBig,
+			Limit: 32,
+		},
+	}
+
+	for _, tc := range testCases {
+		client := serve(t, websocket.Upgrader{ReadLimit: tc.Limit}, echo)
+		client.Write([]byte(handshake + "\r\n"))
+		reader := bufio.NewReader(client)
+		readHandshake(t, reader)
+
+		tc.Send(client)
+		got := readFrame(t, reader)
+		if got.b0 != 0x88 || len(got.payload) < 2 || binary.BigEndian.Uint16(got.payload) != tc.Code {
+			t.Errorf("[ %s ]expected close %d, got %x %v", tc.Name, tc.Code, got.b0, got.payload)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	message := strings.Repeat("compress me please ", 100)
+	client := serve(t, websocket.Upgrader{EnableCompression: true}, echo)
+	client.Write([]byte(handshake + "Sec-WebSocket-Extensions: permessage-deflate\r\n\r\n"))
+	reader := bufio.NewReader(client)
+	readHandshake(t, reader)
+
+	var compressed bytes.Buffer
+	deflate, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	deflate.Write([]byte(message))
+	deflate.Flush()
+	writeFrame(client, 0xc1, bytes.TrimSuffix(compressed.Bytes(), []byte{0x00, 0x00, 0xff, 0xff}))
+
+	got := readFrame(t, reader)
+	if got.b0 != 0xc1 {
+		t.Fatalf("expected a compressed text frame, got %x", got.b0)
+	}
+	if len(got.payload) >= len(message) {
+		t.Errorf("expected payload smaller than %d bytes, got %d", len(message), len(got.payload))
+	}
+	inflated, err := io.ReadAll(flate.NewReader(io.MultiReader(
+		bytes.NewReader(got.payload),
+		bytes.NewReader([]byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}),
+	)))
+	if err != nil || string(inflated) != message {
+		t.Errorf("unexpected inflated message %q, error %v", inflated, err)
+	}
+}
+
+func TestNextWriterFragments(t *testing.T) {
+	client := serve(t, websocket.Upgrader{}, func(conn *websocket.Conn, r httpcore.Request) {
+		writer, _ := conn.NextWriter(websocket.TextMessage)
+		writer.Write([]byte("one "))
+		conn.Ping([]byte("p"))
+		writer.Write([]byte("two"))
+		writer.Close()
+	})
+	client.Write([]byte(handshake + "\r\n"))
+	reader := bufio.NewReader(client)
+	readHandshake(t, reader)
+
+	expected := []frame{
+		{b0: 0x01, payload: []byte("one ")},
+		{b0: 0x89, payload: []byte("p")},
+		{b0: 0x00, payload: []byte("two")},
+		{b0: 0x80, payload: []byte{}},
+		{b0: 0x88, payload: []byte{0x03, 0xe8}},
+	}
+	for _, want := range expected {
+		got := readFrame(t, reader)
+		if got.b0 != want.b0 || !bytes.Equal(got.payload, want.payload) {
+			t.Errorf("expected frame %x %q, got %x %q", want.b0, want.payload, got.b0, got.payload)
+		}
+	}
+}